@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"github.com/montanaflynn/stats"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"math"
+	"strconv"
+)
+
+const (
+	// madScale scales the median absolute deviation to be a consistent estimator of the standard deviation of
+	// normally distributed values
+	madScale = 1.4826
+
+	// targetColumn contains the name of the stream or logical server in reports combining several targets
+	targetColumn = "target"
+)
+
+// anomalyColumns are the columns of the anomalies report
+var anomalyColumns = []string{targetColumn, timeColumn, "column", "value", "expected", "lower", "upper", "score"}
+
+// anomalyScore is the result of scoring a value against its seasonal history
+type anomalyScore struct {
+	expected  float64
+	lower     float64
+	upper     float64
+	score     float64
+	anomalous bool
+}
+
+// anomalies lists the time buckets of the report period whose throughput deviates from the seasonal baseline. The
+// baseline of a time bucket is the same time bucket (i.e. same hour of the day, and day of the week) during the past
+// weeks. Time buckets are scored using their robust z-score (distance from the baseline median in scaled median
+// absolute deviations), and the time buckets scoring beyond the sensitivity are anomalous
+func anomalies(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	bucket, err := chooseTimeBucket(context.String("group-by"))
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid group-by %s", context.String("group-by")), errorExitCode)
+	}
+
+	weeks := context.Int("weeks")
+	sensitivity := context.Float64("sensitivity")
+
+	targets, err := resolveThroughputTargets(context)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	records := [][]string{anomalyColumns}
+	report := &Report{name: "Throughput Anomalies"}
+
+	for _, target := range targets {
+		period, err := resolveReportPeriod(context, target.cluster)
+
+		if err != nil {
+			return cli.Exit(err.Error(), errorExitCode)
+		}
+
+		if len(report.headers) == 0 {
+			report.AddHeader("Period", period.periodLabel())
+			report.AddHeader("Time Zone", period.timeZoneLabel())
+			report.AddHeader("History", fmt.Sprintf("%d weeks", weeks))
+			report.AddHeader("Sensitivity", strconv.FormatFloat(sensitivity, 'f', -1, 64))
+		}
+
+		// A single query covers the report period and its history
+		historyPeriod := *period
+		historyPeriod.start = period.start.AddDate(0, 0, -7*weeks)
+
+		s.Prefix = fmt.Sprintf("%s Throughput Anomalies ", target.name)
+		s.Start()
+
+		history, err := target.throughputReport(context, &historyPeriod, bucket)
+
+		s.Stop()
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"command": "anomalies",
+				"target":  target.name,
+				"error":   err,
+			}).Error("Generating throughput history")
+
+			continue
+		}
+
+		records = append(records, findAnomalies(target.name, history, bucket, period, weeks, sensitivity)...)
+	}
+
+	report.AddHeader("Anomalies", strconv.Itoa(len(records)-1))
+	report.defaultTable = &ResultSet{
+		columnsNames: anomalyColumns,
+		columnsDataTypes: map[string]series.Type{targetColumn: series.String, timeColumn: series.String,
+			"column": series.String, "value": series.Float, "expected": series.Float, "lower": series.Float,
+			"upper": series.Float, "score": series.Float},
+	}
+
+	if len(records) > 1 {
+		report.defaultTable.data = dataframe.LoadRecords(records,
+			dataframe.WithTypes(report.defaultTable.columnsDataTypes))
+	}
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// findAnomalies scores every numeric value of the time buckets of the period against the values of the same time
+// buckets during the previous weeks, and returns the anomalous values as records of the anomalies report
+func findAnomalies(targetName string, history *Report, bucket timeBucket, period *reportPeriod, weeks int,
+	sensitivity float64) [][]string {
+
+	var records [][]string
+	var numericColumns []string
+
+	table := history.GetDefaultTable()
+	rows := map[string]map[string]string{}
+
+	for _, row := range tableRows(table) {
+		rows[row[timeColumn]] = row
+	}
+
+	for _, columnName := range table.GetColumnsNames() {
+		if isNumericType(table.GetColumnsDataTypes()[columnName]) {
+			numericColumns = append(numericColumns, columnName)
+		}
+	}
+
+	for t := bucket.truncate(period.start); t.Before(period.end); t = bucket.next(t) {
+		label := bucket.label(t)
+		row, found := rows[label]
+
+		if !found {
+			continue
+		}
+
+		for _, columnName := range numericColumns {
+			var samples []float64
+
+			for week := 1; week <= weeks; week++ {
+				if sample, found := rows[bucket.label(t.AddDate(0, 0, -7*week))]; found {
+					value, _ := strconv.ParseFloat(sample[columnName], 64)
+					samples = append(samples, value)
+				}
+			}
+
+			value, _ := strconv.ParseFloat(row[columnName], 64)
+			score := scoreAnomaly(value, samples, sensitivity)
+
+			if score.anomalous {
+				records = append(records, []string{targetName, label, columnName, formatFloat(value),
+					strconv.FormatFloat(score.expected, 'f', 2, 64), strconv.FormatFloat(score.lower, 'f', 2, 64),
+					strconv.FormatFloat(score.upper, 'f', 2, 64), strconv.FormatFloat(score.score, 'f', 2, 64)})
+			}
+		}
+	}
+
+	return records
+}
+
+// scoreAnomaly scores the value against its history using the median and the median absolute deviation (MAD) of the
+// history. The expected range is the median plus or minus sensitivity scaled MADs, and values outside the range are
+// anomalous. Values without history are never anomalous
+func scoreAnomaly(value float64, history []float64, sensitivity float64) anomalyScore {
+	median, err := stats.Median(history)
+
+	if err != nil {
+		return anomalyScore{expected: value, lower: value, upper: value}
+	}
+
+	mad, _ := stats.MedianAbsoluteDeviationPopulation(history)
+	deviation := madScale * mad
+
+	score := anomalyScore{
+		expected: median,
+		lower:    math.Max(0, median-sensitivity*deviation),
+		upper:    median + sensitivity*deviation,
+	}
+
+	if deviation > 0 {
+		score.score = (value - median) / deviation
+	} else if value != median {
+		score.score = math.Copysign(math.Inf(1), value-median)
+	}
+
+	score.anomalous = value < score.lower || value > score.upper
+
+	return score
+}
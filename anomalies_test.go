@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScoreAnomaly(t *testing.T) {
+	history := []float64{100, 110, 90, 105}
+
+	if score := scoreAnomaly(102, history, 3.5); score.anomalous || score.expected != 102.5 {
+		t.Errorf("Expecting 102 to be normal with expected value 102.5, but got %+v", score)
+	}
+
+	if score := scoreAnomaly(20, history, 3.5); !score.anomalous || score.score >= 0 {
+		t.Errorf("Expecting 20 to be anomalous with negative score, but got %+v", score)
+	}
+
+	if score := scoreAnomaly(5, []float64{0, 0, 0}, 3.5); !score.anomalous || !math.IsInf(score.score, 1) {
+		t.Errorf("Expecting 5 to be anomalous with infinite score, but got %+v", score)
+	}
+
+	if score := scoreAnomaly(5, nil, 3.5); score.anomalous {
+		t.Errorf("Expecting value without history to be normal, but got %+v", score)
+	}
+}
+
+func TestFindAnomalies(t *testing.T) {
+	types := map[string]series.Type{"time": series.String, "input_cdrs": series.Int}
+
+	history := &Report{defaultTable: &ResultSet{columnsNames: []string{"time", "input_cdrs"}, columnsDataTypes: types}}
+	history.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs"},
+		{"2019031110", "100"},
+		{"2019031810", "104"},
+		{"2019032510", "2"},
+		{"2019032511", "100"},
+	}, dataframe.WithTypes(types))
+
+	bucket, _ := chooseTimeBucket("hour")
+	period := &reportPeriod{
+		start: time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC),
+		end:   time.Date(2019, 3, 25, 11, 59, 59, 0, time.UTC),
+	}
+
+	records := findAnomalies("Test Stream", history, bucket, period, 2, 3.5)
+	expected := [][]string{{"Test Stream", "2019032510", "input_cdrs", "2", "102.00", "91.62", "112.38", "-33.72"}}
+
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+}
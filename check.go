@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Monitoring plugin states, the values are the exit codes expected by Nagios and Icinga
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+const (
+	// defaultCheckWindow is the time window of the rules which do not specify it
+	defaultCheckWindow = "1h"
+
+	// checkPluginName prefixes the plugin output
+	checkPluginName = "EMMSTATS"
+)
+
+// checkStateNames contains the names of the plugin states, indexed by exit code
+var checkStateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkSeverity ranks the plugin states, the state of the check is the most severe state of its rules
+var checkSeverity = map[int]int{checkOK: 0, checkUnknown: 1, checkWarning: 2, checkCritical: 3}
+
+// thresholdExpression matches the conditions of the rules (e.g. "< 1000", ">= 2.5", "== 0")
+var thresholdExpression = regexp.MustCompile(`^(<=|>=|==|!=|<|>)\s*(-?\d+(\.\d+)?)$`)
+
+// threshold is a parsed condition of a rule, the rule is in the state of the threshold when the condition is true
+type threshold struct {
+	operator string
+	value    float64
+}
+
+// checkResult is the result of evaluating a rule
+type checkResult struct {
+	rule    *CheckRule
+	value   float64
+	state   int
+	message string
+}
+
+// check evaluates the rules of the stream or the logical server over the latest time windows, prints the monitoring
+// plugin output with perfdata, and exits with the plugin state
+func check(context *cli.Context) error {
+
+	target, err := resolveThroughputTarget(context)
+
+	if err != nil {
+		return checkExit(context, fmt.Sprintf("%s UNKNOWN - %s", checkPluginName, err), checkUnknown)
+	}
+
+	rules := target.logicalServer.Checks
+
+	if target.stream != nil {
+		rules = target.stream.Checks
+	}
+
+	if len(rules) == 0 {
+		return checkExit(context, fmt.Sprintf("%s UNKNOWN - No checks are defined for %s", checkPluginName,
+			target.name), checkUnknown)
+	}
+
+	// A single query covers the longest window of the rules
+	longestWindow := time.Duration(0)
+
+	for _, rule := range rules {
+		window, err := rule.window()
+
+		if err != nil {
+			return checkExit(context, fmt.Sprintf("%s UNKNOWN - %s", checkPluginName, err), checkUnknown)
+		}
+
+		if window > longestWindow {
+			longestWindow = window
+		}
+	}
+
+	period, err := resolveReportPeriod(context, target.cluster)
+
+	if err != nil {
+		return checkExit(context, fmt.Sprintf("%s UNKNOWN - %s", checkPluginName, err), checkUnknown)
+	}
+
+	bucket, _ := chooseTimeBucket("minute")
+	location, _ := loadTimeZone(period.timeZone)
+
+	period.end = time.Now().In(location).Truncate(time.Second)
+	period.start = bucket.truncate(period.end.Add(-longestWindow))
+
+	report, err := target.throughputReport(context, period, bucket)
+
+	if err != nil {
+		return checkExit(context, fmt.Sprintf("%s UNKNOWN - %s", checkPluginName, err), checkUnknown)
+	}
+
+	var results []checkResult
+
+	rows := tableRows(report.GetDefaultTable())
+
+	for _, rule := range rules {
+		window, _ := rule.window()
+		since := bucket.label(bucket.truncate(period.end.Add(-window)))
+
+		results = append(results, evaluateCheckRule(rule, rows, since))
+	}
+
+	output, state := checkOutput(target.name, results)
+
+	if context.Bool("notify") {
+		var attachment bytes.Buffer
+
+		report.Write(&attachment, htmlFileFormat, nil, report.GetDefaultTable())
+
+		if err = notifyAlerts(emmConfig.Notifications, target.name, results, attachment.Bytes()); err != nil {
+			logger.WithFields(logrus.Fields{
+				"command": "check",
+				"target":  target.name,
+				"error":   err,
+			}).Error("Notifying alerts")
+		}
+	}
+
+	return checkExit(context, output, state)
+}
+
+// checkExit prints the plugin output and exits with the plugin state. The output is printed to the standard output,
+// as expected by monitoring systems, instead of the standard error used for the errors of the other commands
+func checkExit(context *cli.Context, output string, state int) error {
+	fmt.Fprintln(context.App.Writer, output)
+
+	if state == checkOK {
+		return nil
+	}
+
+	return cli.Exit("", state)
+}
+
+// window returns the time window of the rule
+func (r CheckRule) window() (time.Duration, error) {
+	window := r.windowExpression()
+	duration, err := time.ParseDuration(window)
+
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid window %s of %s check", window, r.label())
+	}
+
+	return duration, nil
+}
+
+// windowExpression returns the time window of the rule as written in the rules (e.g. 30m, 1h)
+func (r CheckRule) windowExpression() string {
+	if len(r.Window) == 0 {
+		return defaultCheckWindow
+	}
+
+	return r.Window
+}
+
+// label returns the name of the rule used in the plugin output and perfdata
+func (r CheckRule) label() string {
+	if len(r.Name) == 0 {
+		return r.Column
+	}
+
+	return r.Name
+}
+
+// evaluateCheckRule sums the column of the rule over the rows of the time buckets since the first bucket of the
+// window, and compares the sum with the critical and warning conditions. Stream reports prefix the column names with
+// total_, so the columns of the rules are matched with and without the prefix
+func evaluateCheckRule(rule *CheckRule, rows []map[string]string, since string) checkResult {
+	result := checkResult{rule: rule, state: checkUnknown}
+
+	warning, err := parseThreshold(rule.Warning)
+
+	if err != nil {
+		result.message = err.Error()
+		return result
+	}
+
+	critical, err := parseThreshold(rule.Critical)
+
+	if err != nil {
+		result.message = err.Error()
+		return result
+	}
+
+	for _, row := range rows {
+		if row[timeColumn] < since {
+			continue
+		}
+
+		value, found := row[rule.Column]
+
+		if !found {
+			value, found = row["total_"+rule.Column]
+		}
+
+		if !found {
+			result.message = fmt.Sprintf("unknown column %s", rule.Column)
+			return result
+		}
+
+		number, _ := strconv.ParseFloat(value, 64)
+		result.value += number
+	}
+
+	condition := ""
+
+	switch {
+	case critical.matches(result.value):
+		result.state, condition = checkCritical, fmt.Sprintf(" %s", critical)
+	case warning.matches(result.value):
+		result.state, condition = checkWarning, fmt.Sprintf(" %s", warning)
+	default:
+		result.state = checkOK
+	}
+
+	result.message = fmt.Sprintf("%s %s%s in last %s", rule.Column, formatFloat(result.value), condition,
+		rule.windowExpression())
+
+	return result
+}
+
+// checkOutput creates the plugin output of the results, and returns it with the most severe state of the results.
+// Results which are not OK are listed first
+func checkOutput(targetName string, results []checkResult) (string, int) {
+	var problems, others, perfdata []string
+
+	state := checkOK
+
+	for _, result := range results {
+		line := fmt.Sprintf("%s %s (%s)", result.rule.label(), checkStateNames[result.state], result.message)
+
+		if result.state == checkOK {
+			others = append(others, line)
+		} else {
+			problems = append(problems, line)
+		}
+
+		if checkSeverity[result.state] > checkSeverity[state] {
+			state = result.state
+		}
+
+		if result.state != checkUnknown {
+			warning, _ := parseThreshold(result.rule.Warning)
+			critical, _ := parseThreshold(result.rule.Critical)
+
+			perfdata = append(perfdata, fmt.Sprintf("'%s'=%s;%s;%s;0", result.rule.label(),
+				formatFloat(result.value), warning.nagiosRange(), critical.nagiosRange()))
+		}
+	}
+
+	output := fmt.Sprintf("%s %s - %s: %s", checkPluginName, checkStateNames[state], targetName,
+		strings.Join(append(problems, others...), ", "))
+
+	if len(perfdata) > 0 {
+		output += " | " + strings.Join(perfdata, " ")
+	}
+
+	return output, state
+}
+
+// parseThreshold parses a condition of a rule, an empty condition returns a nil threshold which never matches
+func parseThreshold(expression string) (*threshold, error) {
+	expression = strings.TrimSpace(expression)
+
+	if len(expression) == 0 {
+		return nil, nil
+	}
+
+	match := thresholdExpression.FindStringSubmatch(expression)
+
+	if match == nil {
+		return nil, fmt.Errorf("invalid condition %s", expression)
+	}
+
+	value, _ := strconv.ParseFloat(match[2], 64)
+
+	return &threshold{operator: match[1], value: value}, nil
+}
+
+// matches returns true if the value satisfies the condition
+func (t *threshold) matches(value float64) bool {
+	if t == nil {
+		return false
+	}
+
+	switch t.operator {
+	case "<":
+		return value < t.value
+	case "<=":
+		return value <= t.value
+	case ">":
+		return value > t.value
+	case ">=":
+		return value >= t.value
+	case "==":
+		return value == t.value
+	}
+
+	return value != t.value
+}
+
+// nagiosRange returns the equivalent of the condition in the range format of the plugins perfdata, where the alert is
+// raised when the value is outside the range. Conditions without an equivalent range return an empty range
+func (t *threshold) nagiosRange() string {
+	if t == nil {
+		return ""
+	}
+
+	switch t.operator {
+	case "<":
+		return fmt.Sprintf("%s:", formatFloat(t.value))
+	case ">":
+		return fmt.Sprintf("~:%s", formatFloat(t.value))
+	case "==":
+		return fmt.Sprintf("@%s:%s", formatFloat(t.value), formatFloat(t.value))
+	}
+
+	return ""
+}
+
+// String returns the condition as written in the rules
+func (t *threshold) String() string {
+	return fmt.Sprintf("%s %s", t.operator, formatFloat(t.value))
+}
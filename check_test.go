@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"gopkg.in/urfave/cli.v2"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      float64
+		matches    bool
+		nagios     string
+	}{
+		{"< 1000", 999, true, "1000:"},
+		{"<1000", 1000, false, "1000:"},
+		{">= 2.5", 2.5, true, ""},
+		{"> 10", 11, true, "~:10"},
+		{"== 0", 0, true, "@0:0"},
+		{"!= 0", 0, false, ""},
+	}
+
+	for _, test := range tests {
+		threshold, err := parseThreshold(test.expression)
+
+		if err != nil {
+			t.Errorf("Expecting %s to be parsed, but got %s", test.expression, err)
+			continue
+		}
+
+		if threshold.matches(test.value) != test.matches {
+			t.Errorf("Expecting %s matching %f to be %t", test.expression, test.value, test.matches)
+		}
+
+		if threshold.nagiosRange() != test.nagios {
+			t.Errorf("Expecting %s range %s, but got %s", test.expression, test.nagios, threshold.nagiosRange())
+		}
+	}
+
+	if threshold, err := parseThreshold(""); threshold != nil || err != nil || threshold.matches(0) {
+		t.Errorf("Expecting empty condition to never match")
+	}
+
+	if _, err := parseThreshold("about 10"); err == nil {
+		t.Errorf("Expecting error parsing invalid condition, but got nil")
+	}
+}
+
+func TestEvaluateCheckRule(t *testing.T) {
+	rows := []map[string]string{
+		{"time": "201903251000", "total_output_cdrs": "5000", "total_input_files": "1"},
+		{"time": "201903251030", "total_output_cdrs": "400", "total_input_files": "0"},
+		{"time": "201903251045", "total_output_cdrs": "300", "total_input_files": "0"},
+	}
+
+	rule := &CheckRule{Name: "output-cdrs", Column: "output_cdrs", Window: "30m", Warning: "< 5000",
+		Critical: "< 1000"}
+
+	if result := evaluateCheckRule(rule, rows, "201903251030"); result.state != checkCritical ||
+		result.value != 700 || result.message != "output_cdrs 700 < 1000 in last 30m" {
+		t.Errorf("Expecting CRITICAL with value 700, but got %+v", result)
+	}
+
+	if result := evaluateCheckRule(rule, rows, "201903251000"); result.state != checkOK || result.value != 5700 {
+		t.Errorf("Expecting OK with value 5700, but got %+v", result)
+	}
+
+	rule = &CheckRule{Column: "cpu"}
+
+	if result := evaluateCheckRule(rule, rows, "201903251000"); result.state != checkUnknown {
+		t.Errorf("Expecting UNKNOWN for unknown column, but got %+v", result)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	results := []checkResult{
+		{rule: &CheckRule{Column: "input_files", Warning: "== 0"}, value: 3, state: checkOK,
+			message: "input_files 3 in last 30m"},
+		{rule: &CheckRule{Name: "output-cdrs", Column: "output_cdrs", Warning: "< 5000", Critical: "< 1000"},
+			value: 700, state: checkCritical, message: "output_cdrs 700 < 1000 in last 1h"},
+	}
+
+	output, state := checkOutput("UAT_Test Stream", results)
+	expected := "EMMSTATS CRITICAL - UAT_Test Stream: output-cdrs CRITICAL (output_cdrs 700 < 1000 in last 1h), " +
+		"input_files OK (input_files 3 in last 30m) | 'input_files'=3;@0:0;;0 'output-cdrs'=700;5000:;1000:;0"
+
+	if state != checkCritical || output != expected {
+		t.Errorf("Expecting %s, but got %s (state %d)", expected, output, state)
+	}
+}
+
+// newTestContext returns the context of the command with the global options of the application, parsed from the
+// arguments. The output of the command is written to the writer
+func newTestContext(command *cli.Command, writer io.Writer, arguments ...string) *cli.Context {
+	app := CreateCliApp()
+	app.Writer = writer
+	set := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+
+	for _, option := range append(app.Flags, command.Flags...) {
+		option.Apply(set)
+	}
+
+	set.Parse(arguments)
+
+	return cli.NewContext(app, set, nil)
+}
+
+func TestCheck_QueryFailure(t *testing.T) {
+	logicalServer := failingLogicalServer("Failing2")
+	logicalServer.Checks = []*CheckRule{{Column: "total_input_files", Critical: "< 1"}}
+
+	emmConfig = &Config{Clusters: []*Cluster{{Name: "ryd2", LogicalServers: []*LogicalServer{logicalServer}}}}
+	defer func() { emmConfig = nil }()
+
+	var output bytes.Buffer
+	err := check(newTestContext(checkCommand, &output, "--cluster", "ryd2", "--lserver", "Failing2"))
+
+	if exitCoder, isExitCoder := err.(cli.ExitCoder); !isExitCoder || exitCoder.ExitCode() != checkUnknown {
+		t.Errorf("Expecting UNKNOWN exit code on query failure, but got %v", err)
+	}
+
+	if !strings.HasPrefix(output.String(), checkPluginName+" UNKNOWN - ") {
+		t.Errorf("Expecting UNKNOWN plugin output, but got %s", output.String())
+	}
+}
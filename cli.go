@@ -14,6 +14,8 @@ const (
 	csvFileFormat  = "csv"
 	xlsFileFormat  = "xls"
 	txtFileFormat  = "txt"
+	mdFileFormat   = "md"
+	htmlFileFormat = "html"
 )
 
 // Command to generate the Throughput (Files and CDRs) statistics, it could
@@ -27,6 +29,177 @@ var throughputCommand = &cli.Command{
 	Usage:   "Input/Output Files and CDRs statistics, cluster name is required",
 	Action:  throughput,
 	Before:  validateThroughputOptions,
+	Flags: []cli.Flag{
+		byServerFlag,
+		explainFlag,
+	},
+}
+
+// Command to compare the Throughput (Files and CDRs) statistics of the report period with a baseline period shifted
+// by an offset, for a single stream or a logical server
+var compareCommand = &cli.Command{
+	Name:   "compare",
+	Usage:  "Compare Input/Output Files and CDRs statistics with a baseline period, stream or cluster name is required",
+	Action: compare,
+	Before: validateCompareOptions,
+	Flags: []cli.Flag{
+		baselineFlag,
+		thresholdFlag,
+	},
+}
+
+// Command to list the time buckets whose throughput deviates from the seasonal baseline of the past weeks, for a
+// single stream, a logical server, or all the streams
+var anomaliesCommand = &cli.Command{
+	Name:   "anomalies",
+	Usage:  "Input/Output Files and CDRs anomalies compared with the same time of the past weeks, for all streams if no stream or logical server is specified",
+	Action: anomalies,
+	Before: validateAnomaliesOptions,
+	Flags: []cli.Flag{
+		weeksFlag,
+		sensitivityFlag,
+	},
+}
+
+// Command to project the throughput of the logical servers and clusters for the next months or weeks
+var forecastCommand = &cli.Command{
+	Name: "forecast",
+	Usage: "Input/Output Files, CDRs and Bytes forecast per logical server and cluster, for all clusters if no " +
+		"cluster is specified. History defaults to the last 25 whole months (105 whole weeks if grouped by week), " +
+		"and group-by to month",
+	Action: forecast,
+	Before: validateForecastOptions,
+	Flags: []cli.Flag{
+		periodsFlag,
+		confidenceFlag,
+	},
+}
+
+// Command to evaluate the check rules of a stream or a logical server as a Nagios/Icinga monitoring plugin
+var checkCommand = &cli.Command{
+	Name: "check",
+	Usage: "Evaluate the check rules of a stream or a logical server, and exit with the monitoring plugin state " +
+		"(0 OK, 1 WARNING, 2 CRITICAL, 3 UNKNOWN), stream or cluster name is required",
+	Action: check,
+	Before: validateCheckOptions,
+	Flags: []cli.Flag{
+		notifyFlag,
+	},
+}
+
+// Command to report the last time each collector and distributor of the streams processed a file
+var freshnessCommand = &cli.Command{
+	Name: "freshness",
+	Usage: "Last seen time of the collectors and distributors of all streams, or of the specified stream. Files are " +
+		"scanned in the period of the time options, or in a lookback window of 10 times the largest max silence " +
+		"(at least 7 days)",
+	Action: freshness,
+	Before: validateFreshnessOptions,
+	Flags: []cli.Flag{
+		maxSilenceFlag,
+	},
+}
+
+// Command to discover the collectors and distributors of a logical server, and propose the definitions of the streams
+// which are not defined in EMM configuration yet
+var discoverCommand = &cli.Command{
+	Name: "discover",
+	Usage: "Discover the collectors and distributors of a logical server, and propose stream definitions, logical " +
+		"server and cluster names are required. Period defaults to the last 30 days",
+	Action: discover,
+	Before: validateDiscoverOptions,
+	Flags: []cli.Flag{
+		prefixPartsFlag,
+		patternsFlag,
+		yamlFlag,
+		mergeFlag,
+	},
+}
+
+// Command to list the clusters, logical servers and streams defined in EMM configuration, the cluster, logical server
+// and stream options filter the listed items
+var listCommand = &cli.Command{
+	Name:  "list",
+	Usage: "List the clusters, logical servers or streams defined in EMM configuration, secrets are masked",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "clusters",
+			Usage:  "List the clusters matching the cluster option (glob pattern), with their logical servers count",
+			Action: listClusters,
+		},
+		{
+			Name:    "servers",
+			Aliases: []string{"lservers"},
+			Usage: "List the logical servers matching the cluster and logical server options (glob patterns), with " +
+				"their connection details",
+			Action: listServers,
+		},
+		{
+			Name: "streams",
+			Usage: "List the streams matching the stream options, or running in the logical servers matching the " +
+				"cluster and logical server options, with their nodes count",
+			Action: listStreams,
+		},
+	},
+}
+
+// Command to check the connectivity and the health of the logical servers databases before generating reports
+var pingCommand = &cli.Command{
+	Name:    "ping",
+	Aliases: []string{"doctor"},
+	Usage: "Connect concurrently to the logical servers matching the cluster and logical server options (all by " +
+		"default), or to the adhoc databases, and check their audittraillogentry table",
+	Action: ping,
+	Flags: []cli.Flag{
+		timeoutFlag,
+	},
+}
+
+// Command to manage EMM configuration
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Manage EMM configuration",
+	Subcommands: []*cli.Command{
+		{
+			Name: "encrypt",
+			Usage: "Encrypt the secret (read from the standard input if not specified) with the passphrase of " +
+				secretKeyEnvVar + " environment variable, the output is used as password in EMM configuration",
+			ArgsUsage: "[secret]",
+			Action:    configEncrypt,
+		},
+		{
+			Name: "show",
+			Usage: "Show EMM configuration file, or the effective configuration merged with its included files and " +
+				"the overlay of the environment, plaintext passwords are masked",
+			Action: configShow,
+			Flags: []cli.Flag{
+				mergedFlag,
+			},
+		},
+	},
+}
+
+// Command to run the jobs of the schedule defined in EMM configuration until interrupted
+var runSchedulerCommand = &cli.Command{
+	Name:   "run-scheduler",
+	Usage:  "Run the scheduled jobs defined in EMM configuration on their cron expressions",
+	Action: runScheduler,
+}
+
+// Command to run the named report profiles defined in EMM configuration, global options specified in the command line
+// override the profile values
+var reportCommand = &cli.Command{
+	Name:  "report",
+	Usage: "Run report profiles defined in EMM configuration",
+	Subcommands: []*cli.Command{
+		{
+			Name:            "run",
+			Usage:           "Run the report profile, arguments following the profile name are passed to its command",
+			ArgsUsage:       "<profile name> [command options]",
+			Action:          runReportProfile,
+			SkipFlagParsing: true,
+		},
+	},
 }
 
 // Command to generate CPU and Memory statistics as below:
@@ -74,35 +247,88 @@ var logicalServerGFlag = &cli.StringFlag{
 var outputFormatGFlag = &cli.StringFlag{
 	Name:    "format",
 	Aliases: []string{"fmt"},
-	Usage:   fmt.Sprintf("Output format of the report, valid values (%s, %s, %s)", txtFileFormat, csvFileFormat, xlsFileFormat),
-	Value:   txtFileFormat,
+	Usage: fmt.Sprintf("Output format of the report, valid values (%s, %s, %s, %s), %s is deprecated and written "+
+		"as %s", txtFileFormat, csvFileFormat, mdFileFormat, htmlFileFormat, xlsFileFormat, csvFileFormat),
+	Value: txtFileFormat,
 }
 
 var startTimeGFlag = &cli.StringFlag{
 	Name:    "start-time",
 	Aliases: []string{"sd"},
-	Usage:   "Start time of the report in the format YYMMDDHH24MISS",
-	Value:   "20190101000000",
+	Usage: "Start time of the report in the format YYYYMMDDHH24MISS, ISO-8601 (e.g. 2019-03-25T10:00:00), or " +
+		"relative to the current time (e.g. -7d, -24h, yesterday), 24 hours before end time if not specified",
 }
 
 var endTimeGFlag = &cli.StringFlag{
 	Name:    "end-time",
 	Aliases: []string{"ed"},
-	Usage:   "End time of the report in the format YYMMDDHH24MISS",
-	Value:   currentTime(),
+	Usage: "End time of the report (exclusive) in the same formats as start time, current time is used if not " +
+		"specified",
+}
+
+var rangeGFlag = &cli.StringFlag{
+	Name:    "range",
+	Aliases: []string{"r"},
+	Usage: fmt.Sprintf("Time range of the report, either a named range (last-24h, last-7d, today, yesterday, "+
+		"this-week, last-week, this-month, last-month, this-year, last-year) or two time expressions separated by "+
+		"'..' (e.g. -7d..now), default is %s", defaultRange),
+}
+
+var referenceTimeGFlag = &cli.StringFlag{
+	Name:    "reference-time",
+	Aliases: []string{"rf"},
+	Usage: "Time used instead of the current time to resolve relative time options (e.g. -7d, yesterday), in the " +
+		"same formats as start time",
+}
+
+var timeZoneGFlag = &cli.StringFlag{
+	Name:    "timezone",
+	Aliases: []string{"tz"},
+	Usage: "IANA name of the time zone used for the report time options and time buckets (e.g. Asia/Riyadh), the " +
+		"time zone of the cluster is used if not specified",
 }
 
 var groupByGFlag = &cli.StringFlag{
 	Name:    "group-by",
 	Aliases: []string{"gb"},
-	Usage:   "Time interval for grouping of the result, possible values are minute, hour, day, month",
-	Value:   "day",
+	Usage: "Time interval for grouping of the result, possible values are minute, hour, day, week, month, " +
+		"quarter, year, or a custom interval in minutes or hours (e.g. 5m, 15m, 4h)",
+	Value: "day",
+}
+
+var statsGFlag = &cli.StringFlag{
+	Name: "stats",
+	Usage: "Comma separated list of statistics displayed in the summary table, possible values are avg, min, max, " +
+		"sum, stddev, min_time, max_time, and percentiles (e.g. p50, p95, p99)",
+	Value: defaultSummaryStatistics,
 }
 
-var streamGFlag = &cli.StringFlag{
+var humanGFlag = &cli.BoolFlag{
+	Name:    "human",
+	Aliases: []string{"H"},
+	Usage: "Human readable numbers in txt, md and html outputs, bytes are scaled to kB/MB/GB/TB (1000 based) and counts " +
+		"get thousands separators. CSV output always contains raw values",
+	Value: false,
+}
+
+var localeGFlag = &cli.StringFlag{
+	Name:  "locale",
+	Usage: "Locale of the thousands and decimal separators in human readable mode (e.g. en, de, fr, de-CH)",
+	Value: defaultLocale,
+}
+
+var rateGFlag = &cli.StringFlag{
+	Name:    "rate",
+	Aliases: []string{"rt"},
+	Usage: "Add files, CDRs and MB rate columns derived from the duration of the time buckets, possible values " +
+		"are second, minute",
+}
+
+var streamGFlag = &cli.StringSliceFlag{
 	Name:    "stream",
 	Aliases: []string{"s"},
-	Usage:   "Name of the stream defined in YAML configuration file",
+	Usage: "Name of the stream defined in YAML configuration file, it can be repeated, and it accepts glob patterns " +
+		"(e.g. *_INPUT_CDRs), regular expressions between slashes (e.g. /^HW/), and stream groups (e.g. group:4G)",
 }
 
 var verboseGFlag = &cli.BoolFlag{
@@ -122,7 +348,14 @@ var configFileGFlag = &cli.StringFlag{
 	Name:    "config-file",
 	Aliases: []string{"cfg"},
 	Usage:   "Full path name of EMM YAML configuration file",
-	Value:   "emm-config.yaml",
+	Value:   defaultEMMConfigFile,
+}
+
+var environmentGFlag = &cli.StringFlag{
+	Name:    "environment",
+	Aliases: []string{"env"},
+	Usage:   "Environment of the overlay merged into EMM YAML configuration file (e.g. prod merges emm-config.prod.yaml)",
+	EnvVars: []string{"EMMSTATS_ENV"},
 }
 
 var outputDirGFlag = &cli.StringFlag{
@@ -132,6 +365,113 @@ var outputDirGFlag = &cli.StringFlag{
 	Value:   ".",
 }
 
+//######################### Throughput Command Flags ##################################
+var byServerFlag = &cli.BoolFlag{
+	Name:    "by-server",
+	Aliases: []string{"bs"},
+	Usage:   "Add the throughput of each logical server of the streams running in several logical servers",
+}
+
+var explainFlag = &cli.BoolFlag{
+	Name:  "explain",
+	Usage: "List the collectors and distributors matched by the names, ids and patterns of the streams in the period",
+}
+
+//######################### Discover Command Flags ##################################
+var prefixPartsFlag = &cli.IntFlag{
+	Name:    "prefix-parts",
+	Aliases: []string{"pp"},
+	Usage:   "Number of parts (separated by _ - . or space) of the node names prefixes grouping the proposed streams",
+	Value:   2,
+}
+
+var patternsFlag = &cli.BoolFlag{
+	Name:  "patterns",
+	Usage: "Propose the prefixes of the node names as patterns instead of listing the names",
+}
+
+var yamlFlag = &cli.BoolFlag{
+	Name:  "yaml",
+	Usage: "Print the proposed streams as YAML instead of the discovered nodes",
+}
+
+var mergeFlag = &cli.BoolFlag{
+	Name:  "merge",
+	Usage: "Merge the proposed streams into EMM configuration file, streams already defined are skipped",
+}
+
+//######################### Ping Command Flags ##################################
+var timeoutFlag = &cli.DurationFlag{
+	Name:    "timeout",
+	Aliases: []string{"to"},
+	Usage:   "Timeout of the connection and the queries of each database",
+	Value:   10 * time.Second,
+}
+
+//######################### Config Command Flags ##################################
+var mergedFlag = &cli.BoolFlag{
+	Name:  "merged",
+	Usage: "Show the effective configuration merged with the included files and the overlay of the environment",
+}
+
+//######################### Compare Command Flags ##################################
+var baselineFlag = &cli.StringFlag{
+	Name:    "baseline",
+	Aliases: []string{"b"},
+	Usage:   "Offset of the baseline period relative to the report period (e.g. -7d, -1w, -24h)",
+	Value:   "-7d",
+}
+
+var thresholdFlag = &cli.Float64Flag{
+	Name:    "threshold",
+	Aliases: []string{"th"},
+	Usage:   "Percentage change from the baseline beyond which the change is highlighted",
+	Value:   10,
+}
+
+//######################### Anomalies Command Flags ##################################
+var weeksFlag = &cli.IntFlag{
+	Name:    "weeks",
+	Aliases: []string{"w"},
+	Usage:   "Number of past weeks used as the seasonal baseline",
+	Value:   4,
+}
+
+var sensitivityFlag = &cli.Float64Flag{
+	Name:    "sensitivity",
+	Aliases: []string{"k"},
+	Usage:   "Number of scaled median absolute deviations from the baseline median beyond which a value is anomalous",
+	Value:   3.5,
+}
+
+//######################### Forecast Command Flags ##################################
+var periodsFlag = &cli.IntFlag{
+	Name:    "periods",
+	Aliases: []string{"n"},
+	Usage:   "Number of months or weeks to forecast",
+	Value:   12,
+}
+
+var confidenceFlag = &cli.Float64Flag{
+	Name:    "confidence",
+	Aliases: []string{"ci"},
+	Usage:   "Confidence percentage of the forecast intervals",
+	Value:   95,
+}
+
+//######################### Check Command Flags ##################################
+var notifyFlag = &cli.BoolFlag{
+	Name:  "notify",
+	Usage: "Send the alerts of the checks changing state to the notifiers defined in EMM configuration",
+}
+
+//######################### Freshness Command Flags ##################################
+var maxSilenceFlag = &cli.StringFlag{
+	Name:    "max-silence",
+	Aliases: []string{"ms"},
+	Usage:   "Max silence (e.g. 30m, 2h) of the streams which do not specify max-silence in EMM configuration",
+}
+
 //######################### Adhoc Database Global Flags ##################################
 var lsDatabaseGFlag = &cli.StringFlag{
 	Name:    "ls-dbname",
@@ -157,6 +497,27 @@ var dbPortGFlag = &cli.StringFlag{
 	Usage:   "Port of the adhoc database",
 }
 
+var sslModeGFlag = &cli.StringFlag{
+	Name:  "sslmode",
+	Usage: "SSL mode of the adhoc database connection, valid values (disable, require, verify-ca, verify-full)",
+	Value: defaultSSLMode,
+}
+
+var sslRootCertGFlag = &cli.StringFlag{
+	Name:  "sslrootcert",
+	Usage: "Root certificate file of the certificate authority verifying the adhoc database certificate",
+}
+
+var sslCertGFlag = &cli.StringFlag{
+	Name:  "sslcert",
+	Usage: "Client certificate file of the adhoc database connection",
+}
+
+var sslKeyGFlag = &cli.StringFlag{
+	Name:  "sslkey",
+	Usage: "Client key file of the adhoc database connection",
+}
+
 func CreateCliApp() *cli.App {
 	return &cli.App{
 		Name:     "emmstats",
@@ -172,27 +533,46 @@ func CreateCliApp() *cli.App {
 			streamGFlag,
 			verboseGFlag,
 			outputFormatGFlag,
+			humanGFlag,
+			localeGFlag,
 			startTimeGFlag,
 			endTimeGFlag,
+			rangeGFlag,
+			referenceTimeGFlag,
+			timeZoneGFlag,
 			lsDatabaseGFlag,
 			perfDatabaseGFlag,
 			dbIPGFlag,
 			dbPortGFlag,
+			sslModeGFlag,
+			sslRootCertGFlag,
+			sslCertGFlag,
+			sslKeyGFlag,
 			groupByGFlag,
+			statsGFlag,
+			rateGFlag,
 			outputFileGFlag,
 			outputDirGFlag,
+			configFileGFlag,
+			environmentGFlag,
 		},
 
 		Commands: []*cli.Command{
 			throughputCommand,
+			compareCommand,
+			anomaliesCommand,
+			forecastCommand,
+			checkCommand,
+			freshnessCommand,
+			discoverCommand,
+			listCommand,
+			pingCommand,
+			configCommand,
+			runSchedulerCommand,
+			reportCommand,
 			performanceCommand,
 		},
 		Before: initializeAndValidateGFlags,
 	}
 
 }
-
-// Returns current time formatted in the format YYYYMMDDHH24MISS
-func currentTime() string {
-	return time.Now().Format("20060102150405")
-}
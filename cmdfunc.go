@@ -5,6 +5,8 @@ import (
 	"github.com/briandowns/spinner"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/urfave/cli.v2"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -17,102 +19,142 @@ func throughput(context *cli.Context) error {
 
 	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq) // Build our new spinner
 
-	startTimeArg := context.String("start-time")
-	endTimeArg := context.String("end-time")
+	// Group by and statistics options are already validated while initializing the global options
+	bucket, _ := chooseTimeBucket(context.String("group-by"))
+	statistics, _ := parseSummaryStatistics(context.String("stats"))
 
-	// Logical server name, and cluster are required to generate throughput for specific logical server
-	logicalServerArg := context.String("lserver")
-	clusterArg := context.String("cluster")
-
-	// Stream name is required to generate throughput for specific stream
-	streamArg := context.String("stream")
-	//outputFileArg := context.String("output-file")
-	//outputFormatArg := context.String("format")
+	// Several streams are combined into a single report
+	if len(context.StringSlice("stream")) > 0 {
+		targets, err := resolveThroughputTargets(context)
 
-	// Generate throughput report for a stream
-	if len(streamArg) > 0 {
+		if err != nil {
+			return cli.Exit(err.Error(), errorExitCode)
+		}
 
-		stream := emmConfig.FindStream(streamArg)
+		if len(targets) > 1 {
+			s.Prefix = "Streams Throughput "
+			s.Start()
 
-		if stream.LogicalServer != nil {
+			report, err := streamsThroughputReport(context, targets, bucket)
 
-			s.Prefix = fmt.Sprintf("%s Stream Throughput ", stream.Name)
-			s.Start()
+			if err != nil {
+				s.Stop()
+				return cli.Exit(err.Error(), errorExitCode)
+			}
 
-			logicalServer := emmConfig.FindLogicalServer(stream.LogicalServer.Name, stream.LogicalServer.Cluster)
+			tables := []*ResultSet{report.GetDefaultTable()}
 
-			params := AudittrailLogEntryQueryParameters{
-				TimeFormat:   chooseGroupByFormat(context.String("group-by")),
-				StartTime:    startTimeArg,
-				EndTime:      endTimeArg,
-				InnodeNames:  stream.CollectorNames,
-				InnodeIds:    stream.CollectorIds,
-				OutnodeNames: stream.DistributorNames,
-				OutnodeIds:   stream.DistributorIds,
+			if context.Bool("explain") {
+				tables = append(tables, matchedNodesTable(context, targets, bucket))
 			}
 
-			query := parseTemplate("throughput", streamThroughputQueryTemplate, params)
+			s.Stop()
 
-			logger.WithFields(logrus.Fields{
-				"command": "throughput",
-				"stream":  stream.Name,
-				"query":   query,
-			}).Debug("Stream throughput query")
+			return outputReport(context, report, tables...)
+		}
+	}
 
-			session := CreateSession(logicalServer)
-			report := session.executeQuery(query)
+	target, err := resolveThroughputTarget(context)
 
-			s.Stop()
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
 
-				report.GetDefaultTable().WriteToConsole()
-				report.GetAvgTable().WriteToConsole()
-				report.GetMinTable().WriteToConsole()
-				report.GetMaxTable().WriteToConsole()
+	period, err := resolveReportPeriod(context, target.cluster)
 
-		} else {
-			logger.WithFields(logrus.Fields{
-				"command": "throughput",
-			}).Fatalf("%s stream is not assigned to any logical server", stream.Name)
-		}
-	} else if len(logicalServerArg) > 0 && len(clusterArg) > 0 {
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
 
-		// Generate throughput report for a complete logical server audittraillogentry
-		logicalServer := emmConfig.FindLogicalServer(logicalServerArg, clusterArg)
+	s.Prefix = fmt.Sprintf("%s Throughput ", target.name)
+	s.Start()
 
-		s.Prefix = fmt.Sprintf("%s Logical Server Throughput ", logicalServer.Name)
-		s.Start()
+	report, err := target.throughputReport(context, period, bucket)
 
-		params := AudittrailLogEntryQueryParameters{
-			TimeFormat: chooseGroupByFormat(context.String("group-by")),
-			StartTime:  startTimeArg,
-			EndTime:    endTimeArg,
-		}
+	if err != nil {
+		s.Stop()
+		return cli.Exit(err.Error(), errorExitCode)
+	}
 
-		query := parseTemplate("throughput", lsThroughputQueryTemplate, params)
+	tables := []*ResultSet{report.GetDefaultTable()}
 
-		logger.WithFields(logrus.Fields{
-			"command":        "throughput",
-			"logical_server": logicalServer.Name,
-			"query":          query,
-		}).Debug("Logical server throughput query")
+	if report.breakdownTable != nil {
+		tables = append(tables, report.breakdownTable)
+	}
 
-		session := CreateSession(logicalServer)
+	if context.Bool("explain") {
+		tables = append(tables, matchedNodesTable(context, []*throughputTarget{target}, bucket))
+	}
 
-		report := session.executeQuery(query)
+	s.Stop()
 
-		s.Stop()
+	return outputReport(context, report, append(tables, report.GetSummaryTable(statistics))...)
+}
 
-			report.GetDefaultTable().WriteToConsole()
-			report.GetAvgTable().WriteToConsole()
-			report.GetMinTable().WriteToConsole()
-			report.GetMaxTable().WriteToConsole()
+// completeThroughputReport fills the empty time buckets of a throughput report, and adds the report headers, and the
+// rate columns if the rate option is specified
+func completeThroughputReport(context *cli.Context, report *Report, period *reportPeriod, bucket timeBucket) {
+	report.FillGaps(period.bucketsSeries(bucket))
+	report.AddHeader("Period", period.periodLabel())
+	report.AddHeader("Time Zone", period.timeZoneLabel())
 
-	} else {
-		logger.WithFields(logrus.Fields{
-			"command": "throughput",
-		}).Fatalln("Invalid command options")
+	if rateArg := context.String("rate"); len(rateArg) > 0 {
+		// Rate option is already validated while initializing the global options
+		unit, _ := chooseRateUnit(rateArg)
+
+		rateColumns := report.AddRates(period.bucketsDurations(bucket), unit)
+		report.AddPeakRatesHeaders(rateColumns)
+	}
+}
+
+// outputReport writes the report tables to the output file if specified, otherwise to the console. If the format is
+// specified without an output file, the report is written to a file named after the report in the output directory
+func outputReport(context *cli.Context, report *Report, tables ...*ResultSet) error {
+	var numbers *numberFormat
+
+	if context.Bool("human") {
+		// Locale option is already validated while initializing the global options
+		numbers, _ = chooseNumberFormat(context.String("locale"))
+	}
+
+	outputFile := context.String("output-file")
+	outputFormat := strings.ToLower(context.String("format"))
+
+	if len(outputFile) == 0 && !context.IsSet("format") {
+		return report.Write(os.Stdout, txtFileFormat, numbers, tables...)
+	}
+
+	if len(outputFile) == 0 {
+		outputFile = fmt.Sprintf("%s_%s.%s", strings.Replace(report.name, " ", "_", -1),
+			time.Now().Format(timeFlagFormat), outputFormat)
 	}
 
+	if !filepath.IsAbs(outputFile) {
+		outputFile = filepath.Join(context.String("output-dir"), outputFile)
+	}
+
+	// Check the format before creating the file, to not leave an empty file behind
+	if !supportedFormat(outputFormat) {
+		return cli.Exit(fmt.Sprintf("Unsupported output format %s", outputFormat), errorExitCode)
+	}
+
+	file, err := os.Create(outputFile)
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot create output file %s: %s", outputFile, err), errorExitCode)
+	}
+
+	defer file.Close()
+
+	if err = report.Write(file, outputFormat, numbers, tables...); err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot write output file %s: %s", outputFile, err), errorExitCode)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"report": report.name,
+		"file":   outputFile,
+	}).Info("Report written to output file")
+
 	return nil
 }
 
@@ -136,7 +178,7 @@ func initializeAndValidateGFlags(context *cli.Context) error {
 
 	lserver := context.String("lserver")
 	cluster := context.String("cluster")
-	stream := context.String("stream")
+	stream := strings.Join(context.StringSlice("stream"), ",")
 
 	// Make sure Adhoc options are not combined with configuration file based options
 	if len(lsDbname) > 0 || len(pfDbname) > 0 {
@@ -161,6 +203,10 @@ func initializeAndValidateGFlags(context *cli.Context) error {
 		}
 	}
 
+	if sslMode := context.String("sslmode"); indexOf(sslModes, sslMode) < 0 {
+		return cli.Exit(fmt.Sprintf("Invalid sslmode %s", sslMode), errorExitCode)
+	}
+
 	// Stream and logical server information are exclusive, it is not possible to specify both, either specify
 	// logical server details (i.e. logical server, and cluster name). Or specify stream name only
 	if (len(lserver) > 0 || len(cluster) > 0) && len(stream) > 0 {
@@ -172,31 +218,84 @@ func initializeAndValidateGFlags(context *cli.Context) error {
 	// Validate date formats
 	startTime := context.String("start-time")
 	endTime := context.String("end-time")
+	timeRange := context.String("range")
+
+	if len(timeRange) > 0 && (len(startTime) > 0 || len(endTime) > 0) {
+		return cli.Exit("Cannot combine --range option with --start-time or --end-time options", errorExitCode)
+	}
+
+	if len(timeRange) > 0 {
+		if _, _, err := parseRangeExpression(timeRange, time.Now()); err != nil {
+			return cli.Exit(fmt.Sprintf("Invalid range %s", timeRange), errorExitCode)
+		}
+	}
 
 	if len(startTime) > 0 {
-		_, err := time.Parse(timeFlagFormat, startTime)
-		if err != nil {
+		if _, err := parseTimeExpression(startTime, time.Now()); err != nil {
 			return cli.Exit(fmt.Sprintf("Invalid start-time format %s", startTime), errorExitCode)
 		}
 	}
 
 	if len(endTime) > 0 {
-		_, err := time.Parse(timeFlagFormat, endTime)
-		if err != nil {
+		if _, err := parseTimeExpression(endTime, time.Now()); err != nil {
 			return cli.Exit(fmt.Sprintf("Invalid end-time format %s", endTime), errorExitCode)
 		}
 	}
 
+	if referenceTime := context.String("reference-time"); len(referenceTime) > 0 {
+		if _, err := parseTimeExpression(referenceTime, time.Now()); err != nil {
+			return cli.Exit(fmt.Sprintf("Invalid reference-time format %s", referenceTime), errorExitCode)
+		}
+	}
+
+	// Validate group by period
+	if _, err := chooseTimeBucket(context.String("group-by")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid group-by %s", context.String("group-by")), errorExitCode)
+	}
+
+	// Validate summary statistics
+	if _, err := parseSummaryStatistics(context.String("stats")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid stats %s", context.String("stats")), errorExitCode)
+	}
+
+	// Validate rate unit
+	if rate := context.String("rate"); len(rate) > 0 {
+		if _, err := chooseRateUnit(rate); err != nil {
+			return cli.Exit(fmt.Sprintf("Invalid rate %s", rate), errorExitCode)
+		}
+	}
+
+	// Validate locale of human readable numbers
+	if _, err := chooseNumberFormat(context.String("locale")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid locale %s", context.String("locale")), errorExitCode)
+	}
+
+	// Validate time zone
+	if _, err := loadTimeZone(context.String("timezone")); err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
 	// Validate output file format
 	outputFormat := context.String("format")
-	if len(outputFormat) > 0 && strings.ToLower(outputFormat) != csvFileFormat &&
-		strings.ToLower(outputFormat) != xlsFileFormat &&
-		strings.ToLower(outputFormat) != txtFileFormat {
+
+	// xls reports were never generated, the format is kept for the existing scripts and written as csv
+	if strings.ToLower(outputFormat) == xlsFileFormat {
+		logger.WithFields(logrus.Fields{
+			"format": outputFormat,
+		}).Warn("Output format xls is deprecated, the report is written as csv")
+
+		context.Set("format", csvFileFormat)
+	} else if len(outputFormat) > 0 && !supportedFormat(outputFormat) {
 		return cli.Exit(fmt.Sprintf("Invalid output format %s", outputFormat), errorExitCode)
 	}
 
 	// Parse EMM configuration file
-	emmConfig = parseEMMConfig()
+	var err error
+
+	if emmConfig, err = parseEMMConfig(configFileName(context), context.String("environment")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid EMM configuration file %s: %s", configFileName(context), err),
+			errorExitCode)
+	}
 
 	return nil
 }
@@ -208,7 +307,7 @@ func validateThroughputOptions(context *cli.Context) error {
 	cluster := context.String("cluster")
 
 	// Stream name is required to generate throughput for specific stream
-	stream := context.String("stream")
+	stream := strings.Join(context.StringSlice("stream"), ",")
 
 	// Stream and logical server information are exclusive, it is not possible to specify both, either specify
 	// logical server details (i.e. logical server, and cluster name). Or specify stream name only
@@ -220,6 +319,122 @@ func validateThroughputOptions(context *cli.Context) error {
 		return cli.Exit("Cluster name is missing", errorExitCode)
 	} else if len(lserver) == 0 && len(cluster) > 0 {
 		return cli.Exit("Logical server name is missing", errorExitCode)
+	} else if context.Bool("explain") && len(stream) == 0 {
+		return cli.Exit("Explain lists the nodes matched by streams, stream is required", errorExitCode)
+	}
+
+	return nil
+}
+
+func validateDiscoverOptions(context *cli.Context) error {
+
+	// Nodes are discovered in a single logical server
+	if len(context.StringSlice("stream")) > 0 {
+		return cli.Exit("Nodes are discovered per logical server and cluster, stream cannot be specified",
+			errorExitCode)
+	} else if len(context.String("lserver")) == 0 || len(context.String("cluster")) == 0 {
+		return cli.Exit("Logical server name, and cluster name are required", errorExitCode)
+	}
+
+	if context.Int("prefix-parts") < 1 {
+		return cli.Exit("Prefix parts must be at least 1", errorExitCode)
+	}
+
+	return nil
+}
+
+func validateCompareOptions(context *cli.Context) error {
+
+	if err := validateThroughputOptions(context); err != nil {
+		return err
+	}
+
+	if _, err := parseTimeOffset(context.String("baseline")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid baseline offset %s", context.String("baseline")), errorExitCode)
+	}
+
+	if context.Float64("threshold") < 0 {
+		return cli.Exit("Threshold cannot be negative", errorExitCode)
+	}
+
+	return nil
+}
+
+func validateAnomaliesOptions(context *cli.Context) error {
+
+	if err := validateThroughputOptions(context); err != nil {
+		return err
+	}
+
+	// Seasonal baselines are based on the same time of the day, so time buckets cannot be longer than a day
+	if bucket, _ := chooseTimeBucket(context.String("group-by")); bucket.interval == 0 &&
+		bucket.name != "minute" && bucket.name != "hour" && bucket.name != "day" {
+		return cli.Exit("Anomalies require group-by of a day or shorter", errorExitCode)
+	}
+
+	if context.Int("weeks") < 1 {
+		return cli.Exit("Number of weeks must be at least 1", errorExitCode)
+	}
+
+	if context.Float64("sensitivity") <= 0 {
+		return cli.Exit("Sensitivity must be positive", errorExitCode)
+	}
+
+	return nil
+}
+
+func validateForecastOptions(context *cli.Context) error {
+
+	// Forecasts are generated per logical server and cluster, logical server is optional
+	if len(context.StringSlice("stream")) > 0 {
+		return cli.Exit("Forecast is generated per logical server and cluster, stream cannot be specified",
+			errorExitCode)
+	} else if len(context.String("lserver")) > 0 && len(context.String("cluster")) == 0 {
+		return cli.Exit("Cluster name is missing", errorExitCode)
+	}
+
+	if groupBy := context.String("group-by"); context.IsSet("group-by") && groupBy != "week" && groupBy != "month" {
+		return cli.Exit("Forecast requires group-by of week or month", errorExitCode)
+	}
+
+	if context.Int("periods") < 1 {
+		return cli.Exit("Number of periods must be at least 1", errorExitCode)
+	}
+
+	if confidence := context.Float64("confidence"); confidence <= 0 || confidence >= 100 {
+		return cli.Exit("Confidence must be between 0 and 100", errorExitCode)
+	}
+
+	return nil
+}
+
+func validateCheckOptions(context *cli.Context) error {
+	var message string
+
+	if err := validateThroughputOptions(context); err != nil {
+		message = err.Error()
+	} else if len(context.StringSlice("stream")) == 0 && len(context.String("lserver")) == 0 {
+		message = "Either specify a stream, or logical server and cluster"
+	}
+
+	// Monitoring systems expect the plugin output in the standard output, and the unknown state for invalid options
+	if len(message) > 0 {
+		return checkExit(context, fmt.Sprintf("%s UNKNOWN - %s", checkPluginName, message), checkUnknown)
+	}
+
+	return nil
+}
+
+func validateFreshnessOptions(context *cli.Context) error {
+
+	// Freshness is reported for the nodes of the streams, either all of them or a single stream
+	if len(context.String("lserver")) > 0 || len(context.String("cluster")) > 0 {
+		return cli.Exit("Freshness is reported per stream, logical server and cluster cannot be specified",
+			errorExitCode)
+	}
+
+	if _, err := parseMaxSilence(context.String("max-silence")); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid max silence %s", context.String("max-silence")), errorExitCode)
 	}
 
 	return nil
@@ -231,7 +446,7 @@ func validateCdrsOptions(context *cli.Context) error {
 	cluster := context.String("cluster")
 
 	// Stream name is required to generate throughput for specific stream
-	stream := context.String("stream")
+	stream := strings.Join(context.StringSlice("stream"), ",")
 
 	// Stream and logical server information are exclusive, it is not possible to specify both, either specify
 	// logical server details (i.e. logical server, and cluster name). Or specify stream name only
@@ -256,7 +471,7 @@ func validateFilesOptions(context *cli.Context) error {
 	cluster := context.String("cluster")
 
 	// Stream name is required to generate throughput for specific stream
-	stream := context.String("stream")
+	stream := strings.Join(context.StringSlice("stream"), ",")
 
 	// Stream and logical server information are exclusive, it is not possible to specify both, either specify
 	// logical server details (i.e. logical server, and cluster name). Or specify stream name only
@@ -290,26 +505,3 @@ func validatePerformanceOptions(context *cli.Context) error {
 
 	return nil
 }
-
-func chooseGroupByFormat(groupByPeriod string) string {
-	var groupByFormat string
-
-	switch groupByPeriod {
-	case "month":
-		groupByFormat = month
-		break
-	case "day":
-		groupByFormat = day
-		break
-	case "hour":
-		groupByFormat = hour
-		break
-	case "minute":
-		groupByFormat = minute
-		break
-	default:
-		groupByFormat = day
-	}
-
-	return groupByFormat
-}
\ No newline at end of file
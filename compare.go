@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"gopkg.in/urfave/cli.v2"
+	"math"
+	"strconv"
+)
+
+const (
+	// baselineSuffix, deltaSuffix and deltaPercentSuffix are appended to the names of the compared columns
+	baselineSuffix     = "_baseline"
+	deltaSuffix        = "_delta"
+	deltaPercentSuffix = "_delta_pct"
+
+	// baselineTimeColumn contains the time bucket of the baseline aligned with the current time bucket
+	baselineTimeColumn = "baseline_time"
+)
+
+// compare reports the throughput of a stream or a logical server for the report period, compared with the throughput
+// of the same stream or logical server for a baseline period shifted by the baseline offset
+func compare(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	bucket, err := chooseTimeBucket(context.String("group-by"))
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid group-by %s", context.String("group-by")), errorExitCode)
+	}
+
+	offset, err := parseTimeOffset(context.String("baseline"))
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid baseline offset %s", context.String("baseline")), errorExitCode)
+	}
+
+	threshold := context.Float64("threshold")
+
+	target, err := resolveThroughputTarget(context)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	period, err := resolveReportPeriod(context, target.cluster)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	baselinePeriod := period.shift(offset)
+
+	s.Prefix = fmt.Sprintf("%s Throughput Comparison ", target.name)
+	s.Start()
+
+	current, err := target.throughputReport(context, period, bucket)
+
+	if err != nil {
+		s.Stop()
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	baseline, err := target.throughputReport(context, baselinePeriod, bucket)
+
+	s.Stop()
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	report := compareReports(current, baseline, alignBuckets(bucket, period, offset), threshold)
+	report.name = fmt.Sprintf("%s Throughput Comparison", target.name)
+	report.AddHeader("Period", period.periodLabel())
+	report.AddHeader("Baseline Period", baselinePeriod.periodLabel())
+	report.AddHeader("Time Zone", period.timeZoneLabel())
+	report.AddHeader("Threshold", fmt.Sprintf("%s%%", strconv.FormatFloat(threshold, 'f', -1, 64)))
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// alignBuckets maps the label of every time bucket of the period to the label of the same bucket shifted by the offset
+func alignBuckets(bucket timeBucket, period *reportPeriod, offset timeOffset) map[string]string {
+	aligned := map[string]string{}
+
+	for t := bucket.truncate(period.start); t.Before(period.end); t = bucket.next(t) {
+		if label := bucket.label(t); len(aligned[label]) == 0 {
+			aligned[label] = bucket.label(offset.apply(t))
+		}
+	}
+
+	return aligned
+}
+
+// compareReports creates a report containing, for each time bucket of the current report, the values of the aligned
+// time bucket of the baseline report, and the absolute and percentage deltas of each numeric column. Percentage deltas
+// beyond the threshold (in both directions) are highlighted
+func compareReports(current *Report, baseline *Report, aligned map[string]string, threshold float64) *Report {
+	var numericColumns []string
+
+	currentTable := current.GetDefaultTable()
+	columnsTypes := map[string]series.Type{timeColumn: series.String, baselineTimeColumn: series.String}
+	columns := []string{timeColumn, baselineTimeColumn}
+
+	for _, columnName := range currentTable.GetColumnsNames() {
+		columnType := currentTable.GetColumnsDataTypes()[columnName]
+
+		if !isNumericType(columnType) {
+			continue
+		}
+
+		numericColumns = append(numericColumns, columnName)
+		columns = append(columns, columnName, columnName+baselineSuffix, columnName+deltaSuffix,
+			columnName+deltaPercentSuffix)
+
+		columnsTypes[columnName] = columnType
+		columnsTypes[columnName+baselineSuffix] = columnType
+		columnsTypes[columnName+deltaSuffix] = columnType
+		columnsTypes[columnName+deltaPercentSuffix] = series.Float
+	}
+
+	comparison := &ResultSet{
+		columnsNames:     columns,
+		columnsDataTypes: columnsTypes,
+		highlights:       map[cell]bool{},
+		highlightCaption: fmt.Sprintf("! Change beyond %s%%", strconv.FormatFloat(threshold, 'f', -1, 64)),
+	}
+
+	report := &Report{defaultTable: comparison}
+	records := [][]string{columns}
+	baselineRows := tableRows(baseline.GetDefaultTable())
+
+	for _, row := range tableRows(currentTable) {
+		baselineTime := aligned[row[timeColumn]]
+		baselineRow := findRowByTime(baselineRows, baselineTime)
+		record := []string{row[timeColumn], baselineTime}
+
+		for _, columnName := range numericColumns {
+			value, _ := strconv.ParseFloat(row[columnName], 64)
+			baselineValue, _ := strconv.ParseFloat(baselineRow[columnName], 64)
+			delta := value - baselineValue
+			deltaPercent := math.NaN()
+
+			if baselineValue != 0 {
+				deltaPercent = delta / baselineValue * 100
+			}
+
+			if (baselineValue == 0 && value != 0) || math.Abs(deltaPercent) > threshold {
+				comparison.highlights[cell{row: len(records) - 1, column: len(record) + 3}] = true
+			}
+
+			record = append(record, formatFloat(value), formatFloat(baselineValue), formatFloat(delta),
+				strconv.FormatFloat(deltaPercent, 'f', 2, 64))
+		}
+
+		records = append(records, record)
+	}
+
+	if len(records) > 1 {
+		comparison.data = dataframe.LoadRecords(records, dataframe.WithTypes(columnsTypes))
+	}
+
+	return report
+}
+
+// tableRows returns the rows of the result set as maps of column names to values, in the order of the result set
+func tableRows(table *ResultSet) []map[string]string {
+	var rows []map[string]string
+
+	records := table.data.Records()
+
+	for _, record := range records[1:] {
+		row := map[string]string{}
+
+		for i, columnName := range records[0] {
+			row[columnName] = record[i]
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// findRowByTime returns the row of the time bucket, or an empty row if the time bucket is not found
+func findRowByTime(rows []map[string]string, bucketTime string) map[string]string {
+	for _, row := range rows {
+		if row[timeColumn] == bucketTime {
+			return row
+		}
+	}
+
+	return map[string]string{}
+}
+
+// formatFloat formats the value without trailing zeros
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareReports(t *testing.T) {
+	types := map[string]series.Type{"time": series.String, "input_cdrs": series.Int}
+
+	current := &Report{defaultTable: &ResultSet{columnsNames: []string{"time", "input_cdrs"}, columnsDataTypes: types}}
+	current.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs"},
+		{"20190325", "105"},
+		{"20190326", "50"},
+	}, dataframe.WithTypes(types))
+
+	baseline := &Report{defaultTable: &ResultSet{columnsNames: []string{"time", "input_cdrs"}, columnsDataTypes: types}}
+	baseline.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs"},
+		{"20190318", "100"},
+		{"20190319", "100"},
+	}, dataframe.WithTypes(types))
+
+	bucket, _ := chooseTimeBucket("day")
+	offset, _ := parseTimeOffset("-7d")
+	period := &reportPeriod{
+		start: time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC),
+		end:   time.Date(2019, 3, 26, 23, 59, 59, 0, time.UTC),
+	}
+
+	comparison := compareReports(current, baseline, alignBuckets(bucket, period, offset), 10).GetDefaultTable()
+
+	expected := [][]string{
+		{"time", "baseline_time", "input_cdrs", "input_cdrs_baseline", "input_cdrs_delta", "input_cdrs_delta_pct"},
+		{"20190325", "20190318", "105", "100", "5", "5.000000"},
+		{"20190326", "20190319", "50", "100", "-50", "-50.000000"},
+	}
+
+	if records := comparison.data.Records(); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+
+	if !reflect.DeepEqual(comparison.highlights, map[cell]bool{{row: 1, column: 5}: true}) {
+		t.Errorf("Expecting only the second percentage delta to be highlighted, but got %v", comparison.highlights)
+	}
+}
+
+func TestCompareReports_ZeroBaseline(t *testing.T) {
+	types := map[string]series.Type{"time": series.String, "input_cdrs": series.Int}
+
+	current := &Report{defaultTable: &ResultSet{columnsNames: []string{"time", "input_cdrs"}, columnsDataTypes: types}}
+	current.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs"},
+		{"20190325", "10"},
+		{"20190326", "0"},
+	}, dataframe.WithTypes(types))
+
+	baseline := &Report{defaultTable: &ResultSet{columnsNames: []string{"time", "input_cdrs"}, columnsDataTypes: types}}
+	baseline.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs"},
+		{"20190318", "0"},
+		{"20190319", "0"},
+	}, dataframe.WithTypes(types))
+
+	bucket, _ := chooseTimeBucket("day")
+	offset, _ := parseTimeOffset("-7d")
+	period := &reportPeriod{
+		start: time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC),
+		end:   time.Date(2019, 3, 26, 23, 59, 59, 0, time.UTC),
+	}
+
+	comparison := compareReports(current, baseline, alignBuckets(bucket, period, offset), 10).GetDefaultTable()
+
+	expected := [][]string{
+		{"time", "baseline_time", "input_cdrs", "input_cdrs_baseline", "input_cdrs_delta", "input_cdrs_delta_pct"},
+		{"20190325", "20190318", "10", "0", "10", "NA"},
+		{"20190326", "20190319", "0", "0", "0", "NA"},
+	}
+
+	if records := comparison.records(); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+
+	if !reflect.DeepEqual(comparison.highlights, map[cell]bool{{row: 0, column: 5}: true}) {
+		t.Errorf("Expecting only the change from a zero baseline to be highlighted, but got %v", comparison.highlights)
+	}
+}
@@ -1,45 +1,112 @@
 package main
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
 	"gopkg.in/yaml.v3"
-	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
 )
 
-// defaultEMMConfigFile contains the default name of the EMM YAML configuration file
-const defaultEMMConfigFile = "emm-config.yaml"
+const (
+	// defaultEMMConfigFile contains the default name of the EMM YAML configuration file
+	defaultEMMConfigFile = "emm-config.yaml"
+
+	// defaultDBPort is the port of the logical servers databases which do not specify it
+	defaultDBPort = "5432"
+
+	// defaultSSLMode is the SSL mode of the logical servers databases which do not specify it, connections are not
+	// encrypted by default
+	defaultSSLMode = "disable"
+
+	// streamGroupPrefix prefixes the stream patterns selecting the streams of a group (e.g. group:4G)
+	streamGroupPrefix = "group:"
+)
 
 // emmConfig contains the parsed EMM YAML configuration file
 var emmConfig *Config
 
-// Config represents all the modules and submodules of the EMM YAML configuration file
+// Config represents all the modules and submodules of the EMM YAML configuration file, merged with its included files
+// and the overlay of the environment (see loadMergedConfig)
 type Config struct {
-	Clusters []*Cluster `yaml:"clusters"`
-	Streams  []*Stream  `yaml:"configurations"`
+	Clusters      []*Cluster           `yaml:"clusters"`
+	Streams       []*Stream            `yaml:"configurations"`
+	Notifications *NotificationsConfig `yaml:"notifications"`
+	Schedule      *ScheduleConfig      `yaml:"schedule"`
+	Reports       []*ReportProfile     `yaml:"reports"`
 }
 
 //######################### Main Modules ##################################
 
 // Stream represents EMM business logic, it specifies the names of collectors and distributors to use in queries and
 // specifies the logical server where the stream is running. Name of stream is independent from the name of the business
-// logic used in production EMM. It is just a name
+// logic used in production EMM. It is just a name. Checks are the rules evaluated by the check command, and MaxSilence
+// is the longest time (e.g. 30m, 2h) the collectors and distributors of the stream can stay without processing files.
+// Group is the name of the stream group, used to select the streams of the group at once (e.g. --stream group:4G).
+// Streams load-balanced across several logical servers list them in LogicalServers instead of LogicalServer. Patterns
+// select the collectors and distributors by name using SQL LIKE patterns (e.g. 4G_LTE_%), or regular expressions
+// between slashes (e.g. /^toHW_.*_in_/), and Exclusions remove nodes matched by the patterns using the same syntax
 type Stream struct {
-	Name             string                 `yaml:"name"`
-	CollectorNames   []string               `yaml:"coll-names"`
-	DistributorNames []string               `yaml:"dist-names"`
-	CollectorIds     []string               `yaml:"coll-ids"`
-	DistributorIds   []string               `yaml:"dist-ids"`
-	LogicalServer    *AssignedLogicalServer `yaml:"assigned-logical-server"`
+	Name                  string                   `yaml:"name"`
+	Group                 string                   `yaml:"group,omitempty"`
+	CollectorNames        []string                 `yaml:"coll-names,omitempty"`
+	DistributorNames      []string                 `yaml:"dist-names,omitempty"`
+	CollectorIds          []string                 `yaml:"coll-ids,omitempty"`
+	DistributorIds        []string                 `yaml:"dist-ids,omitempty"`
+	CollectorPatterns     []string                 `yaml:"coll-patterns,omitempty"`
+	DistributorPatterns   []string                 `yaml:"dist-patterns,omitempty"`
+	CollectorExclusions   []string                 `yaml:"coll-exclude,omitempty"`
+	DistributorExclusions []string                 `yaml:"dist-exclude,omitempty"`
+	LogicalServer         *AssignedLogicalServer   `yaml:"assigned-logical-server,omitempty"`
+	LogicalServers        []*AssignedLogicalServer `yaml:"assigned-logical-servers,omitempty"`
+	Checks                []*CheckRule             `yaml:"checks,omitempty"`
+	MaxSilence            string                   `yaml:"max-silence,omitempty"`
 }
 
-// Cluster is the top-level modules which contains the definition of the logical servers
+// Cluster is the top-level modules which contains the definition of the logical servers. TimeZone is the IANA name of
+// the time zone of the timestamps stored in the databases of the cluster. Usernames and passwords may reference
+// environment variables (e.g. ${EMM_PASSWORD}), passwords may be encrypted by the config encrypt command (enc:...), or
+// read from the first line of the PasswordFile instead. SSL settings are the defaults of the logical servers: SSLMode
+// (disable, require, verify-ca or verify-full), the root certificate of the certificate authority verifying the server
+// certificate, and the client certificate and key. Databases of clusters reachable only through a bastion are
+// connected through the SSHTunnel
 type Cluster struct {
 	Name           string           `yaml:"name"`
 	Username       string           `yaml:"username"`
 	Password       string           `yaml:"password"`
+	PasswordFile   string           `yaml:"password-file"`
+	SSLMode        string           `yaml:"sslmode"`
+	SSLRootCert    string           `yaml:"sslrootcert"`
+	SSLCert        string           `yaml:"sslcert"`
+	SSLKey         string           `yaml:"sslkey"`
+	SSHTunnel      *SSHTunnelConfig `yaml:"ssh-tunnel"`
+	TimeZone       string           `yaml:"timezone"`
 	LogicalServers []*LogicalServer `yaml:"logical-servers"`
 }
 
+// NotificationsConfig is the top-level module of the alert notifications sent by the check command. StateFile keeps the
+// last notified state of every check, so that repeated alerts are sent again only after the RepeatInterval (e.g. 4h),
+// or never if it is not specified
+type NotificationsConfig struct {
+	StateFile      string            `yaml:"state-file"`
+	RepeatInterval string            `yaml:"repeat-interval"`
+	Notifiers      []*NotifierConfig `yaml:"notifiers"`
+}
+
+// ScheduleConfig is the top-level module of the jobs executed by the scheduler. LockFile prevents several schedulers
+// from running at the same time, StateFile keeps the last scheduled time of every job to catch up the runs missed while
+// the scheduler was stopped (up to the CatchUp age, e.g. 24h, 0 disables catch-up), and HistoryFile logs every run
+type ScheduleConfig struct {
+	LockFile    string          `yaml:"lock-file"`
+	StateFile   string          `yaml:"state-file"`
+	HistoryFile string          `yaml:"history-file"`
+	CatchUp     string          `yaml:"catch-up"`
+	Jobs        []*ScheduledJob `yaml:"jobs"`
+}
+
 //######################### Sub-Modules ##################################
 
 // AssignedLogicalServer is a sub-module used in definition of streams, it specifies the name of the logical server, and
@@ -50,14 +117,125 @@ type AssignedLogicalServer struct {
 }
 
 // LogicalServer is a sub-module used in the Cluster top-level module, it specifies all the properties of the logical
-// server
+// server. Capacity contains the maximum sustainable rates of the logical server, keyed by rate column name (e.g.
+// input_cdrs_per_sec, output_mb_per_sec). Checks are the rules evaluated by the check command for the complete
+// logical server. Credentials are specified as the ones of the clusters, and looked up in ~/.pgpass if neither the
+// logical server nor the cluster specify the password. SSL settings override the ones of the cluster
 type LogicalServer struct {
+	Name         string             `yaml:"name"`
+	IP           string             `yaml:"ip"`
+	Username     string             `yaml:"username"`
+	Password     string             `yaml:"password"`
+	PasswordFile string             `yaml:"password-file"`
+	Port         string             `yaml:"port"`
+	Database     string             `yaml:"database"`
+	SSLMode      string             `yaml:"sslmode"`
+	SSLRootCert  string             `yaml:"sslrootcert"`
+	SSLCert      string             `yaml:"sslcert"`
+	SSLKey       string             `yaml:"sslkey"`
+	Capacity     map[string]float64 `yaml:"capacity"`
+	Checks       []*CheckRule       `yaml:"checks"`
+	sshTunnel    *SSHTunnelConfig
+}
+
+// SSHTunnelConfig is a sub-module used in the Cluster top-level module, it specifies the bastion Host (host:port, the
+// port defaults to 22), the User and the private Key file authenticating to the bastion, and the KnownHosts file
+// verifying the bastion host key (~/.ssh/known_hosts by default)
+type SSHTunnelConfig struct {
+	Host       string `yaml:"host"`
+	User       string `yaml:"user"`
+	Key        string `yaml:"key"`
+	KnownHosts string `yaml:"known_hosts"`
+}
+
+// CheckRule is a sub-module used in the definition of streams and logical servers, it specifies the column summed over
+// the latest time window (e.g. 30m, 1h), and the warning and critical conditions of the sum (e.g. "< 1000", "== 0").
+// Name is used in the plugin output and perfdata, it defaults to the column name
+type CheckRule struct {
 	Name     string `yaml:"name"`
-	IP       string `yaml:"ip"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Port     string `yaml:"port"`
-	Database string `yaml:"database"`
+	Column   string `yaml:"column"`
+	Window   string `yaml:"window"`
+	Warning  string `yaml:"warning"`
+	Critical string `yaml:"critical"`
+}
+
+// NotifierConfig is a sub-module used in the Notifications top-level module, it specifies the type of the notifier
+// (webhook, smtp or syslog) and its properties:
+// - webhook: URL, and Format of the payload (slack, teams or json)
+// - smtp: Host, Port, Username, Password (resolved as the passwords of the clusters), From and To addresses
+// - syslog: Network (udp, tcp) and Address of the syslog daemon, the local daemon is used if not specified, and Tag
+type NotifierConfig struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	URL      string   `yaml:"url"`
+	Format   string   `yaml:"format"`
+	Host     string   `yaml:"host"`
+	Port     string   `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Network  string   `yaml:"network"`
+	Address  string   `yaml:"address"`
+	Tag      string   `yaml:"tag"`
+}
+
+// ReportOptions are the options of a report command shared by report profiles and scheduled jobs: the command, the
+// streams (names, patterns or groups) or the logical server and cluster, the range expression, group by, statistics,
+// time zone and rate, the output directory, and any additional arguments of the command (e.g. ["--threshold", "5"])
+type ReportOptions struct {
+	Command       string     `yaml:"command"`
+	Stream        stringList `yaml:"stream"`
+	LogicalServer string     `yaml:"lserver"`
+	Cluster       string     `yaml:"cluster"`
+	Range         string     `yaml:"range"`
+	GroupBy       string     `yaml:"group-by"`
+	Stats         string     `yaml:"stats"`
+	TimeZone      string     `yaml:"timezone"`
+	Rate          string     `yaml:"rate"`
+	OutputDir     string     `yaml:"output-dir"`
+	Args          []string   `yaml:"args"`
+}
+
+// stringList is a list of strings which can be written in the configuration as a single value (e.g. stream: UAT_Test)
+// or as a list (e.g. stream: [UAT_Test, "group:4G"])
+type stringList []string
+
+// UnmarshalYAML decodes a single value as a list of one value
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+
+	var values []string
+
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+
+	*l = values
+
+	return nil
+}
+
+// ReportProfile is a sub-module used in the Reports top-level module, it bundles the options of a report under a name,
+// with the output format and file, and the human readable mode
+type ReportProfile struct {
+	Name          string `yaml:"name"`
+	ReportOptions `yaml:",inline"`
+	Format        string `yaml:"format"`
+	OutputFile    string `yaml:"output-file"`
+	Human         bool   `yaml:"human"`
+}
+
+// ScheduledJob is a sub-module used in the Schedule top-level module, it specifies the report options executed on the
+// cron expression (e.g. "0 6 * * *"), and the output formats (one report per format) written to the output directory
+type ScheduledJob struct {
+	Name          string `yaml:"name"`
+	Cron          string `yaml:"cron"`
+	ReportOptions `yaml:",inline"`
+	Formats       []string `yaml:"formats"`
 }
 
 // Equals compares the current logical server with another logical server
@@ -65,7 +243,12 @@ func (l LogicalServer) Equals(another *LogicalServer) bool {
 	if l.Username == another.Username &&
 		l.IP == another.IP &&
 		l.Password == another.Password &&
-		l.Port == another.Port {
+		l.Port == another.Port &&
+		l.SSLMode == another.SSLMode &&
+		l.SSLRootCert == another.SSLRootCert &&
+		l.SSLCert == another.SSLCert &&
+		l.SSLKey == another.SSLKey &&
+		l.sshTunnel == another.sshTunnel {
 		return true
 	}
 
@@ -89,6 +272,108 @@ func (c Config) FindStream(streamName string) *Stream {
 	return nil
 }
 
+// AssignedLogicalServers returns the logical servers where the stream is running, the single logical server syntax
+// and the list syntax can be combined, logical servers listed twice are returned once
+func (s *Stream) AssignedLogicalServers() []*AssignedLogicalServer {
+	var servers []*AssignedLogicalServer
+
+	for _, server := range append([]*AssignedLogicalServer{s.LogicalServer}, s.LogicalServers...) {
+		if server == nil {
+			continue
+		}
+
+		duplicate := false
+
+		for _, existing := range servers {
+			duplicate = duplicate || (existing.Name == server.Name && existing.Cluster == server.Cluster)
+		}
+
+		if !duplicate {
+			servers = append(servers, server)
+		}
+	}
+
+	return servers
+}
+
+// FindLogicalServerStreams returns the streams running in the logical server of the cluster
+func (c Config) FindLogicalServerStreams(logicalServerName string, clusterName string) []*Stream {
+	var streams []*Stream
+
+	for _, stream := range c.Streams {
+		for _, server := range stream.AssignedLogicalServers() {
+			if server.Name == logicalServerName && server.Cluster == clusterName {
+				streams = append(streams, stream)
+				break
+			}
+		}
+	}
+
+	return streams
+}
+
+// FindStreams returns the streams matching any of the patterns, in the order of the configuration file. Patterns are
+// stream names, glob patterns (e.g. *_INPUT_CDRs), regular expressions between slashes (e.g. /^HW.*CDRs$/), or stream
+// groups (e.g. group:4G). Patterns which do not match any stream are reported as errors
+func (c Config) FindStreams(patterns []string) ([]*Stream, error) {
+	var streams []*Stream
+
+	matched := map[*Stream]bool{}
+
+	for _, pattern := range patterns {
+		found := false
+
+		for _, stream := range c.Streams {
+			matches, err := matchStream(pattern, stream)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if matches {
+				found = true
+				matched[stream] = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s does not match any stream defined in EMM configuration", pattern)
+		}
+	}
+
+	for _, stream := range c.Streams {
+		if matched[stream] {
+			streams = append(streams, stream)
+		}
+	}
+
+	return streams, nil
+}
+
+// matchStream returns true if the stream matches the pattern
+func matchStream(pattern string, stream *Stream) (bool, error) {
+	switch {
+	case strings.HasPrefix(pattern, streamGroupPrefix):
+		return stream.Group == strings.TrimPrefix(pattern, streamGroupPrefix), nil
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+
+		if err != nil {
+			return false, fmt.Errorf("invalid stream regular expression %s", pattern)
+		}
+
+		return expression.MatchString(stream.Name), nil
+	}
+
+	matches, err := path.Match(pattern, stream.Name)
+
+	if err != nil {
+		return false, fmt.Errorf("invalid stream pattern %s", pattern)
+	}
+
+	return matches, nil
+}
+
 // findLogicalServer searches for a logical server in EMM configuration using logical server name, and cluster name
 func (c Config) FindLogicalServer(logicalServerName string, clusterName string) *LogicalServer {
 
@@ -110,6 +395,22 @@ func (c Config) FindLogicalServer(logicalServerName string, clusterName string)
 	return nil
 }
 
+// FindReportProfile searches for a report profile in EMM configuration using the profile name
+func (c Config) FindReportProfile(profileName string) *ReportProfile {
+
+	logger.WithFields(logrus.Fields{
+		"report-profile": profileName,
+	}).Debug("Searching for report profile in EMM configuration")
+
+	for _, profile := range c.Reports {
+		if profile.Name == profileName {
+			return profile
+		}
+	}
+
+	return nil
+}
+
 // findCluster searches for a cluster definition in EMM configuration file using cluster name
 func (c Config) FindCluster(clusterName string) *Cluster {
 
@@ -126,20 +427,37 @@ func (c Config) FindCluster(clusterName string) *Cluster {
 	return nil
 }
 
-// parseEMMConfig reads the EMM YAML configuration file and creates a construct with all the modules and submodules
-// defined in the configuration file
-func parseEMMConfig() *Config {
+// configFileName returns the EMM configuration file name specified by the config-file option, or the default file name
+// when the option is not available or empty
+func configFileName(context *cli.Context) string {
+	if fileName := context.String("config-file"); len(fileName) > 0 {
+		return fileName
+	}
+
+	return defaultEMMConfigFile
+}
+
+// parseEMMConfig reads the EMM YAML configuration file, merged with its included files and the overlay of the
+// environment if specified, and creates a construct with all the modules and submodules defined in the configuration.
+// Secrets which cannot be resolved are returned as errors naming the cluster, the logical server or the notifier
+func parseEMMConfig(fileName string, environment string) (*Config, error) {
 
 	logger.Debug("Reading EMM configuration file")
 
-	configFile, err := ioutil.ReadFile(defaultEMMConfigFile)
+	document, err := loadMergedConfig(fileName, environment)
+
+	if err != nil && !os.IsNotExist(err) {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Could not load EMM configuration file")
+	}
 
 	if err == nil {
 		var emmConfig Config
 
 		logger.Debug("Parsing the configuration file")
 
-		err = yaml.Unmarshal(configFile, &emmConfig)
+		err = document.Decode(&emmConfig)
 
 		if err != nil {
 
@@ -147,10 +465,62 @@ func parseEMMConfig() *Config {
 				"error": err,
 			}).Error("Could not parse EMM configuration file successfully")
 
+		} else if err = emmConfig.resolveSecrets(); err != nil {
+			return nil, fmt.Errorf("cannot resolve secrets of %s", err)
 		} else {
-			return &emmConfig
+			emmConfig.resolveDefaults()
+
+			if err = emmConfig.resolvePgpass(); err != nil {
+				return nil, fmt.Errorf("cannot look up PostgreSQL password file: %s", err)
+			}
+
+			return &emmConfig, nil
 		}
 	}
 
-	return nil
+	return nil, nil
+}
+
+// resolveDefaults sets the connection details which are not specified by the logical servers, the username, the
+// password and the SSL settings default to the ones of the cluster, the port defaults to the PostgreSQL port, and the
+// SSL mode to disable. Certificates paths may start with the home directory (~/). Logical servers share the SSH tunnel
+// of their cluster
+func (c *Config) resolveDefaults() {
+	for _, cluster := range c.Clusters {
+		for _, logicalServer := range cluster.LogicalServers {
+			if len(logicalServer.Username) == 0 {
+				logicalServer.Username = cluster.Username
+			}
+
+			if len(logicalServer.Password) == 0 {
+				logicalServer.Password = cluster.Password
+			}
+
+			if len(logicalServer.Port) == 0 {
+				logicalServer.Port = defaultDBPort
+			}
+
+			for _, setting := range []struct {
+				value        *string
+				clusterValue string
+			}{
+				{&logicalServer.SSLMode, cluster.SSLMode},
+				{&logicalServer.SSLRootCert, expandHome(cluster.SSLRootCert)},
+				{&logicalServer.SSLCert, expandHome(cluster.SSLCert)},
+				{&logicalServer.SSLKey, expandHome(cluster.SSLKey)},
+			} {
+				if len(*setting.value) == 0 {
+					*setting.value = setting.clusterValue
+				} else {
+					*setting.value = expandHome(*setting.value)
+				}
+			}
+
+			if len(logicalServer.SSLMode) == 0 {
+				logicalServer.SSLMode = defaultSSLMode
+			}
+
+			logicalServer.sshTunnel = cluster.SSHTunnel
+		}
+	}
 }
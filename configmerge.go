@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/urfave/cli.v2"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// includeKey is the key of the files included by EMM configuration files, a file name or a list of file names
+	includeKey = "include"
+
+	// nameKey is the key identifying the items of the lists merged item by item (e.g. clusters, streams)
+	nameKey = "name"
+
+	// passwordKey is the key of the passwords masked by the config show command
+	passwordKey = "password"
+)
+
+// loadMergedConfig loads the EMM configuration file with its included files, and merges the overlay of the
+// environment (e.g. emm-config.prod.yaml for prod environment) if specified. Merge rules are deterministic:
+// - included files are merged in the order of the include list (files of directories and glob patterns in name
+// order), then the content of the including file, then the overlay, so that later values override earlier ones
+// - mappings are merged key by key
+// - lists of items having a name (e.g. clusters, logical servers, streams, reports) are merged item by item, items of
+// the same name are merged, and new items are appended
+// - other lists and values are replaced
+func loadMergedConfig(fileName string, environment string) (*yaml.Node, error) {
+	document, err := loadConfigFile(fileName, nil)
+
+	if err != nil || len(environment) == 0 {
+		return document, err
+	}
+
+	overlay, err := loadConfigFile(overlayFileName(fileName, environment), nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot load %s environment overlay: %s", environment, err)
+	}
+
+	mergeMappings(document, overlay)
+
+	return document, nil
+}
+
+// overlayFileName returns the name of the overlay of the environment, the environment is inserted before the extension
+// of the configuration file (e.g. emm-config.prod.yaml)
+func overlayFileName(fileName string, environment string) string {
+	extension := filepath.Ext(fileName)
+
+	return strings.TrimSuffix(fileName, extension) + "." + environment + extension
+}
+
+// loadConfigFile returns the mapping of the configuration file merged with its included files. Including files are
+// tracked to report include cycles
+func loadConfigFile(fileName string, including []string) (*yaml.Node, error) {
+	absolute, err := filepath.Abs(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if indexOf(including, absolute) >= 0 {
+		return nil, fmt.Errorf("include cycle %s", strings.Join(append(including, absolute), " -> "))
+	}
+
+	content, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseMapping(content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := includedFiles(root, filepath.Dir(fileName))
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	for _, included := range includes {
+		document, err := loadConfigFile(included, append(append([]string{}, including...), absolute))
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", included, err)
+		}
+
+		mergeMappings(merged, document)
+	}
+
+	mergeMappings(merged, root)
+
+	return merged, nil
+}
+
+// parseMapping parses the YAML content, and returns its top-level mapping. Empty content is an empty mapping
+func parseMapping(content []byte) (*yaml.Node, error) {
+	var document yaml.Node
+
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	if document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("EMM configuration file is not a YAML mapping")
+	}
+
+	return document.Content[0], nil
+}
+
+// includedFiles removes the include key from the mapping, and returns the included files. Relative names are relative
+// to the directory of the including file, directories include their YAML files, and glob patterns include the matching
+// files, in name order
+func includedFiles(mapping *yaml.Node, directory string) ([]string, error) {
+	index := mappingIndex(mapping, includeKey)
+
+	if index < 0 {
+		return nil, nil
+	}
+
+	value := mapping.Content[index+1]
+	mapping.Content = append(mapping.Content[:index], mapping.Content[index+2:]...)
+
+	var names []string
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		names = []string{value.Value}
+	case yaml.SequenceNode:
+		if err := value.Decode(&names); err != nil {
+			return nil, fmt.Errorf("include must be a file name or a list of file names")
+		}
+	default:
+		return nil, fmt.Errorf("include must be a file name or a list of file names")
+	}
+
+	var files []string
+
+	for _, name := range names {
+		name = expandHome(name)
+
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(directory, name)
+		}
+
+		if strings.ContainsAny(name, "*?[") {
+			matches, err := filepath.Glob(name)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern %s", name)
+			}
+
+			files = append(files, matches...)
+			continue
+		}
+
+		info, err := os.Stat(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+
+		var matches []string
+
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			found, _ := filepath.Glob(filepath.Join(name, pattern))
+			matches = append(matches, found...)
+		}
+
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// mappingIndex returns the index of the key in the mapping, or -1 if the mapping does not contain the key
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// mergeMappings merges the overlay mapping into the base mapping key by key
+func mergeMappings(base *yaml.Node, overlay *yaml.Node) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+
+		if index := mappingIndex(base, key.Value); index >= 0 {
+			base.Content[index+1] = mergeValues(base.Content[index+1], value)
+		} else {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+}
+
+// mergeValues returns the overlay value merged into the base value, mappings and lists of named items are merged,
+// other values are replaced by the overlay value
+func mergeValues(base *yaml.Node, overlay *yaml.Node) *yaml.Node {
+	switch {
+	case base.Kind == yaml.MappingNode && overlay.Kind == yaml.MappingNode:
+		mergeMappings(base, overlay)
+		return base
+	case base.Kind == yaml.SequenceNode && overlay.Kind == yaml.SequenceNode && namedItems(base) &&
+		namedItems(overlay):
+		for _, item := range overlay.Content {
+			merged := false
+
+			for _, existing := range base.Content {
+				if itemName(existing) == itemName(item) {
+					mergeMappings(existing, item)
+					merged = true
+					break
+				}
+			}
+
+			if !merged {
+				base.Content = append(base.Content, item)
+			}
+		}
+
+		return base
+	}
+
+	return overlay
+}
+
+// namedItems returns true if all the items of the list are mappings having a name
+func namedItems(list *yaml.Node) bool {
+	for _, item := range list.Content {
+		if item.Kind != yaml.MappingNode || len(itemName(item)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// itemName returns the name of the mapping, or an empty name if it does not have a scalar name
+func itemName(item *yaml.Node) string {
+	if index := mappingIndex(item, nameKey); index >= 0 && item.Content[index+1].Kind == yaml.ScalarNode {
+		return item.Content[index+1].Value
+	}
+
+	return ""
+}
+
+// maskPasswords masks the plaintext passwords of the node, the passwords referencing environment variables or
+// encrypted by the config encrypt command are kept
+func maskPasswords(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+
+			if node.Content[i].Value == passwordKey && value.Kind == yaml.ScalarNode && len(value.Value) > 0 &&
+				!environmentVariableRegex.MatchString(value.Value) &&
+				!strings.HasPrefix(value.Value, encryptedSecretPrefix) {
+				value.Value, value.Style = maskedSecret, 0
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		maskPasswords(child)
+	}
+}
+
+// configShow prints EMM configuration file, or the effective configuration merged with the included files and the
+// overlay of the environment. Plaintext passwords are masked
+func configShow(context *cli.Context) error {
+	fileName := configFileName(context)
+
+	var document *yaml.Node
+	var err error
+
+	if context.Bool("merged") {
+		document, err = loadMergedConfig(fileName, context.String("environment"))
+	} else {
+		var content []byte
+
+		if content, err = ioutil.ReadFile(fileName); err == nil {
+			document, err = parseMapping(content)
+		}
+	}
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot load EMM configuration file %s: %s", fileName, err), errorExitCode)
+	}
+
+	maskPasswords(document)
+
+	content, err := encodeYAML(document)
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot encode EMM configuration: %s", err), errorExitCode)
+	}
+
+	_, err = context.App.Writer.Write(content)
+
+	return err
+}
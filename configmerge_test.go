@@ -0,0 +1,188 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes the configuration files in the directory, file names may contain subdirectories
+func writeConfigFiles(t *testing.T, directory string, files map[string]string) {
+	for name, content := range files {
+		fileName := filepath.Join(directory, name)
+		os.MkdirAll(filepath.Dir(fileName), 0755)
+
+		if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+			t.Fatalf("Expecting configuration file to be written, but got %v", err)
+		}
+	}
+}
+
+func TestLoadMergedConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	writeConfigFiles(t, dir, map[string]string{
+		"emm-config.yaml": `
+include: [clusters, streams/*.yaml]
+clusters:
+  - name: ryd2
+    timezone: Asia/Riyadh
+`,
+		"clusters/1-ryd2.yaml": `
+clusters:
+  - name: ryd2
+    username: mmsuper
+    password: mediation
+    logical-servers:
+      - name: Server1
+        ip: 10.135.3.125
+        capacity: {input_cdrs_per_sec: 20000}
+`,
+		"clusters/2-dev.yml": `
+clusters:
+  - name: dev
+    logical-servers:
+      - name: Server11
+        ip: localhost
+`,
+		"streams/uat.yaml": `
+configurations:
+  - name: UAT_Test
+    coll-names: [INPUT, Output]
+    assigned-logical-server: {name: Server1, cluster: ryd2}
+`,
+		"emm-config.prod.yaml": `
+clusters:
+  - name: ryd2
+    password: ${EMM_RYD2_PASSWORD}
+    logical-servers:
+      - name: Server1
+        ip: 10.10.3.125
+        capacity: {input_mb_per_sec: 50}
+      - name: Server2
+        ip: 10.10.3.126
+configurations:
+  - name: UAT_Test
+    coll-names: [INPUT]
+`,
+	})
+
+	document, err := loadMergedConfig(filepath.Join(dir, "emm-config.yaml"), "prod")
+
+	if err != nil {
+		t.Fatalf("Expecting merged configuration, but got %v", err)
+	}
+
+	var config Config
+
+	if err = document.Decode(&config); err != nil {
+		t.Fatalf("Expecting merged configuration to decode, but got %v", err)
+	}
+
+	if len(config.Clusters) != 2 || config.Clusters[0].Name != "ryd2" || config.Clusters[1].Name != "dev" {
+		t.Fatalf("Expecting ryd2 and dev clusters, but got %v", config.Clusters)
+	}
+
+	ryd2 := config.Clusters[0]
+
+	if ryd2.Username != "mmsuper" || ryd2.Password != "${EMM_RYD2_PASSWORD}" || ryd2.TimeZone != "Asia/Riyadh" ||
+		len(ryd2.LogicalServers) != 2 {
+		t.Errorf("Expecting ryd2 cluster merged with the overlay, but got %+v", ryd2)
+	}
+
+	server := ryd2.LogicalServers[0]
+	capacity := map[string]float64{"input_cdrs_per_sec": 20000, "input_mb_per_sec": 50}
+
+	if server.IP != "10.10.3.125" || !reflect.DeepEqual(server.Capacity, capacity) {
+		t.Errorf("Expecting Server1 merged with the overlay, but got %+v", server)
+	}
+
+	if len(config.Streams) != 1 || !reflect.DeepEqual(config.Streams[0].CollectorNames, []string{"INPUT"}) ||
+		config.Streams[0].LogicalServer == nil {
+		t.Errorf("Expecting UAT_Test collectors replaced by the overlay, but got %+v", config.Streams)
+	}
+
+	if _, err = loadMergedConfig(filepath.Join(dir, "emm-config.yaml"), "uat"); err == nil {
+		t.Errorf("Expecting error loading missing environment overlay")
+	}
+}
+
+func TestLoadConfigFile_IncludeCycle(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	writeConfigFiles(t, dir, map[string]string{
+		"emm-config.yaml": "include: clusters.yaml\n",
+		"clusters.yaml":   "include: emm-config.yaml\n",
+	})
+
+	if _, err := loadConfigFile(filepath.Join(dir, "emm-config.yaml"), nil); err == nil ||
+		!strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("Expecting include cycle error, but got %v", err)
+	}
+}
+
+func TestMaskPasswords(t *testing.T) {
+	document, _ := parseMapping([]byte(`
+clusters:
+  - name: ryd2
+    password: mediation
+    logical-servers:
+      - name: Server1
+        password: ${EMM_PASSWORD}
+      - name: Server2
+        password: enc:3q2+7w==
+`))
+
+	maskPasswords(document)
+
+	var config Config
+	document.Decode(&config)
+
+	cluster := config.Clusters[0]
+
+	if cluster.Password != maskedSecret || cluster.LogicalServers[0].Password != "${EMM_PASSWORD}" ||
+		cluster.LogicalServers[1].Password != "enc:3q2+7w==" {
+		t.Errorf("Expecting plaintext passwords to be masked, but got %+v %+v", cluster, cluster.LogicalServers)
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	var base, overlay yaml.Node
+
+	yaml.Unmarshal([]byte(`{checks: [{column: a}], to: [a@example.com], jobs: [{name: daily, cron: "0 6 * * *"}]}`),
+		&base)
+	yaml.Unmarshal([]byte(`{checks: [{column: b}], to: [b@example.com], jobs: [{name: weekly}, {name: daily, `+
+		`cron: "0 7 * * *"}]}`), &overlay)
+
+	mergeMappings(base.Content[0], overlay.Content[0])
+
+	var merged struct {
+		Checks []*CheckRule    `yaml:"checks"`
+		To     []string        `yaml:"to"`
+		Jobs   []*ScheduledJob `yaml:"jobs"`
+	}
+
+	base.Decode(&merged)
+
+	if len(merged.Checks) != 1 || merged.Checks[0].Column != "b" || !reflect.DeepEqual(merged.To,
+		[]string{"b@example.com"}) {
+		t.Errorf("Expecting unnamed lists to be replaced, but got %+v", merged)
+	}
+
+	if len(merged.Jobs) != 2 || merged.Jobs[0].Name != "daily" || merged.Jobs[0].Cron != "0 7 * * *" ||
+		merged.Jobs[1].Name != "weekly" {
+		t.Errorf("Expecting named lists to be merged, but got %+v %+v", merged.Jobs[0], merged.Jobs[1])
+	}
+}
+
+func TestOverlayFileName(t *testing.T) {
+	if name := overlayFileName("/etc/emm/emm-config.yaml", "prod"); name != "/etc/emm/emm-config.prod.yaml" {
+		t.Errorf("Expecting /etc/emm/emm-config.prod.yaml, but got %s", name)
+	}
+}
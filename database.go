@@ -1,23 +1,48 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 	//"strconv"
 
 	//"reflect"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var sessionsPool []Session
 
+// sslModes are the SSL modes supported by the PostgreSQL driver
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// connectionValueEscaper escapes the backslashes and the quotes of the connection string values
+var connectionValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// sessionsLock protects the sessions pool and the pending sessions, as sessions are created concurrently while
+// querying several logical servers at once. It is not held while connecting, so that a slow or unreachable logical
+// server does not delay the sessions of the other logical servers
+var sessionsLock sync.Mutex
+
+// pendingSessions are the sessions being opened, concurrent requests of the same logical server wait for the pending
+// session instead of opening another one
+var pendingSessions []*pendingSession
+
 type Session struct {
 	logicalServer *LogicalServer
 	Db            *sqlx.DB
 }
 
+// pendingSession is a session being opened, done is closed once the session is opened or failed to open (i.e. nil)
+type pendingSession struct {
+	logicalServer *LogicalServer
+	session       *Session
+	done          chan struct{}
+}
+
 // Checks whether there is already an existing working session
 // If it finds one, returns pointer to the session, otherwise, returns nil
 func isSessionExists(logicalServer *LogicalServer) *Session {
@@ -31,21 +56,82 @@ func isSessionExists(logicalServer *LogicalServer) *Session {
 	return nil
 }
 
+// findPendingSession returns the session being opened to the logical server, or nil
+func findPendingSession(logicalServer *LogicalServer) *pendingSession {
+
+	for _, pending := range pendingSessions {
+		if pending.logicalServer.Equals(logicalServer) {
+			return pending
+		}
+	}
+
+	return nil
+}
+
+// removePendingSession removes the session from the pending sessions
+func removePendingSession(session *pendingSession) {
+
+	for i, pending := range pendingSessions {
+		if pending == session {
+			pendingSessions = append(pendingSessions[:i], pendingSessions[i+1:]...)
+			return
+		}
+	}
+}
+
 // Opens a session to a logical server database and adds the
 // session to the pool
 func CreateSession(ls *LogicalServer) *Session {
 
-	var newSession *Session
+	sessionsLock.Lock()
+
+	if existingSession := isSessionExists(ls); existingSession != nil {
+		sessionsLock.Unlock()
+		return existingSession
+	}
+
+	if pending := findPendingSession(ls); pending != nil {
+		sessionsLock.Unlock()
+		<-pending.done
+
+		return pending.session
+	}
+
+	pending := &pendingSession{logicalServer: ls, done: make(chan struct{})}
+	pendingSessions = append(pendingSessions, pending)
+
+	sessionsLock.Unlock()
+
+	pending.session = openSession(ls)
+
+	sessionsLock.Lock()
 
-	if newSession = isSessionExists(ls); newSession != nil {
-		return newSession
+	removePendingSession(pending)
+
+	if pending.session != nil {
+		sessionsPool = append(sessionsPool, *pending.session)
 	}
 
-	connStr := fmt.Sprintf("user=%s dbname=%s password=%s port=%s host=%s sslmode=disable", ls.Username, ls.Database, ls.Password, ls.Port, ls.IP)
+	sessionsLock.Unlock()
+	close(pending.done)
+
+	return pending.session
+}
+
+// openSession connects to the logical server database, and returns nil if the database cannot be reached
+func openSession(ls *LogicalServer) *Session {
 
-	db, err := sqlx.Open("postgres", connStr)
+	// Passwords are masked in the logs by the secrets mask hook
+	logger.WithFields(logrus.Fields{
+		"logical_server": ls.Name,
+		"connection":     connectionString(ls),
+	}).Debug("Opening session")
 
-	err = db.Ping()
+	db, err := openDatabase(ls)
+
+	if err == nil {
+		err = db.Ping()
+	}
 
 	if err != nil {
 		logger.WithFields(logrus.Fields{
@@ -59,40 +145,75 @@ func CreateSession(ls *LogicalServer) *Session {
 		return nil
 	}
 
-	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"logical_server": ls.Name,
-			"database":       ls.Database,
-			"ip":             ls.IP,
-			"port":           ls.Port,
-			"error":          err,
-		}).Error("Opening session")
+	return &Session{logicalServer: ls, Db: db}
+}
 
-		return nil
+// openDatabase opens the logical server database, connections are dialed through the SSH tunnel of the cluster of the
+// logical server if it is specified
+func openDatabase(ls *LogicalServer) (*sqlx.DB, error) {
+	if ls.sshTunnel == nil {
+		return sqlx.Open("postgres", connectionString(ls))
+	}
 
-	} else {
-		newSession = &Session{logicalServer: ls, Db: db}
+	connector, err := pq.NewConnector(connectionString(ls))
+
+	if err != nil {
+		return nil, err
 	}
 
-	sessionsPool = append(sessionsPool, *newSession)
+	connector.Dialer(findSSHTunnel(ls.sshTunnel))
 
-	return newSession
+	return sqlx.NewDb(sql.OpenDB(connector), "postgres"), nil
 }
 
-func (s Session) executeQuery(query string) *Report {
-	var report Report
+// connectionString returns the connection string of the logical server database. Values are quoted so that passwords
+// may contain spaces and quotes, and empty values are left to the driver defaults, except the SSL mode which defaults to
+// disable
+func connectionString(ls *LogicalServer) string {
+	var options []string
+
+	sslMode := ls.SSLMode
+
+	if len(sslMode) == 0 {
+		sslMode = defaultSSLMode
+	}
+
+	for _, option := range []optionValue{
+		{"user", ls.Username},
+		{"dbname", ls.Database},
+		{"password", ls.Password},
+		{"port", ls.Port},
+		{"host", ls.IP},
+		{"sslmode", sslMode},
+		{"sslrootcert", ls.SSLRootCert},
+		{"sslcert", ls.SSLCert},
+		{"sslkey", ls.SSLKey},
+	} {
+		if len(option.value) > 0 {
+			options = append(options, fmt.Sprintf("%s='%s'", option.name, connectionValueEscaper.Replace(option.value)))
+		}
+	}
 
-	rows, err := s.Db.Queryx(query)
+	return strings.Join(options, " ")
+}
+
+func (s Session) executeQuery(query string, args ...interface{}) (*Report, error) {
+	rows, err := s.Db.Queryx(query, args...)
 
 	if err != nil {
 		logger.WithFields(logrus.Fields{
-			"query": fmt.Sprintf(query),
+			"query": query,
+			"args":  args,
 			"error": err,
 		}).Error("Querying all rows")
+
+		return nil, fmt.Errorf("Query failed in %s logical server: %s", s.logicalServer.Name, err)
 	}
 
-	report = Report{}
+	defer rows.Close()
+
+	report := &Report{}
 	report.ExtractResultSet(rows)
 
-	return &report
+	return report, nil
 }
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// defaultDiscoverRange is the period scanned for nodes when no time options are specified
+	defaultDiscoverRange = "last-30d"
+
+	// unnamedNodesStream is the name of the proposed stream of the nodes without name, they are selected by id
+	unnamedNodesStream = "UNNAMED"
+
+	// configuredStatus and proposedStatus tell whether a discovered node is already part of a stream
+	configuredStatus = "configured"
+	proposedStatus   = "proposed"
+)
+
+// discoverColumns are the columns of the discover report
+var discoverColumns = []string{"node_type", "node_name", "node_id", "first_seen", "last_seen", "files", "cdrs", "bytes",
+	"stream", "status"}
+
+// nodeSeparators separate the parts of the node names (e.g. 4G_LTE_RD1), the proposed streams group the nodes sharing
+// their first parts
+const nodeSeparators = "_-. "
+
+// discoveredNode is a collector or a distributor found in audittraillogentry
+type discoveredNode struct {
+	nodeType  string
+	name      string
+	id        string
+	firstSeen string
+	lastSeen  string
+	files     string
+	cdrs      string
+	bytes     string
+	stream    string
+	status    string
+}
+
+// discover lists the collectors and distributors of a logical server seen during the period, and proposes stream
+// definitions for the nodes which are not part of any stream yet. Proposed streams group the nodes by the common
+// prefix of their names, they are printed as YAML, or merged into EMM configuration file if requested
+func discover(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	target, err := resolveThroughputTarget(context)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	period, err := resolveReportPeriodWithDefault(context, target.cluster, defaultDiscoverRange)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	s.Prefix = fmt.Sprintf("%s Discovery ", target.name)
+	s.Start()
+
+	nodes, err := queryDiscoveredNodes(target, period)
+
+	s.Stop()
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	streams := proposeStreams(nodes, target, context.Int("prefix-parts"), context.Bool("patterns"))
+
+	if context.Bool("merge") {
+		configFile := configFileName(context)
+
+		if err = mergeStreams(configFile, streams); err != nil {
+			return cli.Exit(fmt.Sprintf("Cannot merge streams into %s: %s", configFile, err), errorExitCode)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"file":    configFile,
+			"streams": len(streams),
+		}).Info("Proposed streams merged into EMM configuration file")
+	}
+
+	if context.Bool("yaml") {
+		document, err := streamsYAML(streams)
+
+		if err != nil {
+			return cli.Exit(err.Error(), errorExitCode)
+		}
+
+		_, err = context.App.Writer.Write(document)
+
+		return err
+	}
+
+	report := &Report{name: fmt.Sprintf("%s Discovery", target.name)}
+	report.AddHeader("Period", period.periodLabel())
+	report.AddHeader("Time Zone", period.timeZoneLabel())
+	report.defaultTable = discoveredNodesTable(nodes)
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// queryDiscoveredNodes executes the discover query in the logical server database
+func queryDiscoveredNodes(target *throughputTarget, period *reportPeriod) ([]*discoveredNode, error) {
+	var nodes []*discoveredNode
+
+	query, args := parseTemplate("discover", discoverQueryTemplate, period.queryParameters(timeBucket{}))
+
+	logger.WithFields(logrus.Fields{
+		"command": "discover",
+		"target":  target.name,
+		"query":   query,
+	}).Debug("Discover query")
+
+	session := CreateSession(target.logicalServer)
+
+	if session == nil {
+		return nil, fmt.Errorf("Cannot open session to %s logical server", target.logicalServer.Name)
+	}
+
+	report, err := session.executeQuery(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range tableRows(report.GetDefaultTable()) {
+		nodes = append(nodes, &discoveredNode{
+			nodeType:  row["node_type"],
+			name:      row["node_name"],
+			id:        row["node_id"],
+			firstSeen: row["first_seen"],
+			lastSeen:  row["last_seen"],
+			files:     row["files"],
+			cdrs:      row["cdrs"],
+			bytes:     row["bytes"],
+		})
+	}
+
+	return nodes, nil
+}
+
+// proposeStreams proposes the streams of the nodes which are not part of the streams already running in the logical
+// server. Nodes are grouped by the first parts of their names, and nodes without name are grouped by id. Nodes are
+// listed by name, or by pattern if requested and several nodes share the prefix. The stream of every node is set
+func proposeStreams(nodes []*discoveredNode, target *throughputTarget, prefixParts int,
+	patterns bool) []*Stream {
+
+	var streams []*Stream
+
+	var configured []*Stream
+
+	if emmConfig != nil {
+		configured = emmConfig.FindLogicalServerStreams(target.logicalServer.Name, target.cluster.Name)
+	}
+	prefixPatterns := map[*Stream]string{}
+
+	for _, node := range nodes {
+		for _, stream := range configured {
+			if stream.coversNode(node.nodeType, node.name, node.id) {
+				node.stream, node.status = stream.Name, configuredStatus
+				break
+			}
+		}
+
+		if node.status == configuredStatus {
+			continue
+		}
+
+		prefix, separator := nodePrefix(node.name, prefixParts)
+		name := prefix
+
+		if len(name) == 0 {
+			name = unnamedNodesStream
+		}
+
+		node.stream, node.status = name, proposedStatus
+
+		var stream *Stream
+
+		for _, existing := range streams {
+			if existing.Name == name {
+				stream = existing
+			}
+		}
+
+		if stream == nil {
+			stream = &Stream{
+				Name:          name,
+				LogicalServer: &AssignedLogicalServer{Name: target.logicalServer.Name, Cluster: target.cluster.Name},
+			}
+
+			streams = append(streams, stream)
+		}
+
+		// Names without separator are complete names, they cannot be prefixes of other names
+		if len(separator) > 0 {
+			prefixPatterns[stream] = escapeLikePattern(prefix+separator) + "%"
+		}
+
+		switch {
+		case len(node.name) == 0 && node.nodeType == collectorNode:
+			stream.CollectorIds = appendUnique(stream.CollectorIds, node.id)
+		case len(node.name) == 0:
+			stream.DistributorIds = appendUnique(stream.DistributorIds, node.id)
+		case node.nodeType == collectorNode:
+			stream.CollectorNames = appendUnique(stream.CollectorNames, node.name)
+		default:
+			stream.DistributorNames = appendUnique(stream.DistributorNames, node.name)
+		}
+	}
+
+	for stream, pattern := range prefixPatterns {
+		if patterns && len(stream.CollectorNames) > 1 && matchAllNodes(pattern, stream.CollectorNames) {
+			stream.CollectorNames, stream.CollectorPatterns = nil, []string{pattern}
+		}
+
+		if patterns && len(stream.DistributorNames) > 1 && matchAllNodes(pattern, stream.DistributorNames) {
+			stream.DistributorNames, stream.DistributorPatterns = nil, []string{pattern}
+		}
+	}
+
+	return streams
+}
+
+// coversNode returns true if the node is listed by name or id in the stream, or matches its patterns without matching
+// its exclusions
+func (s *Stream) coversNode(nodeType string, name string, id string) bool {
+	names, ids, patterns, exclusions := s.CollectorNames, s.CollectorIds, s.CollectorPatterns, s.CollectorExclusions
+
+	if nodeType == distributorNode {
+		names, ids, patterns, exclusions = s.DistributorNames, s.DistributorIds, s.DistributorPatterns,
+			s.DistributorExclusions
+	}
+
+	return (len(name) > 0 && indexOf(names, name) >= 0) || (len(id) > 0 && indexOf(ids, id) >= 0) ||
+		(matchNodePatterns(patterns, name) && !matchNodePatterns(exclusions, name))
+}
+
+// nodePrefix returns the first parts of the node name, and the separator following them. Names with fewer parts are
+// returned completely without separator
+func nodePrefix(name string, parts int) (string, string) {
+	if parts < 1 {
+		parts = 1
+	}
+
+	for i, character := range name {
+		if !strings.ContainsRune(nodeSeparators, character) {
+			continue
+		}
+
+		if parts--; parts == 0 {
+			return name[:i], string(character)
+		}
+	}
+
+	return name, ""
+}
+
+// matchAllNodes returns true if all the node names match the pattern
+func matchAllNodes(pattern string, names []string) bool {
+	for _, name := range names {
+		if !matchNodePattern(pattern, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// escapeLikePattern escapes the wildcards of SQL LIKE patterns, so that the value is matched literally
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// appendUnique appends the value if it is not in the values yet
+func appendUnique(values []string, value string) []string {
+	if indexOf(values, value) >= 0 {
+		return values
+	}
+
+	return append(values, value)
+}
+
+// discoveredNodesTable returns the table of the discovered nodes
+func discoveredNodesTable(nodes []*discoveredNode) *ResultSet {
+	records := [][]string{discoverColumns}
+	table := &ResultSet{columnsNames: discoverColumns, columnsDataTypes: map[string]series.Type{}}
+
+	for _, columnName := range discoverColumns {
+		table.columnsDataTypes[columnName] = series.String
+	}
+
+	for _, columnName := range []string{"files", "cdrs", "bytes"} {
+		table.columnsDataTypes[columnName] = series.Int
+	}
+
+	for _, node := range nodes {
+		records = append(records, []string{node.nodeType, node.name, node.id, node.firstSeen, node.lastSeen,
+			node.files, node.cdrs, node.bytes, node.stream, node.status})
+	}
+
+	if len(records) > 1 {
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+	}
+
+	return table
+}
+
+// streamsNodes returns the YAML nodes of the streams, node lists use the flow style of EMM configuration file
+func streamsNodes(streams []*Stream) ([]*yaml.Node, error) {
+	var nodes []*yaml.Node
+
+	for _, stream := range streams {
+		node := &yaml.Node{}
+
+		if err := node.Encode(stream); err != nil {
+			return nil, err
+		}
+
+		for i := 1; i < len(node.Content); i += 2 {
+			if value := node.Content[i]; value.Kind == yaml.SequenceNode {
+				value.Style = yaml.FlowStyle
+
+				for _, item := range value.Content {
+					item.Style = yaml.DoubleQuotedStyle
+				}
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// streamsYAML returns the configurations module of the streams
+func streamsYAML(streams []*Stream) ([]byte, error) {
+	nodes, err := streamsNodes(streams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	document := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "configurations"},
+		{Kind: yaml.SequenceNode, Content: nodes},
+	}}
+
+	return encodeYAML(document)
+}
+
+// mergeStreams appends the streams to the configurations module of EMM configuration file. Streams whose names are
+// already defined are skipped. Comments of the file are kept, but blank lines are removed by the YAML encoder
+func mergeStreams(fileName string, streams []*Stream) error {
+	var document yaml.Node
+
+	content, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return err
+	}
+
+	if err = yaml.Unmarshal(content, &document); err != nil {
+		return err
+	}
+
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("EMM configuration file is not a YAML mapping")
+	}
+
+	root := document.Content[0]
+	var configurations *yaml.Node
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "configurations" {
+			configurations = root.Content[i+1]
+		}
+	}
+
+	if configurations == nil {
+		configurations = &yaml.Node{Kind: yaml.SequenceNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "configurations"},
+			configurations)
+	}
+
+	var merged []*Stream
+
+	for _, stream := range streams {
+		if emmConfig != nil && emmConfig.FindStream(stream.Name) != nil {
+			logger.WithFields(logrus.Fields{
+				"stream": stream.Name,
+			}).Warn("Stream is already defined in EMM configuration, it is not merged")
+
+			continue
+		}
+
+		merged = append(merged, stream)
+	}
+
+	nodes, err := streamsNodes(merged)
+
+	if err != nil {
+		return err
+	}
+
+	configurations.Content = append(configurations.Content, nodes...)
+
+	if content, err = encodeYAML(&document); err != nil {
+		return err
+	}
+
+	// The file is replaced at once, so that it is never left partially written
+	temporary, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temporary.Name())
+
+	if _, err = temporary.Write(content); err != nil {
+		temporary.Close()
+		return err
+	}
+
+	if err = temporary.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary.Name(), fileName)
+}
+
+// encodeYAML encodes the YAML document using the indentation of EMM configuration file
+func encodeYAML(document *yaml.Node) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodePrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		parts     int
+		prefix    string
+		separator string
+	}{
+		{"4G_LTE_RD1", 2, "4G_LTE", "_"},
+		{"4G_LTE_RD1", 1, "4G", "_"},
+		{"toHW-PGW.in", 2, "toHW-PGW", "."},
+		{"INPUT", 2, "INPUT", ""},
+		{"", 2, "", ""},
+	}
+
+	for _, test := range tests {
+		prefix, separator := nodePrefix(test.name, test.parts)
+
+		if prefix != test.prefix || separator != test.separator {
+			t.Errorf("Expecting %s and '%s', but got %s and '%s'", test.prefix, test.separator, prefix, separator)
+		}
+	}
+}
+
+func TestProposeStreams(t *testing.T) {
+	defer func(config *Config) { emmConfig = config }(emmConfig)
+
+	emmConfig = &Config{Streams: []*Stream{{
+		Name:              "UAT_Test",
+		CollectorPatterns: []string{"UAT_%"},
+		LogicalServer:     &AssignedLogicalServer{Name: "Server1", Cluster: "ryd2"},
+	}}}
+
+	target := newLogicalServerTarget(&LogicalServer{Name: "Server1"}, &Cluster{Name: "ryd2"})
+	nodes := []*discoveredNode{
+		{nodeType: collectorNode, name: "4G_LTE_RD1"},
+		{nodeType: collectorNode, name: "4G_LTE_RD2"},
+		{nodeType: collectorNode, name: "UAT_IN"},
+		{nodeType: collectorNode, id: "14025"},
+		{nodeType: distributorNode, name: "4G_LTE_BI"},
+	}
+
+	streams := proposeStreams(nodes, target, 2, true)
+
+	if len(streams) != 2 {
+		t.Fatalf("Expecting 2 streams, but got %d", len(streams))
+	}
+
+	expected := &Stream{
+		Name:              "4G_LTE",
+		CollectorPatterns: []string{`4G\_LTE\_%`},
+		DistributorNames:  []string{"4G_LTE_BI"},
+		LogicalServer:     &AssignedLogicalServer{Name: "Server1", Cluster: "ryd2"},
+	}
+
+	if !reflect.DeepEqual(streams[0], expected) {
+		t.Errorf("Expecting %+v, but got %+v", expected, streams[0])
+	}
+
+	if streams[1].Name != unnamedNodesStream || !reflect.DeepEqual(streams[1].CollectorIds, []string{"14025"}) {
+		t.Errorf("Expecting unnamed stream with collector id 14025, but got %+v", streams[1])
+	}
+
+	if nodes[2].stream != "UAT_Test" || nodes[2].status != configuredStatus {
+		t.Errorf("Expecting UAT_IN to be configured in UAT_Test, but got %s %s", nodes[2].stream, nodes[2].status)
+	}
+
+	if nodes[0].stream != "4G_LTE" || nodes[0].status != proposedStatus {
+		t.Errorf("Expecting 4G_LTE_RD1 to be proposed in 4G_LTE, but got %s %s", nodes[0].stream, nodes[0].status)
+	}
+}
+
+func TestQueryDiscoveredNodes_QueryFailure(t *testing.T) {
+	target := newLogicalServerTarget(failingLogicalServer("Failing4"), nil)
+	period := &reportPeriod{start: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC),
+		end: time.Date(2019, 3, 31, 0, 0, 0, 0, time.UTC)}
+
+	if nodes, err := queryDiscoveredNodes(target, period); err == nil {
+		t.Errorf("Expecting query error, but got %v", nodes)
+	}
+}
+
+func TestMergeStreams(t *testing.T) {
+	defer func(config *Config) { emmConfig = config }(emmConfig)
+
+	emmConfig = &Config{Streams: []*Stream{{Name: "UAT_Test"}}}
+
+	directory, err := ioutil.TempDir("", "emmstats")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(directory)
+
+	fileName := filepath.Join(directory, "emm-config.yaml")
+	content := "clusters:\n  - name: ryd2 # Cluster Name\nconfigurations:\n  - name: UAT_Test\n    coll-names: [\"INPUT\"]\n"
+
+	if err = ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	streams := []*Stream{
+		{Name: "UAT_Test", CollectorNames: []string{"OTHER"}},
+		{Name: "4G_LTE", CollectorNames: []string{"4G_LTE_RD1", "4G_LTE_RD2"},
+			LogicalServer: &AssignedLogicalServer{Name: "Server1", Cluster: "ryd2"}},
+	}
+
+	if err = mergeStreams(fileName, streams); err != nil {
+		t.Fatalf("Expecting streams to be merged, but got %s", err)
+	}
+
+	merged, _ := ioutil.ReadFile(fileName)
+	expected := content + "  - name: 4G_LTE\n    coll-names: [\"4G_LTE_RD1\", \"4G_LTE_RD2\"]\n" +
+		"    assigned-logical-server:\n      name: Server1\n      cluster: ryd2\n"
+
+	if string(merged) != expected {
+		t.Errorf("Expecting %s, but got %s", expected, merged)
+	}
+
+	config, _ := parseEMMConfig(fileName, "")
+
+	if config == nil || config.FindStream("4G_LTE") == nil || strings.Count(string(merged), "UAT_Test") != 1 {
+		t.Errorf("Expecting merged file to define UAT_Test once and 4G_LTE, but got %s", merged)
+	}
+}
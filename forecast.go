@@ -0,0 +1,700 @@
+package main
+
+import (
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"github.com/montanaflynn/stats"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultForecastMonths and defaultForecastWeeks are the numbers of whole periods of the history used to fit the
+	// trends when no time options are specified, two complete years are required to fit the seasonality of monthly
+	// and weekly throughput, and one more period is kept in case the oldest one is not complete in the database
+	defaultForecastMonths = 25
+	defaultForecastWeeks  = 105
+
+	// defaultForecastGroupBy is the forecast period when the group by option is not specified
+	defaultForecastGroupBy = "month"
+)
+
+// forecastColumns are the columns of the forecast report
+var forecastColumns = []string{targetColumn, timeColumn, "column", "forecast", "lower", "upper", "peak_per_sec",
+	"capacity_per_sec"}
+
+// throughputHistory contains the throughput of the complete periods (e.g. months) of the history. Peaks are the
+// highest hourly rates per second of each period, bytes rates are expressed in MB
+type throughputHistory struct {
+	columns   []string
+	starts    []time.Time
+	durations []time.Duration
+	volumes   map[string][]float64
+	peaks     map[string][]float64
+}
+
+// trendModel is a linear trend with optional seasonal components, fitted to the values of a throughput column using
+// ordinary least squares
+type trendModel struct {
+	bucket       timeBucket
+	coefficients []float64
+	inverse      [][]float64
+	sigma        float64
+	dof          float64
+	seasonal     bool
+}
+
+// forecastRow is a projected period of a throughput column
+type forecastRow struct {
+	target   string
+	label    string
+	column   string
+	value    float64
+	lower    float64
+	upper    float64
+	peakRate float64
+	capacity float64
+}
+
+// forecast projects the throughput of the logical servers, and of their clusters, for the next periods. Trends are
+// fitted to the monthly or weekly throughput of the history (the report period), and the projected peak rates are
+// compared with the capacity of the logical servers
+func forecast(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	groupBy := defaultForecastGroupBy
+
+	if context.IsSet("group-by") {
+		groupBy = context.String("group-by")
+	}
+
+	bucket, err := chooseTimeBucket(groupBy)
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid group-by %s", groupBy), errorExitCode)
+	}
+
+	periods := context.Int("periods")
+	confidence := context.Float64("confidence")
+
+	clusters, err := resolveForecastClusters(context)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	var rows []forecastRow
+
+	report := &Report{name: "Throughput Forecast"}
+
+	for _, cluster := range clusters {
+		period, err := resolveReportPeriod(context, cluster)
+
+		if err != nil {
+			return cli.Exit(err.Error(), errorExitCode)
+		}
+
+		if !timeOptionsSet(context) {
+			period.start, period.end = defaultForecastHistory(bucket, period.end)
+		}
+
+		if len(report.headers) == 0 {
+			report.AddHeader("History", period.periodLabel())
+			report.AddHeader("Time Zone", period.timeZoneLabel())
+			report.AddHeader("Group By", bucket.name)
+			report.AddHeader("Periods", strconv.Itoa(periods))
+			report.AddHeader("Confidence", fmt.Sprintf("%s%%", strconv.FormatFloat(confidence, 'f', -1, 64)))
+		}
+
+		var clusterHourly []map[string]map[string]float64
+		var columns []string
+
+		for _, logicalServer := range cluster.LogicalServers {
+			if len(context.String("lserver")) > 0 && logicalServer.Name != context.String("lserver") {
+				continue
+			}
+
+			target := newLogicalServerTarget(logicalServer, cluster)
+
+			s.Prefix = fmt.Sprintf("%s Throughput Forecast ", target.name)
+			s.Start()
+
+			hourly, hourlyColumns, err := loadHourlyThroughput(context, target, period)
+
+			s.Stop()
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"command": "forecast",
+					"target":  target.name,
+					"error":   err,
+				}).Error("Generating throughput history")
+
+				continue
+			}
+
+			clusterHourly = append(clusterHourly, hourly)
+			columns = hourlyColumns
+			history := aggregateThroughput(hourly, columns, period, bucket)
+
+			rows = append(rows, forecastTarget(target.name, history, logicalServer.Capacity, bucket, periods,
+				confidence)...)
+		}
+
+		// Cluster forecast is based on the combined hourly throughput, so that peaks of the logical servers occurring
+		// at different hours are not added up
+		if len(clusterHourly) > 1 {
+			history := aggregateThroughput(combineHourlyThroughput(clusterHourly), columns, period, bucket)
+
+			rows = append(rows, forecastTarget(fmt.Sprintf("%s Cluster", cluster.Name), history, nil, bucket,
+				periods, confidence)...)
+		}
+	}
+
+	report.defaultTable = forecastTable(report, rows)
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// resolveForecastClusters returns the cluster specified in the options, or all the clusters defined in EMM
+// configuration
+func resolveForecastClusters(context *cli.Context) ([]*Cluster, error) {
+	if emmConfig == nil {
+		return nil, fmt.Errorf("EMM configuration file is not loaded")
+	}
+
+	clusterArg := context.String("cluster")
+
+	if len(clusterArg) == 0 {
+		return emmConfig.Clusters, nil
+	}
+
+	cluster := emmConfig.FindCluster(clusterArg)
+
+	if cluster == nil {
+		return nil, fmt.Errorf("%s cluster is not defined in EMM configuration", clusterArg)
+	}
+
+	lserver := context.String("lserver")
+
+	if len(lserver) > 0 && emmConfig.FindLogicalServer(lserver, clusterArg) == nil {
+		return nil, fmt.Errorf("%s logical server is not defined in %s cluster", lserver, clusterArg)
+	}
+
+	return []*Cluster{cluster}, nil
+}
+
+// loadHourlyThroughput returns the hourly throughput of the target for the period, keyed by hour bucket label and
+// column name, and the names of the files, CDRs and bytes columns
+func loadHourlyThroughput(context *cli.Context, target *throughputTarget, period *reportPeriod) (
+	map[string]map[string]float64, []string, error) {
+
+	var columns []string
+
+	hourBucket, _ := chooseTimeBucket("hour")
+	report, err := target.throughputReport(context, period, hourBucket)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	table := report.GetDefaultTable()
+
+	for _, columnName := range table.GetColumnsNames() {
+		if _, isRate := rateColumnName(columnName, rateUnits["second"]); isRate &&
+			isNumericType(table.GetColumnsDataTypes()[columnName]) {
+			columns = append(columns, columnName)
+		}
+	}
+
+	hourly := map[string]map[string]float64{}
+
+	for _, row := range tableRows(table) {
+		values := map[string]float64{}
+
+		for _, columnName := range columns {
+			values[columnName], _ = strconv.ParseFloat(row[columnName], 64)
+		}
+
+		hourly[row[timeColumn]] = values
+	}
+
+	return hourly, columns, nil
+}
+
+// combineHourlyThroughput adds up the hourly throughput of several logical servers
+func combineHourlyThroughput(hourlyThroughputs []map[string]map[string]float64) map[string]map[string]float64 {
+	combined := map[string]map[string]float64{}
+
+	for _, hourly := range hourlyThroughputs {
+		for label, values := range hourly {
+			if combined[label] == nil {
+				combined[label] = map[string]float64{}
+			}
+
+			for columnName, value := range values {
+				combined[label][columnName] += value
+			}
+		}
+	}
+
+	return combined
+}
+
+// aggregateThroughput groups the hourly throughput into the periods of the bucket, keeping only the periods completely
+// covered by the report period, as partial periods would bias the trends
+func aggregateThroughput(hourly map[string]map[string]float64, columns []string, period *reportPeriod,
+	bucket timeBucket) *throughputHistory {
+
+	history := &throughputHistory{
+		columns: columns,
+		volumes: map[string][]float64{},
+		peaks:   map[string][]float64{},
+	}
+
+	hourBucket, _ := chooseTimeBucket("hour")
+	for start := bucket.truncate(period.start); start.Before(period.end); start = bucket.next(start) {
+		end := bucket.next(start)
+
+		if start.Before(period.start) || end.After(period.end) {
+			continue
+		}
+
+		history.starts = append(history.starts, start)
+		history.durations = append(history.durations, end.Sub(start))
+
+		for _, columnName := range columns {
+			volume, peak := 0.0, 0.0
+
+			for hour := start; hour.Before(end); hour = hourBucket.next(hour) {
+				value := hourly[hourBucket.label(hour)][columnName]
+				volume += value
+				peak = math.Max(peak, toRateValue(columnName, value)/hourBucket.next(hour).Sub(hour).Seconds())
+			}
+
+			history.volumes[columnName] = append(history.volumes[columnName], volume)
+			history.peaks[columnName] = append(history.peaks[columnName], peak)
+		}
+	}
+
+	return history
+}
+
+// defaultForecastHistory returns the start and end times of the default history, made of the whole periods of the
+// bucket preceding the period of the current time
+func defaultForecastHistory(bucket timeBucket, now time.Time) (time.Time, time.Time) {
+	current := bucket.truncate(now)
+
+	if bucket.name == "week" {
+		return current.AddDate(0, 0, -7*defaultForecastWeeks), current
+	}
+
+	return current.AddDate(0, -defaultForecastMonths, 0), current
+}
+
+// peakFactor returns the median ratio between the peak hourly rate and the average rate of the history periods, it is
+// used to derive the projected peak rates from the projected volumes
+func (h *throughputHistory) peakFactor(columnName string) float64 {
+	var factors []float64
+
+	for i, volume := range h.volumes[columnName] {
+		if average := toRateValue(columnName, volume) / h.durations[i].Seconds(); average > 0 {
+			factors = append(factors, h.peaks[columnName][i]/average)
+		}
+	}
+
+	factor, err := stats.Median(factors)
+
+	if err != nil {
+		return 1
+	}
+
+	return factor
+}
+
+// forecastTarget fits a trend to each column of the history, and projects it for the next periods
+func forecastTarget(targetName string, history *throughputHistory, capacity map[string]float64, bucket timeBucket,
+	periods int, confidence float64) []forecastRow {
+
+	var rows []forecastRow
+
+	if len(history.starts) == 0 {
+		return rows
+	}
+
+	for _, columnName := range history.columns {
+		model, err := fitTrend(bucket, history.starts, history.volumes[columnName])
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"command": "forecast",
+				"target":  targetName,
+				"column":  columnName,
+				"error":   err,
+			}).Warn("Cannot fit throughput trend")
+
+			continue
+		}
+
+		rateColumn, _ := rateColumnName(columnName, rateUnits["second"])
+		factor := history.peakFactor(columnName)
+		start := history.starts[len(history.starts)-1]
+
+		for i := 0; i < periods; i++ {
+			start = bucket.next(start)
+			index := len(history.starts) + i
+			value, lower, upper := model.predict(index, start, confidence)
+
+			rows = append(rows, forecastRow{
+				target:   targetName,
+				label:    bucket.label(start),
+				column:   columnName,
+				value:    value,
+				lower:    lower,
+				upper:    upper,
+				peakRate: toRateValue(columnName, value) / bucket.next(start).Sub(start).Seconds() * factor,
+				capacity: capacity[rateColumn],
+			})
+		}
+	}
+
+	return rows
+}
+
+// forecastTable creates the forecast table from the projected rows, highlighting the peak rates beyond capacity. The
+// first period where the peak rate of each column crosses the capacity is added to the report headers
+func forecastTable(report *Report, rows []forecastRow) *ResultSet {
+	table := &ResultSet{
+		columnsNames: forecastColumns,
+		columnsDataTypes: map[string]series.Type{targetColumn: series.String, timeColumn: series.String,
+			"column": series.String, "forecast": series.Float, "lower": series.Float, "upper": series.Float,
+			"peak_per_sec": series.Float, "capacity_per_sec": series.String},
+		highlights:       map[cell]bool{},
+		highlightCaption: "! Projected peak rate beyond capacity",
+	}
+
+	records := [][]string{forecastColumns}
+	crossed := map[string]bool{}
+
+	for _, row := range rows {
+		if row.exceedsCapacity() {
+			table.highlights[cell{row: len(records) - 1, column: indexOf(forecastColumns, "peak_per_sec")}] = true
+
+			if key := row.target + row.column; !crossed[key] {
+				crossed[key] = true
+				rateColumn, _ := rateColumnName(row.column, rateUnits["second"])
+				report.AddHeader(fmt.Sprintf("Capacity %s", row.target),
+					fmt.Sprintf("%s exceeds %s from %s", rateColumn, formatFloat(row.capacity), row.label))
+			}
+		}
+
+		records = append(records, row.record())
+	}
+
+	if len(records) > 1 {
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+	}
+
+	return table
+}
+
+// exceedsCapacity returns true if the projected peak rate is beyond the configured capacity
+func (r forecastRow) exceedsCapacity() bool {
+	return r.capacity > 0 && r.peakRate > r.capacity
+}
+
+// record returns the row as a record of the forecast table
+func (r forecastRow) record() []string {
+	capacity := ""
+
+	if r.capacity > 0 {
+		capacity = formatFloat(r.capacity)
+	}
+
+	return []string{r.target, r.label, r.column, strconv.FormatFloat(r.value, 'f', 0, 64),
+		strconv.FormatFloat(r.lower, 'f', 0, 64), strconv.FormatFloat(r.upper, 'f', 0, 64),
+		strconv.FormatFloat(r.peakRate, 'f', 3, 64), capacity}
+}
+
+// fitTrend fits a linear trend to the values of the periods starting at the start times. A seasonal component per
+// month of the year (or week of the year) is added when the history covers at least two complete years
+func fitTrend(bucket timeBucket, starts []time.Time, values []float64) (*trendModel, error) {
+	_, seasonLength := seasonOf(bucket, starts[0])
+
+	model := &trendModel{bucket: bucket, seasonal: len(values) >= 2*seasonLength}
+	parameters := len(model.design(0, starts[0]))
+
+	if len(values) <= parameters {
+		return nil, fmt.Errorf("not enough history to fit the trend, %d periods are available", len(values))
+	}
+
+	design := make([][]float64, len(values))
+
+	for i := range values {
+		design[i] = model.design(i, starts[i])
+	}
+
+	// Coefficients are the solution of the normal equations (X'X)b = X'y, the inverse of X'X is kept to compute the
+	// prediction intervals
+	normal := make([][]float64, parameters)
+	moments := make([]float64, parameters)
+
+	for i := range normal {
+		normal[i] = make([]float64, parameters)
+
+		for j := range normal[i] {
+			for k := range design {
+				normal[i][j] += design[k][i] * design[k][j]
+			}
+		}
+
+		for k, value := range values {
+			moments[i] += design[k][i] * value
+		}
+	}
+
+	var err error
+
+	if model.inverse, err = invertMatrix(normal); err != nil {
+		return nil, fmt.Errorf("cannot fit the trend: %s", err)
+	}
+
+	model.coefficients = multiplyVector(model.inverse, moments)
+
+	squares := 0.0
+
+	for i, value := range values {
+		squares += math.Pow(value-dotProduct(design[i], model.coefficients), 2)
+	}
+
+	model.dof = float64(len(values) - parameters)
+	model.sigma = math.Sqrt(squares / model.dof)
+
+	return model, nil
+}
+
+// design returns the explanatory variables of the period with the index and start time: the intercept, the index
+// for the linear trend, and one indicator per season except the first for the seasonal component
+func (m *trendModel) design(index int, start time.Time) []float64 {
+	variables := []float64{1, float64(index)}
+
+	if !m.seasonal {
+		return variables
+	}
+
+	season, seasonLength := seasonOf(m.bucket, start)
+
+	for i := 1; i < seasonLength; i++ {
+		if i == season {
+			variables = append(variables, 1)
+		} else {
+			variables = append(variables, 0)
+		}
+	}
+
+	return variables
+}
+
+// predict returns the projected value of the period with the index and start time, and the bounds of its prediction
+// interval for the confidence percentage. Throughput cannot be negative, so values are clipped at zero
+func (m *trendModel) predict(index int, start time.Time, confidence float64) (float64, float64, float64) {
+	variables := m.design(index, start)
+	value := dotProduct(variables, m.coefficients)
+
+	margin := 0.0
+
+	if m.sigma > 0 {
+		quantile := studentTQuantile(0.5+confidence/200, m.dof)
+		margin = quantile * m.sigma * math.Sqrt(1+dotProduct(variables, multiplyVector(m.inverse, variables)))
+	}
+
+	return math.Max(0, value), math.Max(0, value-margin), math.Max(0, value+margin)
+}
+
+// invertMatrix returns the inverse of the square matrix using Gauss-Jordan elimination with partial pivoting, an error
+// is returned if the matrix is singular (e.g. seasons without history)
+func invertMatrix(matrix [][]float64) ([][]float64, error) {
+	size := len(matrix)
+	augmented := make([][]float64, size)
+
+	for i, row := range matrix {
+		augmented[i] = make([]float64, 2*size)
+		copy(augmented[i], row)
+		augmented[i][size+i] = 1
+	}
+
+	for column := 0; column < size; column++ {
+		pivot := column
+
+		for row := column + 1; row < size; row++ {
+			if math.Abs(augmented[row][column]) > math.Abs(augmented[pivot][column]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(augmented[pivot][column]) < 1e-12 {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+
+		augmented[column], augmented[pivot] = augmented[pivot], augmented[column]
+		scale := augmented[column][column]
+
+		for j := range augmented[column] {
+			augmented[column][j] /= scale
+		}
+
+		for row := 0; row < size; row++ {
+			if factor := augmented[row][column]; row != column && factor != 0 {
+				for j := range augmented[row] {
+					augmented[row][j] -= factor * augmented[column][j]
+				}
+			}
+		}
+	}
+
+	inverse := make([][]float64, size)
+
+	for i, row := range augmented {
+		inverse[i] = row[size:]
+	}
+
+	return inverse, nil
+}
+
+// multiplyVector returns the product of the matrix and the vector
+func multiplyVector(matrix [][]float64, vector []float64) []float64 {
+	product := make([]float64, len(matrix))
+
+	for i, row := range matrix {
+		product[i] = dotProduct(row, vector)
+	}
+
+	return product
+}
+
+// dotProduct returns the dot product of two vectors of the same length
+func dotProduct(a []float64, b []float64) float64 {
+	product := 0.0
+
+	for i := range a {
+		product += a[i] * b[i]
+	}
+
+	return product
+}
+
+// studentTQuantile returns the quantile of the probability (between 0.5 and 1) for the Student's t-distribution with
+// the degrees of freedom. The quantile is found by bisection of the cumulative distribution function
+func studentTQuantile(probability float64, dof float64) float64 {
+	low, high := 0.0, 1.0
+
+	for studentTCDF(high, dof) < probability {
+		low, high = high, 2*high
+	}
+
+	for i := 0; i < 100 && high-low > 1e-10; i++ {
+		middle := (low + high) / 2
+
+		if studentTCDF(middle, dof) < probability {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return (low + high) / 2
+}
+
+// studentTCDF returns the cumulative distribution function of the Student's t-distribution with the degrees of freedom
+// for a non-negative value, using the regularized incomplete beta function
+func studentTCDF(value float64, dof float64) float64 {
+	return 1 - incompleteBeta(dof/2, 0.5, dof/(dof+value*value))/2
+}
+
+// incompleteBeta returns the regularized incomplete beta function I_x(a, b), evaluated with its continued fraction
+// (Lentz's method) on the side of x where it converges quickly
+func incompleteBeta(a float64, b float64, x float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+
+	if x > (a+1)/(a+b+2) {
+		return 1 - incompleteBeta(b, a, 1-x)
+	}
+
+	lgammaA, _ := math.Lgamma(a)
+	lgammaB, _ := math.Lgamma(b)
+	lgammaAB, _ := math.Lgamma(a + b)
+	front := math.Exp(lgammaAB - lgammaA - lgammaB + a*math.Log(x) + b*math.Log(1-x))
+
+	const tiny = 1e-300
+
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+
+	d = 1 / d
+	fraction := d
+
+	for m := 1.0; m <= 300; m++ {
+		for _, numerator := range []float64{
+			m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m)),
+			-(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1)),
+		} {
+			d = 1 + numerator*d
+
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+
+			c = 1 + numerator/c
+
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+
+			d = 1 / d
+			fraction *= d * c
+		}
+
+		if math.Abs(d*c-1) < 1e-15 {
+			break
+		}
+	}
+
+	return front * fraction / a
+}
+
+// seasonOf returns the season of the period starting at the start time, and the number of seasons in a year. Seasons
+// are the months of the year for monthly periods, and the ISO weeks of the year for weekly periods (the rare 53rd week
+// is merged with the 52nd)
+func seasonOf(bucket timeBucket, start time.Time) (int, int) {
+	if bucket.name == "week" {
+		_, week := start.ISOWeek()
+
+		return int(math.Min(float64(week), 52)) - 1, 52
+	}
+
+	return int(start.Month()) - 1, 12
+}
+
+// toRateValue converts a bytes value to MB, so that bytes rates are expressed in MB the same way as the rate columns,
+// other values are returned as they are
+func toRateValue(columnName string, value float64) float64 {
+	if strings.HasSuffix(columnName, "_bytes") {
+		return value / bytesPerMB
+	}
+
+	return value
+}
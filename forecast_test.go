@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFitTrend(t *testing.T) {
+	bucket, _ := chooseTimeBucket("month")
+
+	var starts []time.Time
+	var linear, seasonal []float64
+
+	for i := 0; i < 24; i++ {
+		start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, i, 0)
+		starts = append(starts, start)
+		linear = append(linear, 1000+50*float64(i))
+
+		// December peak on top of the linear trend
+		if start.Month() == time.December {
+			seasonal = append(seasonal, 1000+50*float64(i)+400)
+		} else {
+			seasonal = append(seasonal, 1000+50*float64(i))
+		}
+	}
+
+	model, err := fitTrend(bucket, starts[:12], linear[:12])
+
+	if err != nil {
+		t.Fatalf("Expecting linear trend to be fitted, but got %s", err)
+	}
+
+	if value, lower, upper := model.predict(12, starts[12], 95); math.Abs(value-1600) > 1e-6 ||
+		math.Abs(lower-value) > 1e-6 || math.Abs(upper-value) > 1e-6 {
+		t.Errorf("Expecting exact projection 1600, but got %f [%f, %f]", value, lower, upper)
+	}
+
+	model, err = fitTrend(bucket, starts, seasonal)
+
+	if err != nil {
+		t.Fatalf("Expecting seasonal trend to be fitted, but got %s", err)
+	}
+
+	december := time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+	if value, _, _ := model.predict(35, december, 95); math.Abs(value-(1000+50*35+400)) > 1e-6 {
+		t.Errorf("Expecting December projection %d, but got %f", 1000+50*35+400, value)
+	}
+
+	if _, err = fitTrend(bucket, starts[:2], linear[:2]); err == nil {
+		t.Errorf("Expecting error fitting two periods, but got nil")
+	}
+}
+
+func TestFitTrendConfidence(t *testing.T) {
+	bucket, _ := chooseTimeBucket("week")
+
+	var starts []time.Time
+	var values []float64
+
+	for i := 0; i < 20; i++ {
+		starts = append(starts, time.Date(2019, time.January, 7, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7*i))
+		values = append(values, 100+float64(i%2)*10)
+	}
+
+	model, _ := fitTrend(bucket, starts, values)
+	value, lower90, upper90 := model.predict(20, starts[19].AddDate(0, 0, 7), 90)
+	_, lower99, upper99 := model.predict(20, starts[19].AddDate(0, 0, 7), 99)
+
+	if !(lower99 < lower90 && lower90 < value && value < upper90 && upper90 < upper99) {
+		t.Errorf("Expecting nested intervals around %f, but got [%f, %f] and [%f, %f]", value, lower90, upper90,
+			lower99, upper99)
+	}
+}
+
+func TestAggregateThroughput(t *testing.T) {
+	bucket, _ := chooseTimeBucket("day")
+	hourly := map[string]map[string]float64{
+		"2019032423": {"input_cdrs": 7200},
+		"2019032510": {"input_cdrs": 3600, "input_bytes": 3600e6},
+		"2019032511": {"input_cdrs": 36000},
+		"2019032609": {"input_cdrs": 100},
+	}
+
+	// The first and last days are partial, so only the 25th is kept
+	period := &reportPeriod{
+		start: time.Date(2019, 3, 24, 12, 0, 0, 0, time.UTC),
+		end:   time.Date(2019, 3, 26, 12, 0, 0, 0, time.UTC),
+	}
+
+	history := aggregateThroughput(hourly, []string{"input_cdrs", "input_bytes"}, period, bucket)
+
+	if len(history.starts) != 1 || !history.starts[0].Equal(time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expecting only 2019-03-25 in history, but got %v", history.starts)
+	}
+
+	if history.volumes["input_cdrs"][0] != 39600 || history.peaks["input_cdrs"][0] != 10 {
+		t.Errorf("Expecting volume 39600 and peak 10, but got %f and %f", history.volumes["input_cdrs"][0],
+			history.peaks["input_cdrs"][0])
+	}
+
+	if history.peaks["input_bytes"][0] != 1 {
+		t.Errorf("Expecting bytes peak 1 MB per second, but got %f", history.peaks["input_bytes"][0])
+	}
+
+	// Average rate is 39600 / 86400 per second, and peak rate is 10 per second
+	if factor := history.peakFactor("input_cdrs"); math.Abs(factor-10/(39600.0/86400)) > 1e-9 {
+		t.Errorf("Expecting peak factor %f, but got %f", 10/(39600.0/86400), factor)
+	}
+}
+
+func TestForecastTable(t *testing.T) {
+	report := &Report{}
+	rows := []forecastRow{
+		{target: "Server1 Logical Server", label: "201904", column: "input_cdrs", value: 100, peakRate: 5,
+			capacity: 10},
+		{target: "Server1 Logical Server", label: "201905", column: "input_cdrs", value: 300, peakRate: 15,
+			capacity: 10},
+		{target: "Server1 Logical Server", label: "201906", column: "input_cdrs", value: 400, peakRate: 20,
+			capacity: 10},
+		{target: "ryd2 Cluster", label: "201904", column: "input_cdrs", value: 400, peakRate: 20},
+	}
+
+	table := forecastTable(report, rows)
+
+	if len(table.highlights) != 2 || !table.highlights[cell{row: 1, column: 6}] ||
+		!table.highlights[cell{row: 2, column: 6}] {
+		t.Errorf("Expecting peak rates of rows 1 and 2 highlighted, but got %v", table.highlights)
+	}
+
+	expected := "input_cdrs_per_sec exceeds 10 from 201905"
+
+	if len(report.headers) != 1 || report.headers[0].value != expected {
+		t.Errorf("Expecting single header %s, but got %v", expected, report.headers)
+	}
+}
+
+func TestDefaultForecastHistory(t *testing.T) {
+	now := time.Date(2019, time.March, 27, 10, 30, 0, 0, time.UTC)
+
+	for _, groupBy := range []string{"month", "week"} {
+		bucket, _ := chooseTimeBucket(groupBy)
+		start, end := defaultForecastHistory(bucket, now)
+		history := aggregateThroughput(map[string]map[string]float64{}, nil, &reportPeriod{start: start, end: end},
+			bucket)
+
+		if _, seasonLength := seasonOf(bucket, start); len(history.starts) < 2*seasonLength {
+			t.Errorf("Expecting at least two seasons of %s periods, but got %d", groupBy, len(history.starts))
+		}
+	}
+}
+
+func TestStudentTQuantile(t *testing.T) {
+	tests := []struct {
+		probability float64
+		dof         float64
+		expected    float64
+	}{
+		{0.975, 10, 2.228139},
+		{0.95, 5, 2.015048},
+		{0.995, 1, 63.656741},
+		{0.9, 100, 1.290075},
+	}
+
+	for _, test := range tests {
+		if quantile := studentTQuantile(test.probability, test.dof); math.Abs(quantile-test.expected) > 1e-5 {
+			t.Errorf("Expecting quantile %f of %f with %f degrees of freedom, but got %f", test.expected,
+				test.probability, test.dof, quantile)
+		}
+	}
+}
+
+func TestInvertMatrix(t *testing.T) {
+	inverse, err := invertMatrix([][]float64{{4, 7}, {2, 6}})
+
+	if err != nil {
+		t.Fatalf("Expecting matrix to be inverted, but got %s", err)
+	}
+
+	expected := [][]float64{{0.6, -0.7}, {-0.2, 0.4}}
+
+	for i := range expected {
+		for j := range expected[i] {
+			if math.Abs(inverse[i][j]-expected[i][j]) > 1e-12 {
+				t.Errorf("Expecting inverse %v, but got %v", expected, inverse)
+			}
+		}
+	}
+
+	if _, err = invertMatrix([][]float64{{1, 2}, {2, 4}}); err == nil {
+		t.Errorf("Expecting error inverting a singular matrix")
+	}
+}
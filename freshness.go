@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// collectorNode and distributorNode are the node types of the freshness report
+	collectorNode   = "collector"
+	distributorNode = "distributor"
+
+	// neverSeen is displayed as last seen time of the nodes without any processed file
+	neverSeen = "never"
+
+	// silentStatus is the status of the nodes silent for longer than the max silence
+	silentStatus = "SILENT"
+
+	// freshnessLookbackFactor is the multiple of the largest max silence scanned for the last seen times, the lookback
+	// window is at least defaultFreshnessLookback so that nodes silent for a long time are still reported
+	freshnessLookbackFactor  = 10
+	defaultFreshnessLookback = 7 * 24 * time.Hour
+)
+
+// freshnessColumns are the columns of the freshness report
+var freshnessColumns = []string{"stream", "node_type", "node", "last_seen", "silent_for", "max_silence", "status"}
+
+// nodeFreshness is the last time a collector or a distributor processed a file
+type nodeFreshness struct {
+	nodeType string
+	name     string
+	id       string
+	lastSeen string
+	silence  time.Duration
+}
+
+// logicalServerStreams are the streams running in the same logical server, their nodes are queried at once
+type logicalServerStreams struct {
+	logicalServer *LogicalServer
+	cluster       *Cluster
+	streams       []*Stream
+}
+
+// freshness reports for every stream the last time each of its collectors and distributors processed a file, and
+// marks the nodes silent for longer than the max silence of the stream. Logical servers are queried concurrently,
+// with a single query per logical server covering the nodes of all its streams. Only the files processed in the
+// lookback window are scanned, nodes not seen in the window are reported as never seen
+func freshness(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	defaultMaxSilence, err := parseMaxSilence(context.String("max-silence"))
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid max silence %s", context.String("max-silence")), errorExitCode)
+	}
+
+	streams, groups, err := groupStreamsByLogicalServer(context)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	lookback, err := freshnessLookback(streams, defaultMaxSilence)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	var wait sync.WaitGroup
+
+	nodes := make([][]nodeFreshness, len(groups))
+	errors := make([]error, len(groups))
+
+	s.Prefix = "Streams Freshness "
+	s.Start()
+
+	for i, group := range groups {
+		wait.Add(1)
+
+		go func(i int, group *logicalServerStreams) {
+			defer wait.Done()
+			nodes[i], errors[i] = group.queryFreshness(context, lookback)
+		}(i, group)
+	}
+
+	wait.Wait()
+	s.Stop()
+
+	report := &Report{name: "Streams Freshness"}
+	records := [][]string{freshnessColumns}
+	table := &ResultSet{
+		columnsNames:     freshnessColumns,
+		columnsDataTypes: map[string]series.Type{},
+		highlights:       map[cell]bool{},
+		highlightCaption: "! Silent longer than max silence",
+	}
+
+	for _, columnName := range freshnessColumns {
+		table.columnsDataTypes[columnName] = series.String
+	}
+
+	timeZone := context.String("timezone")
+
+	if len(timeZone) == 0 {
+		timeZone = "Time zone of each cluster"
+	}
+
+	report.AddHeader("Time Zone", timeZone)
+
+	if !timeOptionsSet(context) {
+		report.AddHeader("Lookback", lookback.String())
+	}
+
+	// Streams running in several logical servers are seen in any of them
+	streamNodes := map[*Stream][]nodeFreshness{}
+
+	for i, group := range groups {
+		if errors[i] != nil {
+			logger.WithFields(logrus.Fields{
+				"command":        "freshness",
+				"logical_server": group.logicalServer.Name,
+				"error":          errors[i],
+			}).Error("Querying nodes freshness")
+
+			continue
+		}
+
+		for _, stream := range group.streams {
+			streamNodes[stream] = append(streamNodes[stream], nodes[i]...)
+		}
+	}
+
+	for _, stream := range streams {
+		if _, queried := streamNodes[stream]; !queried {
+			continue
+		}
+
+		maxSilence := defaultMaxSilence
+
+		if len(stream.MaxSilence) > 0 {
+			if maxSilence, err = parseMaxSilence(stream.MaxSilence); err != nil {
+				return cli.Exit(fmt.Sprintf("%s stream: %s", stream.Name, err), errorExitCode)
+			}
+		}
+
+		for _, record := range streamFreshness(stream, streamNodes[stream], maxSilence) {
+			if record[len(record)-1] == silentStatus {
+				table.highlights[cell{row: len(records) - 1, column: len(record) - 1}] = true
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	if len(records) > 1 {
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+	}
+
+	report.defaultTable = table
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// groupStreamsByLogicalServer groups the streams by the logical servers they are running in, streams running in
+// several logical servers are in several groups. Only the streams specified in the options are included if any, and
+// they are returned in the order of EMM configuration
+func groupStreamsByLogicalServer(context *cli.Context) ([]*Stream, []*logicalServerStreams, error) {
+	var streams []*Stream
+	var groups []*logicalServerStreams
+
+	targets, err := resolveThroughputTargets(context)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, target := range targets {
+		streams = append(streams, target.stream)
+
+		for _, server := range target.servers {
+			var group *logicalServerStreams
+
+			for _, existing := range groups {
+				if existing.logicalServer == server.logicalServer {
+					group = existing
+				}
+			}
+
+			if group == nil {
+				group = &logicalServerStreams{logicalServer: server.logicalServer, cluster: server.cluster}
+				groups = append(groups, group)
+			}
+
+			group.streams = append(group.streams, target.stream)
+		}
+	}
+
+	return streams, groups, nil
+}
+
+// freshnessLookback returns the lookback window of the freshness query, which is a multiple of the largest max silence
+// of the streams, and at least the default lookback
+func freshnessLookback(streams []*Stream, defaultMaxSilence time.Duration) (time.Duration, error) {
+	largest := defaultMaxSilence
+
+	for _, stream := range streams {
+		if len(stream.MaxSilence) == 0 {
+			continue
+		}
+
+		maxSilence, err := parseMaxSilence(stream.MaxSilence)
+
+		if err != nil {
+			return 0, fmt.Errorf("%s stream: %s", stream.Name, err)
+		}
+
+		if maxSilence > largest {
+			largest = maxSilence
+		}
+	}
+
+	if lookback := freshnessLookbackFactor * largest; lookback > defaultFreshnessLookback {
+		return lookback, nil
+	}
+
+	return defaultFreshnessLookback, nil
+}
+
+// timeOptionsSet checks if any of the time options is specified
+func timeOptionsSet(context *cli.Context) bool {
+	return len(context.String("range")) > 0 || len(context.String("start-time")) > 0 ||
+		len(context.String("end-time")) > 0
+}
+
+// freshnessQuery returns the query of the last time each collector and distributor of the streams was seen, and the
+// values bound to its placeholders. Exclusions of a stream must not hide the nodes of the other streams, so they are
+// applied to the query results by streamFreshness
+func (g *logicalServerStreams) freshnessQuery(period *reportPeriod) (string, []interface{}) {
+	params := AudittrailLogEntryQueryParameters{
+		StartTime:  period.start.Format(timeFlagFormat),
+		TimeZone:   period.timeZone,
+		DBTimeZone: period.dbTimeZone,
+	}
+
+	for _, stream := range g.streams {
+		params.InnodeNames = append(params.InnodeNames, stream.CollectorNames...)
+		params.InnodeIds = append(params.InnodeIds, stream.CollectorIds...)
+		params.InnodePatterns = append(params.InnodePatterns, stream.CollectorPatterns...)
+		params.OutnodeNames = append(params.OutnodeNames, stream.DistributorNames...)
+		params.OutnodeIds = append(params.OutnodeIds, stream.DistributorIds...)
+		params.OutnodePatterns = append(params.OutnodePatterns, stream.DistributorPatterns...)
+	}
+
+	return parseTemplate("freshness", freshnessQueryTemplate, params)
+}
+
+// queryFreshness executes the freshness query in the logical server database. The files are scanned from the start of
+// the period of the time options if specified, otherwise from the start of the lookback window
+func (g *logicalServerStreams) queryFreshness(context *cli.Context, lookback time.Duration) ([]nodeFreshness, error) {
+	var nodes []nodeFreshness
+
+	period, err := resolveReportPeriod(context, g.cluster)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !timeOptionsSet(context) {
+		period.start = period.end.Add(-lookback)
+	}
+
+	query, args := g.freshnessQuery(period)
+
+	logger.WithFields(logrus.Fields{
+		"command":        "freshness",
+		"logical_server": g.logicalServer.Name,
+		"query":          query,
+		"args":           args,
+	}).Debug("Freshness query")
+
+	session := CreateSession(g.logicalServer)
+
+	if session == nil {
+		return nil, fmt.Errorf("Cannot open session to %s logical server", g.logicalServer.Name)
+	}
+
+	report, err := session.executeQuery(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range tableRows(report.GetDefaultTable()) {
+		seconds, _ := strconv.ParseInt(row["silence_seconds"], 10, 64)
+
+		nodes = append(nodes, nodeFreshness{
+			nodeType: row["node_type"],
+			name:     row["node_name"],
+			id:       row["node_id"],
+			lastSeen: row["last_seen"],
+			silence:  time.Duration(seconds) * time.Second,
+		})
+	}
+
+	return nodes, nil
+}
+
+// streamFreshness returns the freshness records of the collectors and distributors of the stream. Nodes configured by
+// name are matched by name, and nodes configured by id are matched by id. Nodes which were never seen are always
+// silent if a max silence is specified. Nodes matched by the patterns, and not excluded, follow the configured nodes
+func streamFreshness(stream *Stream, nodes []nodeFreshness, maxSilence time.Duration) [][]string {
+	var records [][]string
+
+	configured := []struct {
+		nodeType string
+		values   []string
+		byID     bool
+	}{
+		{collectorNode, stream.CollectorNames, false},
+		{collectorNode, stream.CollectorIds, true},
+		{distributorNode, stream.DistributorNames, false},
+		{distributorNode, stream.DistributorIds, true},
+	}
+
+	seen := map[string]bool{}
+
+	for _, nodesGroup := range configured {
+		for _, value := range nodesGroup.values {
+			label := value
+
+			if nodesGroup.byID {
+				label = fmt.Sprintf("id %s", value)
+			}
+
+			// Configurations may list the same node several times
+			if seen[nodesGroup.nodeType+label] {
+				continue
+			}
+
+			seen[nodesGroup.nodeType+label] = true
+
+			latest := findLatestNode(nodes, nodesGroup.nodeType, value, nodesGroup.byID)
+			record := []string{stream.Name, nodesGroup.nodeType, label, neverSeen, "", "", "OK"}
+
+			if latest != nil {
+				record[3] = latest.lastSeen
+				record[4] = latest.silence.String()
+			}
+
+			if maxSilence > 0 {
+				record[5] = maxSilence.String()
+
+				if latest == nil || latest.silence > maxSilence {
+					record[6] = silentStatus
+				}
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	patterns := []struct {
+		nodeType   string
+		patterns   []string
+		exclusions []string
+	}{
+		{collectorNode, stream.CollectorPatterns, stream.CollectorExclusions},
+		{distributorNode, stream.DistributorPatterns, stream.DistributorExclusions},
+	}
+
+	for _, nodesGroup := range patterns {
+		var names []string
+
+		for _, node := range nodes {
+			if node.nodeType != nodesGroup.nodeType || seen[node.nodeType+node.name] ||
+				!matchNodePatterns(nodesGroup.patterns, node.name) ||
+				matchNodePatterns(nodesGroup.exclusions, node.name) {
+				continue
+			}
+
+			seen[node.nodeType+node.name] = true
+			names = append(names, node.name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			latest := findLatestNode(nodes, nodesGroup.nodeType, name, false)
+			record := []string{stream.Name, nodesGroup.nodeType, name, latest.lastSeen, latest.silence.String(), "",
+				"OK"}
+
+			if maxSilence > 0 {
+				record[5] = maxSilence.String()
+
+				if latest.silence > maxSilence {
+					record[6] = silentStatus
+				}
+			}
+
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// matchNodePatterns returns true if the node name matches any of the patterns
+func matchNodePatterns(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchNodePattern(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findLatestNode returns the most recently seen node matching the name or the id, or nil if the node was never seen
+func findLatestNode(nodes []nodeFreshness, nodeType string, value string, byID bool) *nodeFreshness {
+	var latest *nodeFreshness
+
+	for i, node := range nodes {
+		if node.nodeType != nodeType || (byID && node.id != value) || (!byID && node.name != value) {
+			continue
+		}
+
+		if latest == nil || node.silence < latest.silence {
+			latest = &nodes[i]
+		}
+	}
+
+	return latest
+}
+
+// parseMaxSilence parses a max silence duration (e.g. 30m, 2h), empty duration means no max silence
+func parseMaxSilence(expression string) (time.Duration, error) {
+	if len(expression) == 0 {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(expression)
+
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid max silence %s", expression)
+	}
+
+	return duration, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamFreshness(t *testing.T) {
+	stream := &Stream{
+		Name:             "HWPGW",
+		CollectorNames:   []string{"INPUT", "INPUT"},
+		DistributorNames: []string{"BI", "RA"},
+		DistributorIds:   []string{"14025"},
+	}
+
+	nodes := []nodeFreshness{
+		{nodeType: collectorNode, name: "INPUT", id: "1", lastSeen: "2019-03-25 10:00:00", silence: time.Hour},
+		{nodeType: collectorNode, name: "INPUT", id: "2", lastSeen: "2019-03-25 10:50:00", silence: 10 * time.Minute},
+		{nodeType: distributorNode, name: "BI", id: "3", lastSeen: "2019-03-25 09:00:00", silence: 2 * time.Hour},
+		{nodeType: distributorNode, name: "RA", id: "14025", lastSeen: "2019-03-25 10:59:00", silence: time.Minute},
+	}
+
+	expected := [][]string{
+		{"HWPGW", collectorNode, "INPUT", "2019-03-25 10:50:00", "10m0s", "30m0s", "OK"},
+		{"HWPGW", distributorNode, "BI", "2019-03-25 09:00:00", "2h0m0s", "30m0s", silentStatus},
+		{"HWPGW", distributorNode, "RA", "2019-03-25 10:59:00", "1m0s", "30m0s", "OK"},
+		{"HWPGW", distributorNode, "id 14025", "2019-03-25 10:59:00", "1m0s", "30m0s", "OK"},
+	}
+
+	if records := streamFreshness(stream, nodes, 30*time.Minute); !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+
+	stream.CollectorNames = []string{"Missing"}
+
+	if records := streamFreshness(stream, nodes, 0); records[0][3] != neverSeen || records[0][6] != "OK" {
+		t.Errorf("Expecting never seen node without max silence to be OK, but got %v", records[0])
+	}
+
+	if records := streamFreshness(stream, nodes, time.Hour); records[0][6] != silentStatus {
+		t.Errorf("Expecting never seen node to be silent, but got %v", records[0])
+	}
+}
+
+func TestFreshnessQuery(t *testing.T) {
+	group := &logicalServerStreams{streams: []*Stream{
+		{CollectorNames: []string{"INPUT"}},
+		{DistributorIds: []string{"14025"}},
+	}}
+
+	start := time.Date(2019, 3, 18, 11, 0, 0, 0, time.UTC)
+	query, args := group.freshnessQuery(&reportPeriod{start: start, timeZone: "Asia/Riyadh", dbTimeZone: "UTC"})
+
+	for _, expected := range []string{
+		"intime >= ((to_timestamp('20190318110000', 'YYYYMMDDHH24MISS')::timestamp AT TIME ZONE 'Asia/Riyadh') " +
+			"AT TIME ZONE 'UTC')AND (trim(innodename) IN ($1))\n",
+		"outtime >= ((to_timestamp('20190318110000', 'YYYYMMDDHH24MISS')::timestamp AT TIME ZONE 'Asia/Riyadh') " +
+			"AT TIME ZONE 'UTC')AND (outnodeid IN ($2))\n",
+		"Max(((intime AT TIME ZONE 'UTC') AT TIME ZONE 'Asia/Riyadh'))",
+		"now() - Max((outtime AT TIME ZONE 'UTC'))",
+	} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("Expecting query to contain %s, but got %s", expected, query)
+		}
+	}
+
+	if expected := []interface{}{"INPUT", "14025"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, args)
+	}
+}
+
+func TestQueryFreshness_QueryFailure(t *testing.T) {
+	group := &logicalServerStreams{logicalServer: failingLogicalServer("Failing3"),
+		streams: []*Stream{{CollectorNames: []string{"INPUT"}}}}
+
+	if nodes, err := group.queryFreshness(newTestContext(freshnessCommand, ioutil.Discard), time.Hour); err == nil {
+		t.Errorf("Expecting query error, but got %v", nodes)
+	}
+}
+
+func TestFreshnessLookback(t *testing.T) {
+	streams := []*Stream{{Name: "HWPGW", MaxSilence: "48h"}, {Name: "4G"}}
+
+	if lookback, err := freshnessLookback(streams, time.Hour); err != nil || lookback != 20*24*time.Hour {
+		t.Errorf("Expecting lookback of 10 times the largest max silence, but got %s (%v)", lookback, err)
+	}
+
+	if lookback, _ := freshnessLookback(streams[1:], time.Hour); lookback != defaultFreshnessLookback {
+		t.Errorf("Expecting default lookback, but got %s", lookback)
+	}
+
+	if _, err := freshnessLookback([]*Stream{{Name: "HWPGW", MaxSilence: "x"}}, 0); err == nil {
+		t.Errorf("Expecting invalid max silence error")
+	}
+}
+
+func TestStreamFreshness_Patterns(t *testing.T) {
+	stream := &Stream{
+		Name:                "4G",
+		CollectorNames:      []string{"INPUT"},
+		CollectorPatterns:   []string{"4G_%"},
+		CollectorExclusions: []string{"%_TEST"},
+	}
+
+	nodes := []nodeFreshness{
+		{nodeType: collectorNode, name: "INPUT", lastSeen: "2019-03-25 10:00:00", silence: time.Minute},
+		{nodeType: collectorNode, name: "4G_RD2", lastSeen: "2019-03-25 09:00:00", silence: time.Hour},
+		{nodeType: collectorNode, name: "4G_RD1", lastSeen: "2019-03-25 10:00:00", silence: time.Minute},
+		{nodeType: collectorNode, name: "4G_RD1", lastSeen: "2019-03-25 08:00:00", silence: 2 * time.Hour},
+		{nodeType: collectorNode, name: "4G_TEST", lastSeen: "2019-03-25 10:00:00", silence: time.Minute},
+		{nodeType: distributorNode, name: "4G_OUT", lastSeen: "2019-03-25 10:00:00", silence: time.Minute},
+	}
+
+	var names []string
+
+	for _, record := range streamFreshness(stream, nodes, 0) {
+		names = append(names, record[2]+" "+record[3])
+	}
+
+	expected := []string{"INPUT 2019-03-25 10:00:00", "4G_RD1 2019-03-25 10:00:00", "4G_RD2 2019-03-25 09:00:00"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, names)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"gopkg.in/urfave/cli.v2"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maskedSecret replaces the secrets (e.g. passwords) in the inventory reports
+const maskedSecret = "********"
+
+// Columns of the inventory reports
+var (
+	clustersColumns = []string{"cluster", "username", "password", "timezone", "logical_servers", "streams"}
+	serversColumns  = []string{"cluster", "logical_server", "host", "port", "database", "username", "password",
+		"sslmode", "streams", "checks", "capacity"}
+	streamsColumns = []string{"stream", "group", "logical_servers", "collectors", "distributors", "coll_patterns",
+		"dist_patterns", "exclusions", "checks", "max_silence"}
+)
+
+// listClusters lists the clusters of EMM configuration matching the cluster option, with the number of their logical
+// servers and streams
+func listClusters(context *cli.Context) error {
+	if emmConfig == nil {
+		return cli.Exit("EMM configuration file is not loaded", errorExitCode)
+	}
+
+	var records [][]string
+
+	for _, cluster := range emmConfig.Clusters {
+		if !matchName(context.String("cluster"), cluster.Name) {
+			continue
+		}
+
+		streams := 0
+
+		for _, logicalServer := range cluster.LogicalServers {
+			streams += len(emmConfig.FindLogicalServerStreams(logicalServer.Name, cluster.Name))
+		}
+
+		records = append(records, []string{cluster.Name, cluster.Username, maskSecret(cluster.Password),
+			cluster.TimeZone, strconv.Itoa(len(cluster.LogicalServers)), strconv.Itoa(streams)})
+	}
+
+	return outputInventory(context, "Clusters", clustersColumns, []string{"logical_servers", "streams"}, records)
+}
+
+// listServers lists the logical servers of EMM configuration matching the cluster and logical server options, with
+// their resolved connection details, and the number of their streams and checks
+func listServers(context *cli.Context) error {
+	if emmConfig == nil {
+		return cli.Exit("EMM configuration file is not loaded", errorExitCode)
+	}
+
+	var records [][]string
+
+	for _, cluster := range emmConfig.Clusters {
+		if !matchName(context.String("cluster"), cluster.Name) {
+			continue
+		}
+
+		for _, logicalServer := range cluster.LogicalServers {
+			if !matchName(context.String("lserver"), logicalServer.Name) {
+				continue
+			}
+
+			var capacity []string
+
+			for columnName, rate := range logicalServer.Capacity {
+				capacity = append(capacity, fmt.Sprintf("%s=%s", columnName, formatFloat(rate)))
+			}
+
+			sort.Strings(capacity)
+
+			records = append(records, []string{cluster.Name, logicalServer.Name, logicalServer.IP,
+				logicalServer.Port, logicalServer.Database, logicalServer.Username,
+				maskSecret(logicalServer.Password), logicalServer.SSLMode,
+				strconv.Itoa(len(emmConfig.FindLogicalServerStreams(logicalServer.Name, cluster.Name))),
+				strconv.Itoa(len(logicalServer.Checks)), strings.Join(capacity, " ")})
+		}
+	}
+
+	return outputInventory(context, "Logical Servers", serversColumns, []string{"streams", "checks"}, records)
+}
+
+// listStreams lists the streams of EMM configuration matching the stream options, or running in the logical servers
+// matching the cluster and logical server options, with the number of their nodes, patterns and checks
+func listStreams(context *cli.Context) error {
+	if emmConfig == nil {
+		return cli.Exit("EMM configuration file is not loaded", errorExitCode)
+	}
+
+	var records [][]string
+
+	streams := emmConfig.Streams
+
+	if streamArgs := context.StringSlice("stream"); len(streamArgs) > 0 {
+		var err error
+
+		if streams, err = emmConfig.FindStreams(streamArgs); err != nil {
+			return cli.Exit(err.Error(), errorExitCode)
+		}
+	}
+
+	for _, stream := range streams {
+		var servers []string
+
+		matches := false
+
+		for _, server := range stream.AssignedLogicalServers() {
+			servers = append(servers, fmt.Sprintf("%s (%s)", server.Name, server.Cluster))
+			matches = matches || (matchName(context.String("cluster"), server.Cluster) &&
+				matchName(context.String("lserver"), server.Name))
+		}
+
+		if !matches && (len(context.String("cluster")) > 0 || len(context.String("lserver")) > 0) {
+			continue
+		}
+
+		records = append(records, []string{stream.Name, stream.Group, strings.Join(servers, ", "),
+			strconv.Itoa(countUnique(stream.CollectorNames) + countUnique(stream.CollectorIds)),
+			strconv.Itoa(countUnique(stream.DistributorNames) + countUnique(stream.DistributorIds)),
+			strconv.Itoa(len(stream.CollectorPatterns)), strconv.Itoa(len(stream.DistributorPatterns)),
+			strconv.Itoa(len(stream.CollectorExclusions) + len(stream.DistributorExclusions)),
+			strconv.Itoa(len(stream.Checks)), stream.MaxSilence})
+	}
+
+	return outputInventory(context, "Streams", streamsColumns, streamsColumns[3:9], records)
+}
+
+// outputInventory writes the inventory records using the output options of the reports
+func outputInventory(context *cli.Context, name string, columns []string, countColumns []string,
+	records [][]string) error {
+
+	report := &Report{name: name}
+	table := &ResultSet{columnsNames: columns, columnsDataTypes: map[string]series.Type{}}
+
+	for _, columnName := range columns {
+		table.columnsDataTypes[columnName] = series.String
+	}
+
+	for _, columnName := range countColumns {
+		table.columnsDataTypes[columnName] = series.Int
+	}
+
+	if len(records) > 0 {
+		table.data = dataframe.LoadRecords(append([][]string{columns}, records...),
+			dataframe.WithTypes(table.columnsDataTypes))
+	}
+
+	report.defaultTable = table
+	report.AddHeader("Configuration File", configFileName(context))
+
+	if environment := context.String("environment"); len(environment) > 0 {
+		report.AddHeader("Environment", environment)
+	}
+
+	return outputReport(context, report, report.GetDefaultTable())
+}
+
+// matchName returns true if the name matches the glob pattern, empty pattern matches all the names
+func matchName(pattern string, name string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+
+	matches, err := path.Match(pattern, name)
+
+	return err == nil && matches
+}
+
+// countUnique returns the number of distinct values, configurations may list the same node several times
+func countUnique(values []string) int {
+	unique := map[string]bool{}
+
+	for _, value := range values {
+		unique[value] = true
+	}
+
+	return len(unique)
+}
+
+// maskSecret masks the secret if it is specified
+func maskSecret(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+
+	return maskedSecret
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConfig_ResolveDefaults(t *testing.T) {
+	config := &Config{Clusters: []*Cluster{{
+		Name:     "ryd2",
+		Username: "mmsuper",
+		Password: "mediation",
+		SSLMode:  "verify-full",
+		SSLCert:  "/etc/emm/client.crt",
+		LogicalServers: []*LogicalServer{
+			{Name: "Server1"},
+			{Name: "Server2", Username: "reader", Password: "secret", Port: "5433", SSLCert: "/etc/emm/s2.crt"},
+		},
+	}, {
+		Name:           "dev",
+		LogicalServers: []*LogicalServer{{Name: "Server11"}},
+	}}}
+
+	config.resolveDefaults()
+
+	first, second := config.Clusters[0].LogicalServers[0], config.Clusters[0].LogicalServers[1]
+
+	if first.Username != "mmsuper" || first.Password != "mediation" || first.Port != defaultDBPort {
+		t.Errorf("Expecting cluster credentials and default port, but got %+v", first)
+	}
+
+	if second.Username != "reader" || second.Password != "secret" || second.Port != "5433" ||
+		second.SSLMode != "verify-full" || second.SSLCert != "/etc/emm/s2.crt" {
+		t.Errorf("Expecting logical server connection details to be kept, but got %+v", second)
+	}
+
+	if first.SSLMode != "verify-full" || first.SSLCert != "/etc/emm/client.crt" {
+		t.Errorf("Expecting cluster SSL settings, but got %+v", first)
+	}
+
+	if third := config.Clusters[1].LogicalServers[0]; third.SSLMode != defaultSSLMode {
+		t.Errorf("Expecting default SSL mode, but got %+v", third)
+	}
+}
+
+func TestConfig_FindLogicalServerStreams(t *testing.T) {
+	config := Config{Streams: []*Stream{
+		{Name: "UAT_Test", LogicalServer: &AssignedLogicalServer{Name: "Server1", Cluster: "ryd2"}},
+		{Name: "HW", LogicalServers: []*AssignedLogicalServer{{Name: "Server11", Cluster: "dev"},
+			{Name: "Server1", Cluster: "ryd2"}}},
+		{Name: "4G", LogicalServer: &AssignedLogicalServer{Name: "Server1", Cluster: "dev"}},
+	}}
+
+	streams := config.FindLogicalServerStreams("Server1", "ryd2")
+
+	if len(streams) != 2 || streams[0].Name != "UAT_Test" || streams[1].Name != "HW" {
+		t.Errorf("Expecting UAT_Test and HW streams, but got %v", streams)
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"", "ryd2", true},
+		{"ryd*", "ryd2", true},
+		{"Server?", "Server11", false},
+		{"[", "ryd2", false},
+	}
+
+	for _, test := range tests {
+		if matches := matchName(test.pattern, test.name); matches != test.expected {
+			t.Errorf("Expecting %s matching %s to be %v, but got %v", test.pattern, test.name, test.expected, matches)
+		}
+	}
+
+	if secret := maskSecret("mediation"); secret != maskedSecret {
+		t.Errorf("Expecting %s, but got %s", maskedSecret, secret)
+	}
+
+	if secret := maskSecret(""); secret != "" {
+		t.Errorf("Expecting empty secret, but got %s", secret)
+	}
+}
@@ -15,6 +15,7 @@ var logger = logrus.New()
 
 func main() {
 	logger.SetLevel(logrus.InfoLevel)
+	logger.AddHook(secretsMask)
 
 	app := CreateCliApp()
 	app.Version = fmt.Sprintf("%s - build %s", version, build)
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAlertStateFile contains the last notified state of every check, it is used to de-duplicate alerts
+	defaultAlertStateFile = "emmstats-alerts.json"
+
+	// Supported notifier types
+	webhookNotifierType = "webhook"
+	smtpNotifierType    = "smtp"
+	syslogNotifierType  = "syslog"
+
+	// Supported webhook payload formats
+	slackPayloadFormat = "slack"
+	teamsPayloadFormat = "teams"
+	jsonPayloadFormat  = "json"
+
+	// mimeBoundary separates the parts of the notification emails
+	mimeBoundary = "emmstats-report-boundary"
+
+	// syslogUserFacility is the facility of the syslog messages (user-level messages)
+	syslogUserFacility = 1
+)
+
+// notifierTimeout limits the time spent sending a notification
+var notifierTimeout = 30 * time.Second
+
+// alert is a change of the state of a check, or a repeated notification of a check which is still not OK
+type alert struct {
+	key           string
+	target        string
+	check         string
+	state         int
+	previousState int
+	message       string
+	time          time.Time
+}
+
+// alertState is the last notified state of a check
+type alertState struct {
+	State    int       `json:"state"`
+	Notified time.Time `json:"notified"`
+}
+
+// notifier sends alerts to an external system, the HTML report of the alerts is attached if the system supports it
+type notifier interface {
+	send(alerts []*alert, attachment []byte) error
+}
+
+// webhookNotifier posts the alerts as JSON to a URL, using Slack, Microsoft Teams or generic payloads
+type webhookNotifier struct {
+	config *NotifierConfig
+}
+
+// smtpNotifier sends the alerts in an email with the HTML report attached
+type smtpNotifier struct {
+	config *NotifierConfig
+}
+
+// syslogNotifier sends every alert as a syslog message (RFC 3164) to a local or remote syslog daemon
+type syslogNotifier struct {
+	config *NotifierConfig
+}
+
+// newNotifier creates the notifier of the configuration type
+func newNotifier(config *NotifierConfig) (notifier, error) {
+	switch config.Type {
+	case webhookNotifierType:
+		if len(config.URL) == 0 {
+			return nil, fmt.Errorf("%s notifier: url is missing", config.Name)
+		}
+
+		return &webhookNotifier{config: config}, nil
+	case smtpNotifierType:
+		if len(config.Host) == 0 || len(config.From) == 0 || len(config.To) == 0 {
+			return nil, fmt.Errorf("%s notifier: host, from and to are required", config.Name)
+		}
+
+		return &smtpNotifier{config: config}, nil
+	case syslogNotifierType:
+		return &syslogNotifier{config: config}, nil
+	}
+
+	return nil, fmt.Errorf("%s notifier: unsupported type %s", config.Name, config.Type)
+}
+
+// newAlert creates the alert of a check result
+func newAlert(targetName string, result checkResult, previousState int, now time.Time) *alert {
+	return &alert{
+		key:           fmt.Sprintf("%s/%s", targetName, result.rule.label()),
+		target:        targetName,
+		check:         result.rule.label(),
+		state:         result.state,
+		previousState: previousState,
+		message:       result.message,
+		time:          now,
+	}
+}
+
+// recovery returns true if the alert notifies that a check is back to OK
+func (a *alert) recovery() bool {
+	return a.state == checkOK
+}
+
+// stateName returns the name of the alert state, recoveries are named RECOVERY
+func (a *alert) stateName() string {
+	if a.recovery() {
+		return "RECOVERY"
+	}
+
+	return checkStateNames[a.state]
+}
+
+// summary returns a single line describing the alert
+func (a *alert) summary() string {
+	summary := fmt.Sprintf("%s %s - %s %s: %s", checkPluginName, a.stateName(), a.target, a.check, a.message)
+
+	if a.recovery() {
+		summary += fmt.Sprintf(" (was %s)", checkStateNames[a.previousState])
+	}
+
+	return summary
+}
+
+// pendingAlerts compares the check results with the last notified states, and returns the alerts to send: checks
+// changing state, and checks still not OK since the repeat interval (if specified). Checks back to OK are notified as
+// recoveries. Checks staying in the same state are not notified again, so that alerts are not repeated every run
+func pendingAlerts(targetName string, results []checkResult, states map[string]alertState, now time.Time,
+	repeatInterval time.Duration) []*alert {
+
+	var alerts []*alert
+
+	for _, result := range results {
+		key := fmt.Sprintf("%s/%s", targetName, result.rule.label())
+		previous, found := states[key]
+
+		if !found {
+			previous = alertState{State: checkOK}
+		}
+
+		switch {
+		case result.state == previous.State && result.state == checkOK:
+			continue
+		case result.state == previous.State && (repeatInterval <= 0 || now.Sub(previous.Notified) < repeatInterval):
+			continue
+		}
+
+		alerts = append(alerts, newAlert(targetName, result, previous.State, now))
+	}
+
+	return alerts
+}
+
+// notifyAlerts sends the pending alerts of the check results to all the configured notifiers, and records the
+// notified states. States are recorded only if at least one notifier succeeded, so that failed alerts are sent again
+// in the next run
+func notifyAlerts(config *NotificationsConfig, targetName string, results []checkResult, attachment []byte) error {
+	if config == nil || len(config.Notifiers) == 0 {
+		return fmt.Errorf("no notifiers are defined in EMM configuration")
+	}
+
+	repeatInterval := time.Duration(0)
+
+	if len(config.RepeatInterval) > 0 {
+		var err error
+
+		if repeatInterval, err = time.ParseDuration(config.RepeatInterval); err != nil {
+			return fmt.Errorf("invalid repeat interval %s", config.RepeatInterval)
+		}
+	}
+
+	stateFile := config.StateFile
+
+	if len(stateFile) == 0 {
+		stateFile = defaultAlertStateFile
+	}
+
+	states, err := loadAlertStates(stateFile)
+
+	if err != nil {
+		return err
+	}
+
+	alerts := pendingAlerts(targetName, results, states, time.Now(), repeatInterval)
+
+	if len(alerts) == 0 {
+		logger.WithFields(logrus.Fields{
+			"target": targetName,
+		}).Debug("No alerts to notify")
+
+		return nil
+	}
+
+	sent := false
+
+	for _, notifierConfig := range config.Notifiers {
+		n, err := newNotifier(notifierConfig)
+
+		if err == nil {
+			err = n.send(alerts, attachment)
+		}
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"notifier": notifierConfig.Name,
+				"error":    err,
+			}).Error("Sending alerts")
+
+			continue
+		}
+
+		sent = true
+
+		logger.WithFields(logrus.Fields{
+			"notifier": notifierConfig.Name,
+			"alerts":   len(alerts),
+		}).Debug("Alerts sent")
+	}
+
+	if !sent {
+		return fmt.Errorf("alerts could not be sent by any notifier")
+	}
+
+	for _, a := range alerts {
+		states[a.key] = alertState{State: a.state, Notified: a.time}
+	}
+
+	return saveAlertStates(stateFile, states)
+}
+
+// loadAlertStates reads the last notified states, a missing state file means that nothing was notified yet
+func loadAlertStates(stateFile string) (map[string]alertState, error) {
+	states := map[string]alertState{}
+	content, err := ioutil.ReadFile(stateFile)
+
+	if os.IsNotExist(err) {
+		return states, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read alerts state file %s: %s", stateFile, err)
+	}
+
+	if err = json.Unmarshal(content, &states); err != nil {
+		return nil, fmt.Errorf("cannot parse alerts state file %s: %s", stateFile, err)
+	}
+
+	return states, nil
+}
+
+// saveAlertStates writes the last notified states
+func saveAlertStates(stateFile string, states map[string]alertState) error {
+	content, err := json.MarshalIndent(states, "", "  ")
+
+	if err == nil {
+		err = ioutil.WriteFile(stateFile, content, 0600)
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot write alerts state file %s: %s", stateFile, err)
+	}
+
+	return nil
+}
+
+//######################### Webhook Notifier ##################################
+
+// send posts the alerts in a single payload
+func (n *webhookNotifier) send(alerts []*alert, attachment []byte) error {
+	payload, err := json.Marshal(n.payload(alerts))
+
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notifierTimeout}
+	response, err := client.Post(n.config.URL, "application/json", bytes.NewReader(payload))
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+
+	return nil
+}
+
+// payload returns the payload of the alerts in the configured format, Slack payloads are used by default
+func (n *webhookNotifier) payload(alerts []*alert) interface{} {
+	var lines []string
+
+	for _, a := range alerts {
+		lines = append(lines, a.summary())
+	}
+
+	switch n.config.Format {
+	case teamsPayloadFormat:
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"summary":    alerts[0].summary(),
+			"themeColor": alertColor(alerts),
+			"title":      fmt.Sprintf("%s alerts", checkPluginName),
+			"text":       strings.Join(lines, "\n\n"),
+		}
+	case jsonPayloadFormat:
+		var items []map[string]interface{}
+
+		for _, a := range alerts {
+			items = append(items, map[string]interface{}{
+				"target":         a.target,
+				"check":          a.check,
+				"state":          a.stateName(),
+				"previous_state": checkStateNames[a.previousState],
+				"message":        a.message,
+				"time":           a.time.Format(time.RFC3339),
+			})
+		}
+
+		return map[string]interface{}{"alerts": items}
+	}
+
+	return map[string]interface{}{"text": strings.Join(lines, "\n")}
+}
+
+// alertColor returns the color of the most severe alert, used by the Microsoft Teams cards
+func alertColor(alerts []*alert) string {
+	state := checkOK
+
+	for _, a := range alerts {
+		if checkSeverity[a.state] > checkSeverity[state] {
+			state = a.state
+		}
+	}
+
+	return map[int]string{checkOK: "2EB886", checkWarning: "DAA038", checkCritical: "D00000",
+		checkUnknown: "808080"}[state]
+}
+
+//######################### SMTP Notifier ##################################
+
+// send sends the alerts in a single email, with the HTML report attached
+func (n *smtpNotifier) send(alerts []*alert, attachment []byte) error {
+	port := n.config.Port
+
+	if len(port) == 0 {
+		port = "25"
+	}
+
+	var auth smtp.Auth
+
+	if len(n.config.Username) > 0 {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(n.config.Host, port), auth, n.config.From, n.config.To,
+		n.message(alerts, attachment))
+}
+
+// message returns the MIME email of the alerts
+func (n *smtpNotifier) message(alerts []*alert, attachment []byte) []byte {
+	var message bytes.Buffer
+
+	subject := fmt.Sprintf("[%s] %s %s", checkPluginName, alerts[0].stateName(), alerts[0].target)
+
+	if len(alerts) > 1 {
+		subject = fmt.Sprintf("[%s] %d alerts", checkPluginName, len(alerts))
+	}
+
+	fmt.Fprintf(&message, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", alerts[0].time.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", mimeBoundary)
+
+	fmt.Fprintf(&message, "--%s\r\n", mimeBoundary)
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+
+	for _, a := range alerts {
+		fmt.Fprintf(&message, "%s\r\n", a.summary())
+	}
+
+	if len(attachment) > 0 {
+		encoded := base64.StdEncoding.EncodeToString(attachment)
+
+		fmt.Fprintf(&message, "\r\n--%s\r\n", mimeBoundary)
+		fmt.Fprintf(&message, "Content-Type: text/html; charset=\"utf-8\"\r\n")
+		fmt.Fprintf(&message, "Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&message, "Content-Disposition: attachment; filename=\"report.html\"\r\n\r\n")
+
+		for len(encoded) > 76 {
+			fmt.Fprintf(&message, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+
+		fmt.Fprintf(&message, "%s\r\n", encoded)
+	}
+
+	fmt.Fprintf(&message, "\r\n--%s--\r\n", mimeBoundary)
+
+	return message.Bytes()
+}
+
+//######################### Syslog Notifier ##################################
+
+// send sends every alert as a syslog message, to the configured address (udp by default) or to the local syslog
+// daemon if the address is not specified
+func (n *syslogNotifier) send(alerts []*alert, attachment []byte) error {
+	network, address := n.config.Network, n.config.Address
+
+	if len(address) == 0 {
+		network, address = "unixgram", "/dev/log"
+	} else if len(network) == 0 {
+		network = "udp"
+	}
+
+	connection, err := net.DialTimeout(network, address, notifierTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	defer connection.Close()
+
+	for _, a := range alerts {
+		if _, err = connection.Write([]byte(n.message(a))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// message returns the RFC 3164 syslog message of the alert, the severity is derived from the alert state
+func (n *syslogNotifier) message(a *alert) string {
+	tag := n.config.Tag
+
+	if len(tag) == 0 {
+		tag = "emmstats"
+	}
+
+	hostname, _ := os.Hostname()
+	severity := map[int]int{checkOK: 6, checkWarning: 4, checkCritical: 2, checkUnknown: 5}[a.state]
+
+	return fmt.Sprintf("<%d>%s %s %s[%d]: %s\n", syslogUserFacility*8+severity, a.time.Format(time.Stamp),
+		hostname, tag, os.Getpid(), a.summary())
+}
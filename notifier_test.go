@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPendingAlerts(t *testing.T) {
+	now := time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC)
+	critical := checkResult{rule: &CheckRule{Name: "output-cdrs"}, state: checkCritical, message: "output_cdrs 0"}
+	ok := checkResult{rule: &CheckRule{Name: "output-cdrs"}, state: checkOK, message: "output_cdrs 5000"}
+
+	states := map[string]alertState{}
+
+	if alerts := pendingAlerts("UAT_Test Stream", []checkResult{ok}, states, now, 0); len(alerts) != 0 {
+		t.Errorf("Expecting no alerts for OK check, but got %d", len(alerts))
+	}
+
+	alerts := pendingAlerts("UAT_Test Stream", []checkResult{critical}, states, now, 0)
+
+	if len(alerts) != 1 || alerts[0].key != "UAT_Test Stream/output-cdrs" || alerts[0].previousState != checkOK {
+		t.Fatalf("Expecting one critical alert, but got %v", alerts)
+	}
+
+	states[alerts[0].key] = alertState{State: checkCritical, Notified: now}
+
+	if alerts := pendingAlerts("UAT_Test Stream", []checkResult{critical}, states, now.Add(time.Hour),
+		0); len(alerts) != 0 {
+		t.Errorf("Expecting repeated alert to be de-duplicated, but got %d alerts", len(alerts))
+	}
+
+	if alerts := pendingAlerts("UAT_Test Stream", []checkResult{critical}, states, now.Add(time.Hour),
+		30*time.Minute); len(alerts) != 1 {
+		t.Errorf("Expecting alert to be repeated after the repeat interval, but got %d alerts", len(alerts))
+	}
+
+	alerts = pendingAlerts("UAT_Test Stream", []checkResult{ok}, states, now.Add(time.Hour), 0)
+	expected := "EMMSTATS RECOVERY - UAT_Test Stream output-cdrs: output_cdrs 5000 (was CRITICAL)"
+
+	if len(alerts) != 1 || !alerts[0].recovery() || alerts[0].summary() != expected {
+		t.Errorf("Expecting recovery alert %s, but got %v", expected, alerts)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var payloads []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+
+	defer server.Close()
+
+	alerts := []*alert{{target: "UAT_Test Stream", check: "output-cdrs", state: checkCritical,
+		message: "output_cdrs 0 < 1000 in last 1h"}}
+
+	for _, format := range []string{slackPayloadFormat, teamsPayloadFormat, jsonPayloadFormat} {
+		n, _ := newNotifier(&NotifierConfig{Name: format, Type: webhookNotifierType, URL: server.URL, Format: format})
+
+		if err := n.send(alerts, nil); err != nil {
+			t.Errorf("Expecting %s webhook to be sent, but got %s", format, err)
+		}
+	}
+
+	expected := "EMMSTATS CRITICAL - UAT_Test Stream output-cdrs: output_cdrs 0 < 1000 in last 1h"
+
+	if len(payloads) != 3 || payloads[0]["text"] != expected || payloads[1]["@type"] != "MessageCard" ||
+		payloads[1]["themeColor"] != "D00000" || len(payloads[2]["alerts"].([]interface{})) != 1 {
+		t.Errorf("Expecting slack, teams and json payloads, but got %v", payloads)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	defer failing.Close()
+
+	n, _ := newNotifier(&NotifierConfig{Type: webhookNotifierType, URL: failing.URL})
+
+	if err := n.send(alerts, nil); err == nil {
+		t.Errorf("Expecting error for failing webhook, but got nil")
+	}
+}
+
+// startSMTPStandIn starts a minimal SMTP server accepting a single email, and returns its address and a channel
+// receiving the email data
+func startSMTPStandIn(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Cannot start SMTP stand-in: %s", err)
+	}
+
+	received := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+
+		connection, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer connection.Close()
+
+		reader := bufio.NewReader(connection)
+		connection.Write([]byte("220 localhost ESMTP\r\n"))
+
+		for {
+			line, err := reader.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				connection.Write([]byte("250 localhost\r\n"))
+			case command == "DATA":
+				connection.Write([]byte("354 End data with <CR><LF>.<CR><LF>\r\n"))
+
+				var data strings.Builder
+
+				for {
+					line, _ := reader.ReadString('\n')
+
+					if line == ".\r\n" {
+						break
+					}
+
+					data.WriteString(line)
+				}
+
+				received <- data.String()
+				connection.Write([]byte("250 OK\r\n"))
+			case command == "QUIT":
+				connection.Write([]byte("221 Bye\r\n"))
+				return
+			default:
+				connection.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	address, received := startSMTPStandIn(t)
+	host, port, _ := net.SplitHostPort(address)
+
+	n, _ := newNotifier(&NotifierConfig{Type: smtpNotifierType, Host: host, Port: port, From: "emmstats@localhost",
+		To: []string{"ops@localhost"}})
+
+	alerts := []*alert{{target: "UAT_Test Stream", check: "output-cdrs", state: checkWarning,
+		message: "output_cdrs 3000 < 5000 in last 1h", time: time.Now()}}
+
+	if err := n.send(alerts, []byte("<html></html>")); err != nil {
+		t.Fatalf("Expecting email to be sent, but got %s", err)
+	}
+
+	email := <-received
+
+	for _, expected := range []string{
+		"Subject: [EMMSTATS] WARNING UAT_Test Stream",
+		"EMMSTATS WARNING - UAT_Test Stream output-cdrs: output_cdrs 3000 < 5000 in last 1h",
+		"Content-Disposition: attachment; filename=\"report.html\"",
+		"PGh0bWw+PC9odG1sPg==",
+	} {
+		if !strings.Contains(email, expected) {
+			t.Errorf("Expecting email to contain %s, but got %s", expected, email)
+		}
+	}
+}
+
+func TestSyslogNotifier(t *testing.T) {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Cannot start syslog stand-in: %s", err)
+	}
+
+	defer connection.Close()
+
+	n, _ := newNotifier(&NotifierConfig{Type: syslogNotifierType, Address: connection.LocalAddr().String(),
+		Tag: "emmstats"})
+
+	alerts := []*alert{{target: "UAT_Test Stream", check: "input-files", state: checkCritical, message: "input_files 0",
+		time: time.Now()}}
+
+	if err := n.send(alerts, nil); err != nil {
+		t.Fatalf("Expecting syslog message to be sent, but got %s", err)
+	}
+
+	buffer := make([]byte, 1024)
+	connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	count, _, err := connection.ReadFrom(buffer)
+	message := string(buffer[:count])
+
+	if err != nil || !strings.HasPrefix(message, "<10>") ||
+		!strings.Contains(message, "EMMSTATS CRITICAL - UAT_Test Stream input-files: input_files 0") {
+		t.Errorf("Expecting critical syslog message, but got %s (%v)", message, err)
+	}
+}
+
+func TestNotifyAlerts(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+
+	defer server.Close()
+
+	directory, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(directory)
+
+	config := &NotificationsConfig{
+		StateFile: filepath.Join(directory, "alerts.json"),
+		Notifiers: []*NotifierConfig{{Name: "hook", Type: webhookNotifierType, URL: server.URL}},
+	}
+
+	results := []checkResult{{rule: &CheckRule{Column: "output_cdrs"}, state: checkCritical}}
+
+	for i := 0; i < 2; i++ {
+		if err := notifyAlerts(config, "UAT_Test Stream", results, nil); err != nil {
+			t.Fatalf("Expecting alerts to be notified, but got %s", err)
+		}
+	}
+
+	states, _ := loadAlertStates(config.StateFile)
+
+	if requests != 1 || states["UAT_Test Stream/output_cdrs"].State != checkCritical {
+		t.Errorf("Expecting a single notification and critical state, but got %d notifications and %v", requests,
+			states)
+	}
+}
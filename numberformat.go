@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// defaultLocale is the locale used to format numbers in human readable mode if not specified in the options
+const defaultLocale = "en"
+
+// byteUnits are the decimal (SI) units used to scale bytes in human readable mode, they have the same base as the MB
+// of the rate columns and the capacities, so that a report never mixes 1000 and 1024 based units
+var byteUnits = []string{"B", "kB", "MB", "GB", "TB", "PB"}
+
+// byteUnitBase is the ratio between two consecutive byte units
+const byteUnitBase = 1000
+
+// numberFormat contains the separators used to format numbers in human readable mode
+type numberFormat struct {
+	thousands string
+	decimal   string
+}
+
+// numberFormats contains the number formats of the supported languages, and of the regions which do not use the format
+// of their language (e.g. de-ch)
+var numberFormats = map[string]numberFormat{
+	"en":    {thousands: ",", decimal: "."},
+	"de":    {thousands: ".", decimal: ","},
+	"es":    {thousands: ".", decimal: ","},
+	"it":    {thousands: ".", decimal: ","},
+	"pt":    {thousands: ".", decimal: ","},
+	"fr":    {thousands: " ", decimal: ","},
+	"ru":    {thousands: " ", decimal: ","},
+	"sv":    {thousands: " ", decimal: ","},
+	"de-ch": {thousands: "'", decimal: "."},
+}
+
+// chooseNumberFormat returns the number format of the locale, the format of the language is used for the regions
+// without their own format (e.g. en_US, de-DE)
+func chooseNumberFormat(locale string) (*numberFormat, error) {
+	if len(locale) == 0 {
+		locale = defaultLocale
+	}
+
+	fields := strings.FieldsFunc(strings.ToLower(locale), func(r rune) bool { return r == '_' || r == '-' })
+
+	if len(fields) > 1 {
+		if format, found := numberFormats[fields[0]+"-"+fields[1]]; found {
+			return &format, nil
+		}
+	}
+
+	if len(fields) > 0 {
+		if format, found := numberFormats[fields[0]]; found {
+			return &format, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported locale %s", locale)
+}
+
+// humanize formats a numeric value of a column, bytes columns (i.e. names ending with _bytes) are scaled to SI units,
+// and other numbers get thousands separators. Values which are not numbers are returned as they are
+func (f numberFormat) humanize(columnName string, value string) string {
+	number, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || math.IsNaN(number) {
+		return value
+	}
+
+	if strings.HasSuffix(columnName, "_bytes") {
+		return f.formatBytes(number)
+	}
+
+	return f.formatNumber(number, decimalsOf(number))
+}
+
+// formatBytes scales the bytes to the largest SI unit which keeps the value above one
+func (f numberFormat) formatBytes(bytes float64) string {
+	unit := 0
+
+	for math.Abs(bytes) >= byteUnitBase && unit < len(byteUnits)-1 {
+		bytes /= byteUnitBase
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%s %s", f.formatNumber(bytes, decimalsOf(bytes)), byteUnits[unit])
+	}
+
+	return fmt.Sprintf("%s %s", f.formatNumber(bytes, 2), byteUnits[unit])
+}
+
+// formatNumber formats the number with the decimals count, using the thousands and decimal separators
+func (f numberFormat) formatNumber(number float64, decimals int) string {
+	formatted := strconv.FormatFloat(math.Abs(number), 'f', decimals, 64)
+	integer, fraction := formatted, ""
+
+	if dot := strings.Index(formatted, "."); dot >= 0 {
+		integer, fraction = formatted[:dot], formatted[dot+1:]
+	}
+
+	var grouped strings.Builder
+
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteString(f.thousands)
+		}
+
+		grouped.WriteRune(digit)
+	}
+
+	result := grouped.String()
+
+	if len(fraction) > 0 {
+		result += f.decimal + fraction
+	}
+
+	if number < 0 {
+		result = "-" + result
+	}
+
+	return result
+}
+
+// decimalsOf returns the number of decimals displayed for the number, integers are displayed without decimals
+func decimalsOf(number float64) int {
+	if number == math.Trunc(number) {
+		return 0
+	}
+
+	return 2
+}
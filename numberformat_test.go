@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNumberFormat_Humanize(t *testing.T) {
+	en, _ := chooseNumberFormat("en_US")
+	de, _ := chooseNumberFormat("de")
+
+	tests := []struct {
+		format   *numberFormat
+		column   string
+		value    string
+		expected string
+	}{
+		{en, "output_bytes", "2206548945208", "2.21 TB"},
+		{en, "output_bytes", "512", "512 B"},
+		{en, "input_cdrs", "1474082862", "1,474,082,862"},
+		{en, "input_cdrs_per_sec", "1234.5678", "1,234.57"},
+		{de, "input_cdrs", "1474082862", "1.474.082.862"},
+		{de, "input_bytes", "1500000", "1,50 MB"},
+		{en, "input_files", "-1500", "-1,500"},
+		{en, "input_files", "NA", "NA"},
+	}
+
+	for _, test := range tests {
+		if humanized := test.format.humanize(test.column, test.value); humanized != test.expected {
+			t.Errorf("Expecting '%s' for %s %s, but got '%s'", test.expected, test.column, test.value, humanized)
+		}
+	}
+
+	if _, err := chooseNumberFormat("xx"); err == nil {
+		t.Errorf("Expecting error for locale 'xx'")
+	}
+
+	if swiss, _ := chooseNumberFormat("de_CH"); swiss == nil || swiss.formatNumber(1234567.5, 1) != "1'234'567.5" {
+		t.Errorf("Expecting Swiss separators for locale 'de_CH'")
+	}
+
+	if _, err := chooseNumberFormat("ch"); err == nil {
+		t.Errorf("Expecting error for country code 'ch'")
+	}
+}
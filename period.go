@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/urfave/cli.v2"
+	"time"
+)
+
+// dbSessionTimeZone is the label used in report headers when no time zone is configured, in this case the time
+// buckets are generated in the time zone of the database session
+const dbSessionTimeZone = "Database session time zone"
+
+// reportPeriod is the resolved time range of a report, start and end times are expressed in the report time zone
+type reportPeriod struct {
+	start time.Time
+	end   time.Time
+
+	// timeZone is the IANA name of the time zone used to generate the report time buckets, and dbTimeZone is the time
+	// zone of the timestamps stored in the database. Empty names mean the database session time zone
+	timeZone   string
+	dbTimeZone string
+}
+
+// resolveReportPeriod creates the report period from the time options. The report time zone is taken from the
+// timezone option if specified, otherwise the time zone of the cluster is used
+func resolveReportPeriod(context *cli.Context, cluster *Cluster) (*reportPeriod, error) {
+	return resolveReportPeriodWithDefault(context, cluster, defaultRange)
+}
+
+// resolveReportPeriodWithDefault creates the report period from the time options, using the fallback range when no
+// time options are specified
+func resolveReportPeriodWithDefault(context *cli.Context, cluster *Cluster, fallbackRange string) (*reportPeriod,
+	error) {
+
+	period := &reportPeriod{timeZone: context.String("timezone")}
+
+	if cluster != nil {
+		period.dbTimeZone = cluster.TimeZone
+	}
+
+	if len(period.timeZone) == 0 {
+		period.timeZone = period.dbTimeZone
+	}
+
+	location, err := loadTimeZone(period.timeZone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = loadTimeZone(period.dbTimeZone); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(location).Truncate(time.Second)
+
+	// Relative time options are resolved from the reference time if specified (e.g. scheduled time of catch-up runs)
+	if referenceArg := context.String("reference-time"); len(referenceArg) > 0 {
+		if now, err = parseTimeExpression(referenceArg, now); err != nil {
+			return nil, fmt.Errorf("invalid reference-time: %s", err)
+		}
+	}
+	rangeArg := context.String("range")
+	startTimeArg := context.String("start-time")
+	endTimeArg := context.String("end-time")
+
+	if len(rangeArg) == 0 && len(startTimeArg) == 0 && len(endTimeArg) == 0 {
+		rangeArg = fallbackRange
+	}
+
+	if len(rangeArg) > 0 {
+		if period.start, period.end, err = parseRangeExpression(rangeArg, now); err != nil {
+			return nil, err
+		}
+
+		return period, nil
+	}
+
+	// Start time defaults to 24 hours before the end time, and end time defaults to the current time
+	period.end = now
+
+	if len(endTimeArg) > 0 {
+		if period.end, err = parseTimeExpression(endTimeArg, now); err != nil {
+			return nil, fmt.Errorf("invalid end-time: %s", err)
+		}
+	}
+
+	period.start = period.end.Add(-24 * time.Hour)
+
+	if len(startTimeArg) > 0 {
+		if period.start, err = parseTimeExpression(startTimeArg, now); err != nil {
+			return nil, fmt.Errorf("invalid start-time: %s", err)
+		}
+	}
+
+	if period.end.Before(period.start) {
+		return nil, fmt.Errorf("end-time is before start-time")
+	}
+
+	return period, nil
+}
+
+// timeZoneLabel returns the name of the report time zone as displayed in report headers
+func (p reportPeriod) timeZoneLabel() string {
+	if len(p.timeZone) == 0 {
+		return dbSessionTimeZone
+	}
+
+	return p.timeZone
+}
+
+// periodLabel returns the resolved start and end times as displayed in report headers
+func (p reportPeriod) periodLabel() string {
+	return fmt.Sprintf("%s - %s", p.start.Format(periodLabelFormat), p.end.Format(periodLabelFormat))
+}
+
+// queryParameters returns the query parameters for the time range of the period, grouped using the time bucket
+func (p reportPeriod) queryParameters(bucket timeBucket) AudittrailLogEntryQueryParameters {
+	return AudittrailLogEntryQueryParameters{
+		TimeFormat: bucket.dbFormat,
+		Interval:   bucket.interval,
+		StartTime:  p.start.Format(timeFlagFormat),
+		EndTime:    p.end.Format(timeFlagFormat),
+		TimeZone:   p.timeZone,
+		DBTimeZone: p.dbTimeZone,
+	}
+}
+
+// loadTimeZone returns the location of the time zone name. The database session time zone (i.e. empty name) is
+// handled as the local time zone on the client side, as time values are passed to the database as they are
+func loadTimeZone(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %s", name)
+	}
+
+	return location, nil
+}
+
+// bucketsSeries returns all the time buckets of the period
+func (p reportPeriod) bucketsSeries(bucket timeBucket) []string {
+	return bucket.series(p.start, p.end)
+}
+
+// bucketsDurations returns the duration of each time bucket of the period, clipped by the start and end of the period
+func (p reportPeriod) bucketsDurations(bucket timeBucket) map[string]time.Duration {
+	return bucket.durations(p.start, p.end)
+}
+
+// shift returns a copy of the period with start and end times shifted by the offset
+func (p reportPeriod) shift(offset timeOffset) *reportPeriod {
+	shifted := p
+	shifted.start = offset.apply(p.start)
+	shifted.end = offset.apply(p.end)
+
+	return &shifted
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	ctx "context"
+	"database/sql"
+	"fmt"
+	"github.com/briandowns/spinner"
+	"github.com/go-gota/gota/series"
+	"github.com/jmoiron/sqlx"
+	"github.com/kniren/gota/dataframe"
+	"gopkg.in/urfave/cli.v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Kinds of the databases checked by the ping command
+	logicalServerDatabase = "logical server"
+	performanceDatabase   = "performance"
+
+	// healthyStatus and unhealthyStatus are the statuses of the databases in the ping report
+	healthyStatus   = "OK"
+	unhealthyStatus = "UNHEALTHY"
+)
+
+// pingColumns are the columns of the ping report
+var pingColumns = []string{"cluster", "logical_server", "kind", "status", "latency_ms", "version", "sslmode", "tls",
+	"audittrail", "rows_estimate", "min_intime", "max_intime", "missing_indexes", "error"}
+
+// expectedIndexColumns are the columns of audittraillogentry expected to lead an index, the throughput queries filter
+// the entries on them
+var expectedIndexColumns = []string{"intime", "outtime"}
+
+// databaseHealth is the result of checking a database, tls is the negotiated TLS version and cipher of the connection
+type databaseHealth struct {
+	cluster        string
+	logicalServer  *LogicalServer
+	kind           string
+	latency        time.Duration
+	version        string
+	tls            string
+	audittrail     bool
+	rowsEstimate   int64
+	minIntime      string
+	maxIntime      string
+	missingIndexes []string
+	err            error
+}
+
+// ping connects concurrently to every logical server of EMM configuration matching the cluster and logical server
+// options, or to the adhoc databases if specified, and checks the audittraillogentry table of the logical servers.
+// The command fails if any database is unhealthy
+func ping(context *cli.Context) error {
+
+	s := spinner.New(spinner.CharSets[36], spinnerUpdateFreq)
+
+	checks := pingTargets(context)
+
+	if len(checks) == 0 {
+		return cli.Exit("No logical server matches the options", errorExitCode)
+	}
+
+	var wait sync.WaitGroup
+
+	s.Prefix = "Pinging Databases "
+	s.Start()
+
+	for _, check := range checks {
+		wait.Add(1)
+
+		go func(check *databaseHealth) {
+			defer wait.Done()
+			check.run(context.Duration("timeout"))
+		}(check)
+	}
+
+	wait.Wait()
+	s.Stop()
+
+	report := &Report{name: "Databases Health"}
+	records := [][]string{pingColumns}
+	table := &ResultSet{
+		columnsNames:     pingColumns,
+		columnsDataTypes: map[string]series.Type{},
+		highlights:       map[cell]bool{},
+		highlightCaption: "! Unhealthy database",
+	}
+
+	for _, columnName := range pingColumns {
+		table.columnsDataTypes[columnName] = series.String
+	}
+
+	unhealthy := 0
+
+	for _, check := range checks {
+		record := check.record()
+
+		if !check.healthy() {
+			unhealthy++
+			table.highlights[cell{row: len(records) - 1, column: indexOf(pingColumns, "status")}] = true
+		}
+
+		records = append(records, record)
+	}
+
+	table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+	report.defaultTable = table
+	report.AddHeader("Expected Indexes", strings.Join(expectedIndexColumns, ", "))
+
+	if err := outputReport(context, report, report.GetDefaultTable()); err != nil {
+		return err
+	}
+
+	if unhealthy > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d databases are unhealthy", unhealthy, len(checks)), errorExitCode)
+	}
+
+	return nil
+}
+
+// pingTargets returns the databases to check, the adhoc databases if specified, otherwise the logical servers of EMM
+// configuration matching the cluster and logical server options
+func pingTargets(context *cli.Context) []*databaseHealth {
+	var checks []*databaseHealth
+
+	for _, adhoc := range []struct {
+		database string
+		kind     string
+	}{
+		{context.String("ls-dbname"), logicalServerDatabase},
+		{context.String("pf-dbname"), performanceDatabase},
+	} {
+		if len(adhoc.database) > 0 {
+			checks = append(checks, &databaseHealth{
+				logicalServer: &LogicalServer{Name: adhoc.database, IP: context.String("db-ip"),
+					Port: context.String("db-port"), Database: adhoc.database,
+					SSLMode: context.String("sslmode"), SSLRootCert: expandHome(context.String("sslrootcert")),
+					SSLCert: expandHome(context.String("sslcert")), SSLKey: expandHome(context.String("sslkey"))},
+				kind: adhoc.kind,
+			})
+		}
+	}
+
+	if len(checks) > 0 || emmConfig == nil {
+		return checks
+	}
+
+	for _, cluster := range emmConfig.Clusters {
+		if !matchName(context.String("cluster"), cluster.Name) {
+			continue
+		}
+
+		for _, logicalServer := range cluster.LogicalServers {
+			if matchName(context.String("lserver"), logicalServer.Name) {
+				checks = append(checks, &databaseHealth{cluster: cluster.Name, logicalServer: logicalServer,
+					kind: logicalServerDatabase})
+			}
+		}
+	}
+
+	return checks
+}
+
+// run connects to the database, reads the TLS state of the connection, and checks the audittraillogentry table of the
+// logical servers databases. The first error stops the checks, every query is cancelled after the timeout
+func (h *databaseHealth) run(timeout time.Duration) {
+	db, err := openDatabase(h.logicalServer)
+
+	if err != nil {
+		h.err = err
+		return
+	}
+
+	defer db.Close()
+
+	timeoutContext, cancel := ctx.WithTimeout(ctx.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	if h.err = db.PingContext(timeoutContext); h.err != nil {
+		return
+	}
+
+	h.latency = time.Since(start)
+
+	if h.err = db.QueryRowContext(timeoutContext, "SELECT current_setting('server_version')").Scan(
+		&h.version); h.err != nil {
+		return
+	}
+
+	h.tls = connectionTLS(timeoutContext, db)
+
+	if h.kind == performanceDatabase {
+		return
+	}
+
+	if h.err = db.QueryRowContext(timeoutContext, "SELECT to_regclass('audittraillogentry') IS NOT NULL").Scan(
+		&h.audittrail); h.err != nil || !h.audittrail {
+		return
+	}
+
+	if h.err = db.QueryRowContext(timeoutContext, "SELECT reltuples::bigint FROM pg_class "+
+		"WHERE oid = 'audittraillogentry'::regclass").Scan(&h.rowsEstimate); h.err != nil {
+		return
+	}
+
+	var minIntime, maxIntime sql.NullString
+
+	if h.err = db.QueryRowContext(timeoutContext, "SELECT To_char(Min(intime), 'YYYY-MM-DD HH24:MI:SS'), "+
+		"To_char(Max(intime), 'YYYY-MM-DD HH24:MI:SS') FROM audittraillogentry").Scan(&minIntime,
+		&maxIntime); h.err != nil {
+		return
+	}
+
+	h.minIntime, h.maxIntime = minIntime.String, maxIntime.String
+
+	var indexedColumns []string
+
+	if h.err = db.SelectContext(timeoutContext, &indexedColumns, "SELECT a.attname FROM pg_index i "+
+		"JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0] "+
+		"WHERE i.indrelid = 'audittraillogentry'::regclass"); h.err != nil {
+		return
+	}
+
+	h.missingIndexes = missingIndexes(indexedColumns)
+}
+
+// connectionTLS returns the TLS version and cipher of the connection, off if the connection is not encrypted, or
+// unknown if the database does not report it (i.e. pg_stat_ssl view is available since PostgreSQL 9.5)
+func connectionTLS(timeoutContext ctx.Context, db *sqlx.DB) string {
+	var ssl sql.NullBool
+	var version, cipher sql.NullString
+
+	if err := db.QueryRowContext(timeoutContext, "SELECT ssl, version, cipher FROM pg_stat_ssl "+
+		"WHERE pid = pg_backend_pid()").Scan(&ssl, &version, &cipher); err != nil {
+		return "unknown"
+	}
+
+	if !ssl.Bool {
+		return "off"
+	}
+
+	return fmt.Sprintf("%s (%s)", version.String, cipher.String)
+}
+
+// healthy returns true if the database is reachable, and the audittraillogentry table of the logical servers exists
+// with the expected indexes
+func (h *databaseHealth) healthy() bool {
+	return h.err == nil && (h.kind == performanceDatabase || (h.audittrail && len(h.missingIndexes) == 0))
+}
+
+// record returns the record of the database in the ping report
+func (h *databaseHealth) record() []string {
+	status, latency, audittrail, rowsEstimate, errorMessage := healthyStatus, "", "", "", ""
+
+	if !h.healthy() {
+		status = unhealthyStatus
+	}
+
+	if h.latency > 0 {
+		latency = strconv.FormatInt(h.latency.Milliseconds(), 10)
+	}
+
+	if len(h.version) > 0 && h.kind == logicalServerDatabase {
+		audittrail = "missing"
+
+		if h.audittrail {
+			audittrail, rowsEstimate = "present", strconv.FormatInt(h.rowsEstimate, 10)
+		}
+	}
+
+	if h.err != nil {
+		errorMessage = h.err.Error()
+	}
+
+	return []string{h.cluster, h.logicalServer.Name, h.kind, status, latency, h.version, h.logicalServer.SSLMode, h.tls,
+		audittrail, rowsEstimate, h.minIntime, h.maxIntime, strings.Join(h.missingIndexes, ", "), errorMessage}
+}
+
+// missingIndexes returns the expected index columns which do not lead any index
+func missingIndexes(indexedColumns []string) []string {
+	var missing []string
+
+	for _, columnName := range expectedIndexColumns {
+		if indexOf(indexedColumns, columnName) < 0 {
+			missing = append(missing, columnName)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissingIndexes(t *testing.T) {
+	if missing := missingIndexes([]string{"outtime", "id", "intime"}); len(missing) != 0 {
+		t.Errorf("Expecting no missing indexes, but got %v", missing)
+	}
+
+	if missing := missingIndexes([]string{"event"}); !reflect.DeepEqual(missing, expectedIndexColumns) {
+		t.Errorf("Expecting %v, but got %v", expectedIndexColumns, missing)
+	}
+}
+
+func TestDatabaseHealth_Record(t *testing.T) {
+	server := &LogicalServer{Name: "Server1", SSLMode: "require"}
+	healthy := &databaseHealth{cluster: "ryd2", logicalServer: server, kind: logicalServerDatabase,
+		latency: 12 * time.Millisecond, version: "11.5", tls: "TLSv1.3 (TLS_AES_256_GCM_SHA384)", audittrail: true,
+		rowsEstimate: 1500,
+		minIntime:    "2019-01-01 00:00:05", maxIntime: "2019-03-25 10:00:00"}
+
+	expected := []string{"ryd2", "Server1", logicalServerDatabase, healthyStatus, "12", "11.5", "require",
+		"TLSv1.3 (TLS_AES_256_GCM_SHA384)", "present", "1500",
+		"2019-01-01 00:00:05", "2019-03-25 10:00:00", "", ""}
+
+	if record := healthy.record(); !reflect.DeepEqual(record, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, record)
+	}
+
+	checks := []*databaseHealth{
+		{logicalServer: server, kind: logicalServerDatabase, version: "11.5"},
+		{logicalServer: server, kind: logicalServerDatabase, version: "11.5", audittrail: true,
+			missingIndexes: []string{"outtime"}},
+		{logicalServer: server, kind: logicalServerDatabase, err: fmt.Errorf("connection refused")},
+	}
+
+	for _, check := range checks {
+		if record := check.record(); check.healthy() || record[3] != unhealthyStatus {
+			t.Errorf("Expecting %v to be unhealthy", record)
+		}
+	}
+
+	performance := &databaseHealth{logicalServer: server, kind: performanceDatabase, version: "11.5"}
+
+	if record := performance.record(); !performance.healthy() || record[8] != "" {
+		t.Errorf("Expecting healthy performance database without audittrail, but got %v", record)
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	server := &LogicalServer{IP: "10.135.3.125", Port: "5432", Username: "mmsuper", Password: `it's se\cret`}
+	expected := `user='mmsuper' password='it\'s se\\cret' port='5432' host='10.135.3.125' sslmode='disable'`
+
+	if connection := connectionString(server); connection != expected {
+		t.Errorf("Expecting %s, but got %s", expected, connection)
+	}
+}
+
+func TestConnectionString_SSL(t *testing.T) {
+	server := &LogicalServer{IP: "10.135.3.125", Port: "5432", Username: "mmsuper", SSLMode: "verify-full",
+		SSLRootCert: "/etc/emm/root.crt", SSLCert: "/etc/emm/client.crt", SSLKey: "/etc/emm/client.key"}
+	expected := "user='mmsuper' port='5432' host='10.135.3.125' sslmode='verify-full' " +
+		"sslrootcert='/etc/emm/root.crt' sslcert='/etc/emm/client.crt' sslkey='/etc/emm/client.key'"
+
+	if connection := connectionString(server); connection != expected {
+		t.Errorf("Expecting %s, but got %s", expected, connection)
+	}
+}
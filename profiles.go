@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"sort"
+	"strconv"
+)
+
+// optionValue is the value of a global option
+type optionValue struct {
+	name  string
+	value string
+}
+
+// overrideGroups are the global options overridden together. Target options select the stream, or the logical server
+// and cluster of a report, so that a profile stream is not combined with a logical server specified in the options.
+// Time options select the period, so that a profile range is not combined with a start time specified in the options
+var overrideGroups = []map[string]bool{
+	{"stream": true, "lserver": true, "cluster": true},
+	{"range": true, "start-time": true, "end-time": true},
+}
+
+// overriddenOptions returns the options of the profile which are overridden by the options of the command line,
+// including the options of the groups overridden by any of their options
+func overriddenOptions(overrides map[string][]string) map[string]bool {
+	overridden := map[string]bool{}
+
+	for name := range overrides {
+		overridden[name] = true
+
+		for _, group := range overrideGroups {
+			if !group[name] {
+				continue
+			}
+
+			for member := range group {
+				overridden[member] = true
+			}
+		}
+	}
+
+	return overridden
+}
+
+// runReportProfile runs the command of the report profile with its options. Global options specified in the command
+// line override the profile values, and the arguments following the profile name are appended to the command arguments
+func runReportProfile(context *cli.Context) error {
+
+	if context.NArg() == 0 {
+		return cli.Exit("Report profile name is missing", errorExitCode)
+	}
+
+	if emmConfig == nil {
+		return cli.Exit("EMM configuration file is not loaded", errorExitCode)
+	}
+
+	name := context.Args().First()
+	profile := emmConfig.FindReportProfile(name)
+
+	if profile == nil {
+		return cli.Exit(fmt.Sprintf("%s report profile is not defined in EMM configuration", name), errorExitCode)
+	}
+
+	if len(profile.Command) == 0 {
+		return cli.Exit(fmt.Sprintf("%s report profile: command is missing", name), errorExitCode)
+	}
+
+	// The application of subcommands only contains the subcommands, the profile is run by the root application
+	app := context.App
+
+	for _, parent := range context.Lineage() {
+		if parent.App != nil {
+			app = parent.App
+		}
+	}
+
+	arguments := profileArguments(profile, setGlobalOptions(context, app), context.Args().Tail())
+
+	logger.WithFields(logrus.Fields{
+		"report-profile": name,
+		"arguments":      arguments,
+	}).Debug("Running report profile")
+
+	return app.Run(append([]string{app.Name}, arguments...))
+}
+
+// setGlobalOptions returns the values of the global options of the application specified in the command line, using
+// the name or any of the aliases of the options. Repeatable options (e.g. stream) have a value per repetition
+func setGlobalOptions(context *cli.Context, app *cli.App) map[string][]string {
+	options := map[string][]string{}
+
+	for _, flag := range app.Flags {
+		for _, name := range flag.Names() {
+			if !context.IsSet(name) {
+				continue
+			}
+
+			if _, repeatable := flag.(*cli.StringSliceFlag); repeatable {
+				options[flag.Names()[0]] = context.StringSlice(name)
+			} else {
+				options[flag.Names()[0]] = []string{context.String(name)}
+			}
+		}
+	}
+
+	return options
+}
+
+// profileArguments returns the arguments running the command of the profile. Profile values are overridden by the
+// global options of the command line, and extra arguments are appended to the command arguments of the profile
+func profileArguments(profile *ReportProfile, overrides map[string][]string, extra []string) []string {
+	var arguments []string
+
+	overridden := overriddenOptions(overrides)
+
+	options := append(profile.ReportOptions.globalOptions(),
+		optionValue{"format", profile.Format},
+		optionValue{"output-file", profile.OutputFile})
+
+	if profile.Human {
+		options = append(options, optionValue{"human", strconv.FormatBool(profile.Human)})
+	}
+
+	for _, option := range options {
+		if overridden[option.name] || len(option.value) == 0 {
+			continue
+		}
+
+		arguments = append(arguments, fmt.Sprintf("--%s=%s", option.name, option.value))
+	}
+
+	// Overrides are sorted so that the arguments are deterministic
+	var names []string
+
+	for name := range overrides {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range overrides[name] {
+			arguments = append(arguments, fmt.Sprintf("--%s=%s", name, value))
+		}
+	}
+
+	arguments = append(arguments, profile.Command)
+	arguments = append(arguments, profile.Args...)
+
+	return append(arguments, extra...)
+}
+
+// globalOptions returns the report options which are global options, in the order of the command line
+func (o ReportOptions) globalOptions() []optionValue {
+	var options []optionValue
+
+	for _, stream := range o.Stream {
+		options = append(options, optionValue{"stream", stream})
+	}
+
+	return append(options, []optionValue{
+		{"lserver", o.LogicalServer},
+		{"cluster", o.Cluster},
+		{"range", o.Range},
+		{"group-by", o.GroupBy},
+		{"stats", o.Stats},
+		{"timezone", o.TimeZone},
+		{"rate", o.Rate},
+		{"output-dir", o.OutputDir},
+	}...)
+}
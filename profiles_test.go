@@ -0,0 +1,72 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+)
+
+func TestProfileArguments(t *testing.T) {
+	profile := &ReportProfile{
+		Name: "uat-hourly-csv",
+		ReportOptions: ReportOptions{Command: "compare", Stream: stringList{"UAT_Test"}, Range: "yesterday", GroupBy: "hour",
+			OutputDir: "reports", Args: []string{"--threshold", "15"}},
+		Format: "csv",
+		Human:  true,
+	}
+
+	expected := []string{"--stream=UAT_Test", "--range=yesterday", "--group-by=hour", "--output-dir=reports",
+		"--format=csv", "--human=true", "compare", "--threshold", "15"}
+
+	if arguments := profileArguments(profile, map[string][]string{}, nil); !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments)
+	}
+
+	overrides := map[string][]string{"group-by": {"day"}, "lserver": {"Server1"}, "cluster": {"ryd2"}}
+	expected = []string{"--range=yesterday", "--output-dir=reports", "--format=csv", "--human=true",
+		"--cluster=ryd2", "--group-by=day", "--lserver=Server1", "compare", "--threshold", "15", "--threshold", "5"}
+
+	arguments := profileArguments(profile, overrides, []string{"--threshold", "5"})
+
+	if !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments)
+	}
+
+	overrides = map[string][]string{"stream": {"group:4G", "UAT_*"}}
+	expected = []string{"--range=yesterday", "--group-by=hour", "--output-dir=reports", "--format=csv", "--human=true",
+		"--stream=group:4G", "--stream=UAT_*", "compare", "--threshold", "15"}
+
+	if arguments = profileArguments(profile, overrides, nil); !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments)
+	}
+
+	// Time options are overridden together, the profile range is not combined with the start time
+	overrides = map[string][]string{"start-time": {"20190301000000"}}
+	expected = []string{"--stream=UAT_Test", "--group-by=hour", "--output-dir=reports", "--format=csv",
+		"--human=true", "--start-time=20190301000000", "compare", "--threshold", "15"}
+
+	if arguments = profileArguments(profile, overrides, nil); !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments)
+	}
+}
+
+func TestReportOptions_Streams(t *testing.T) {
+	var profiles []*ReportProfile
+
+	document := "- name: single\n  stream: UAT_Test\n- name: several\n  stream: [\"group:4G\", \"UAT_*\"]\n"
+
+	if err := yaml.Unmarshal([]byte(document), &profiles); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if expected := (stringList{"UAT_Test"}); !reflect.DeepEqual(profiles[0].Stream, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, profiles[0].Stream)
+	}
+
+	expected := []string{"--stream=group:4G", "--stream=UAT_*", "list"}
+	profiles[1].Command = "list"
+
+	if arguments := profileArguments(profiles[1], map[string][]string{}, nil); !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments)
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"strings"
 	"text/template"
+	"time"
 )
 
 const (
@@ -12,6 +15,12 @@ const (
 	hour                = "YYYYMMDDHH24"
 	minute              = "YYYYMMDDHH24MI"
 	month               = "YYYYMM"
+	week                = `IYYY"-W"IW`
+	quarter             = `YYYY"-Q"Q`
+	year                = "YYYY"
+
+	// timestampDBFormat is the database format of the time values passed in the time options
+	timestampDBFormat = "YYYYMMDDHH24MISS"
 
 	// Template for generation of Input/Output throughput of a logical server
 	lsThroughputQueryTemplate = `SELECT CASE
@@ -25,16 +34,16 @@ const (
 			COALESCE(b.output_files, 0) AS output_files,
 			COALESCE(b.output_cdrs, 0) AS output_cdrs,
 			COALESCE(b.output_bytes, 0) AS output_bytes
-		FROM   (SELECT To_char(intime, '{{.TimeFormat}}') AS time,
+		FROM   (SELECT {{.Bucket "intime"}} AS time,
 			Count (*)                     AS input_files,
 			Sum(bytes)::bigint               AS input_bytes
 		FROM   audittraillogentry
 		WHERE  
-		to_char(intime, '{{.TimeFormat}}') >= '{{.StartTime}}'
-		AND to_char(intime, '{{.TimeFormat}}') <= '{{.EndTime}}'
+		intime >= {{.DBTimestamp .StartTime}}
+		AND intime < {{.DBTimestamp .EndTime}}
 		AND event = 67
-		GROUP  BY To_char(intime, '{{.TimeFormat}}')
-		ORDER  BY To_char(intime, '{{.TimeFormat}}')) a
+		GROUP  BY {{.Bucket "intime"}}
+		ORDER  BY {{.Bucket "intime"}}) a
 		FULL OUTER JOIN (SELECT CASE
 		WHEN c.time IS NOT NULL THEN c.time
 		WHEN d.time IS NOT NULL THEN d.time
@@ -44,18 +53,18 @@ const (
 			d.output_files,
 			d.output_cdrs,
 			d.output_bytes
-		FROM   (SELECT To_char(intime, '{{.TimeFormat}}') AS time,
+		FROM   (SELECT {{.Bucket "intime"}} AS time,
 			COALESCE(Sum (cdrs)::bigint, 0)       AS
 		input_cdrs
 		FROM   audittraillogentry
 		WHERE  
-		to_char(intime, '{{.TimeFormat}}') >= '{{.StartTime}}'
-		AND to_char(intime, '{{.TimeFormat}}') <= '{{.EndTime}}'
+		intime >= {{.DBTimestamp .StartTime}}
+		AND intime < {{.DBTimestamp .EndTime}}
 		AND event = 73
-		GROUP  BY To_char(intime, '{{.TimeFormat}}')
-		ORDER  BY To_char(intime, '{{.TimeFormat}}')) c
+		GROUP  BY {{.Bucket "intime"}}
+		ORDER  BY {{.Bucket "intime"}}) c
 		FULL OUTER JOIN (SELECT
-		To_char(outtime, '{{.TimeFormat}}')
+		{{.Bucket "outtime"}}
 		AS time,
 			Count(*)
 		AS
@@ -68,11 +77,11 @@ const (
 		output_bytes
 		FROM   audittraillogentry
 		WHERE  
-		to_char(outtime, '{{.TimeFormat}}') >= '{{.StartTime}}'
-		AND to_char(outtime, '{{.TimeFormat}}') <= '{{.EndTime}}'
+		outtime >= {{.DBTimestamp .StartTime}}
+		AND outtime < {{.DBTimestamp .EndTime}}
 		AND event = 68
-		GROUP  BY To_char(outtime, '{{.TimeFormat}}')
-		ORDER  BY To_char(outtime, '{{.TimeFormat}}')) d
+		GROUP  BY {{.Bucket "outtime"}}
+		ORDER  BY {{.Bucket "outtime"}}) d
 		ON c.time = d.time) b
 		ON a.time = b.time`
 
@@ -88,24 +97,16 @@ const (
 			COALESCE(b.total_output_files, 0) AS total_output_files,
 			COALESCE(b.total_output_cdrs, 0) AS total_output_cdrs,
 			COALESCE(b.total_output_bytes, 0) AS total_output_bytes
-		FROM   (SELECT To_char(intime, '{{.TimeFormat}}') AS time,
+		FROM   (SELECT {{.Bucket "intime"}} AS time,
 			Count (*)                     AS total_input_files,
 			Sum(bytes)::bigint            AS total_input_bytes
 		FROM   audittraillogentry
 		WHERE  event = 67
-			{{- $names := concat .InnodeNames -}}
-			{{- $ids := concat .InnodeIds -}}
-			{{- if and $names $ids -}}
-				AND (trim(innodename) IN ({{- $names -}}) OR innodeid IN ({{- $ids -}}))
-			{{- else if $names -}}
-				AND (trim(innodename) IN ({{- $names -}}))
-			{{- else if $ids -}}
-				AND (innodeid IN ({{- $ids -}}))
-			{{- else -}}
-				AND 1=2
-			{{- end -}}
-		GROUP  BY To_char(intime, '{{.TimeFormat}}')
-		ORDER  BY To_char(intime, '{{.TimeFormat}}')) a
+		AND (intime >= {{.DBTimestamp .StartTime}}
+			AND intime < {{.DBTimestamp .EndTime}})
+			{{- nodes "innode" .InnodeNames .InnodeIds .InnodePatterns .InnodeExclusions -}}
+		GROUP  BY {{.Bucket "intime"}}
+		ORDER  BY {{.Bucket "intime"}}) a
 		FULL OUTER JOIN (SELECT CASE
 		WHEN c.time IS NOT NULL THEN c.time
 		WHEN d.time IS NOT NULL THEN d.time
@@ -115,26 +116,18 @@ const (
 			d.total_output_files,
 			d.total_output_cdrs,
 			d.total_output_bytes
-		FROM   (SELECT To_char(intime, '{{.TimeFormat}}') AS time,
+		FROM   (SELECT {{.Bucket "intime"}} AS time,
 			COALESCE(Sum (cdrs)::bigint, 0)       AS
 		total_input_cdrs
 		FROM   audittraillogentry
 		WHERE  event = 73
-			{{- $names := concat .InnodeNames -}}
-			{{- $ids := concat .InnodeIds -}}
-			{{- if and $names $ids -}}
-				AND (trim(innodename) IN ({{- $names -}}) OR innodeid IN ({{- $ids -}}))
-			{{- else if $names -}}
-				AND (trim(innodename) IN ({{- $names -}}))
-			{{- else if $ids -}}
-				AND (innodeid IN ({{- $ids -}}))
-			{{- else -}}
-				AND 1=2
-			{{- end -}}
-		GROUP  BY To_char(intime, '{{.TimeFormat}}')
-		ORDER  BY To_char(intime, '{{.TimeFormat}}')) c
+		AND (intime >= {{.DBTimestamp .StartTime}}
+			AND intime < {{.DBTimestamp .EndTime}})
+			{{- nodes "innode" .InnodeNames .InnodeIds .InnodePatterns .InnodeExclusions -}}
+		GROUP  BY {{.Bucket "intime"}}
+		ORDER  BY {{.Bucket "intime"}}) c
 		FULL OUTER JOIN (SELECT
-		To_char(outtime, '{{.TimeFormat}}')
+		{{.Bucket "outtime"}}
 		AS time,
 			Count(*)
 		AS
@@ -147,59 +140,308 @@ const (
 		total_output_bytes
 		FROM   audittraillogentry
 		WHERE  event = 68
-			{{- $names := concat .OutnodeNames -}}
-			{{- $ids := concat .OutnodeIds -}}
-			{{- if and $names $ids -}}
-				AND (trim(outnodename) IN ({{- $names -}}) OR outnodeid IN ({{- $ids -}}))
-			{{- else if $names -}}
-				AND (trim(outnodename) IN ({{- $names -}}))
-			{{- else if $ids -}}
-				AND (outnodeid IN ({{- $ids -}}))
-			{{- else -}}
-				AND 1=2
-			{{- end -}}
-		GROUP  BY To_char(outtime,
-			'{{.TimeFormat}}'
-		)
-		ORDER  BY To_char(outtime,
-			'{{.TimeFormat}}'
-		)) d
+		AND (outtime >= {{.DBTimestamp .StartTime}}
+			AND outtime < {{.DBTimestamp .EndTime}})
+			{{- nodes "outnode" .OutnodeNames .OutnodeIds .OutnodePatterns .OutnodeExclusions -}}
+		GROUP  BY {{.Bucket "outtime"}}
+		ORDER  BY {{.Bucket "outtime"}}) d
 		ON c.time = d.time) b
 		ON a.time = b.time`
+
+	// Template for generation of the last time each collector and distributor of the streams of a logical server was
+	// seen since the start of the lookback window, in a single scan per direction
+	freshnessQueryTemplate = `SELECT 'collector' AS node_type,
+			COALESCE(trim(innodename), '') AS node_name,
+			COALESCE(innodeid::text, '') AS node_id,
+			To_char(Max({{.ZonedColumn "intime"}}), 'YYYY-MM-DD HH24:MI:SS') AS last_seen,
+			Extract(epoch FROM (now() - Max({{.AbsoluteTime "intime"}})))::bigint AS silence_seconds
+		FROM   audittraillogentry
+		WHERE  (event = 67)
+		AND intime >= {{.DBTimestamp .StartTime}}
+			{{- nodes "innode" .InnodeNames .InnodeIds .InnodePatterns .InnodeExclusions }}
+		GROUP  BY COALESCE(trim(innodename), ''), innodeid
+		UNION ALL
+		SELECT 'distributor' AS node_type,
+			COALESCE(trim(outnodename), '') AS node_name,
+			COALESCE(outnodeid::text, '') AS node_id,
+			To_char(Max({{.ZonedColumn "outtime"}}), 'YYYY-MM-DD HH24:MI:SS') AS last_seen,
+			Extract(epoch FROM (now() - Max({{.AbsoluteTime "outtime"}})))::bigint AS silence_seconds
+		FROM   audittraillogentry
+		WHERE  (event = 68)
+		AND outtime >= {{.DBTimestamp .StartTime}}
+			{{- nodes "outnode" .OutnodeNames .OutnodeIds .OutnodePatterns .OutnodeExclusions }}
+		GROUP  BY COALESCE(trim(outnodename), ''), outnodeid`
+
+	// Template for generation of the collectors and distributors matched by the nodes of a stream during the period,
+	// with the number of files they processed
+	matchedNodesQueryTemplate = `SELECT 'collector' AS node_type,
+			COALESCE(trim(innodename), '') AS node_name,
+			COALESCE(innodeid::text, '') AS node_id,
+			Count(*) AS files
+		FROM   audittraillogentry
+		WHERE  (event = 67)
+		AND (intime >= {{.DBTimestamp .StartTime}}
+			AND intime < {{.DBTimestamp .EndTime}})
+			{{- nodes "innode" .InnodeNames .InnodeIds .InnodePatterns .InnodeExclusions }}
+		GROUP  BY COALESCE(trim(innodename), ''), innodeid
+		UNION ALL
+		SELECT 'distributor' AS node_type,
+			COALESCE(trim(outnodename), '') AS node_name,
+			COALESCE(outnodeid::text, '') AS node_id,
+			Count(*) AS files
+		FROM   audittraillogentry
+		WHERE  (event = 68)
+		AND (outtime >= {{.DBTimestamp .StartTime}}
+			AND outtime < {{.DBTimestamp .EndTime}})
+			{{- nodes "outnode" .OutnodeNames .OutnodeIds .OutnodePatterns .OutnodeExclusions }}
+		GROUP  BY COALESCE(trim(outnodename), ''), outnodeid
+		ORDER  BY node_type, node_name, node_id`
+
+	// Template for generation of all the collectors and distributors seen during the period, with the first and last
+	// time they processed a file, and the volume they processed
+	discoverQueryTemplate = `SELECT 'collector' AS node_type,
+			COALESCE(trim(innodename), '') AS node_name,
+			COALESCE(innodeid::text, '') AS node_id,
+			To_char(Min({{.ZonedColumn "intime"}}), 'YYYY-MM-DD HH24:MI:SS') AS first_seen,
+			To_char(Max({{.ZonedColumn "intime"}}), 'YYYY-MM-DD HH24:MI:SS') AS last_seen,
+			Count(*) AS files,
+			COALESCE(Sum(cdrs), 0)::bigint AS cdrs,
+			COALESCE(Sum(bytes), 0)::bigint AS bytes
+		FROM   audittraillogentry
+		WHERE  (event = 67)
+		AND (intime >= {{.DBTimestamp .StartTime}}
+			AND intime < {{.DBTimestamp .EndTime}})
+		GROUP  BY COALESCE(trim(innodename), ''), COALESCE(innodeid::text, '')
+		UNION ALL
+		SELECT 'distributor' AS node_type,
+			COALESCE(trim(outnodename), '') AS node_name,
+			COALESCE(outnodeid::text, '') AS node_id,
+			To_char(Min({{.ZonedColumn "outtime"}}), 'YYYY-MM-DD HH24:MI:SS') AS first_seen,
+			To_char(Max({{.ZonedColumn "outtime"}}), 'YYYY-MM-DD HH24:MI:SS') AS last_seen,
+			Count(*) AS files,
+			COALESCE(Sum(cdrs), 0)::bigint AS cdrs,
+			COALESCE(Sum(bytes), 0)::bigint AS bytes
+		FROM   audittraillogentry
+		WHERE  (event = 68)
+		AND (outtime >= {{.DBTimestamp .StartTime}}
+			AND outtime < {{.DBTimestamp .EndTime}})
+		GROUP  BY COALESCE(trim(outnodename), ''), COALESCE(outnodeid::text, '')
+		ORDER  BY node_type, node_name, node_id`
 )
 
+// regexNodePattern matches the node patterns which are regular expressions between slashes (e.g. /^toHW_.*_in_/),
+// other node patterns are SQL LIKE patterns (e.g. 4G_LTE_%)
+var regexNodePattern = regexp.MustCompile(`^/(.+)/$`)
+
 type AudittrailLogEntryQueryParameters struct {
 	StartTime    string
 	EndTime      string
 	TimeFormat   string
+	Interval     time.Duration
+	TimeZone     string
+	DBTimeZone   string
 	Collectors   []string
 	Distributors []string
 	InnodeNames  []string
 	OutnodeNames []string
 	InnodeIds    []string
 	OutnodeIds   []string
+
+	// Patterns match the node names using SQL LIKE patterns or regular expressions, and Exclusions remove nodes
+	// matched by the patterns
+	InnodePatterns    []string
+	OutnodePatterns   []string
+	InnodeExclusions  []string
+	OutnodeExclusions []string
 }
 
-func parseTemplate(templateName string, queryTemplate string, paramStruct interface{}) string {
-	var actualQuery bytes.Buffer
+// queryArguments contains the values bound to the placeholders of a query, so that the values of the configuration
+// are never written in the query text
+type queryArguments struct {
+	values []interface{}
+}
 
-	funcMap := template.FuncMap{
-		"inc": func(i int) int {
-			return i + 1
-		},
-		"concat": func(args []string) string {
-			result := ""
+// bind adds a value to the arguments, and returns its placeholder
+func (a *queryArguments) bind(value interface{}) string {
+	a.values = append(a.values, value)
+
+	return fmt.Sprintf("$%d", len(a.values))
+}
+
+// bindAll adds the values to the arguments, and returns their comma separated placeholders
+func (a *queryArguments) bindAll(values []string) string {
+	var placeholders []string
+
+	for _, value := range values {
+		placeholders = append(placeholders, a.bind(value))
+	}
+
+	return strings.Join(placeholders, ",")
+}
+
+// nodePredicate returns the condition selecting the nodes (innode or outnode) by names, ids, or patterns. Exclusions
+// only remove the nodes matched by the patterns, nodes listed by name or id are always selected. The condition never
+// matches if no node is specified
+func (a *queryArguments) nodePredicate(node string, names, ids, patterns, exclusions []string) string {
+	var conditions []string
+
+	nameColumn := fmt.Sprintf("trim(%sname)", node)
+
+	if len(names) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s IN (%s)", nameColumn, a.bindAll(names)))
+	}
+
+	if len(ids) > 0 {
+		conditions = append(conditions, fmt.Sprintf("%sid IN (%s)", node, a.bindAll(ids)))
+	}
+
+	if len(patterns) > 0 {
+		condition := a.patternsCondition(nameColumn, patterns)
+
+		if len(exclusions) > 0 {
+			condition = fmt.Sprintf("(%s AND NOT %s)", condition,
+				a.patternsCondition(fmt.Sprintf("COALESCE(%s, '')", nameColumn), exclusions))
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	if len(conditions) == 0 {
+		return "AND 1=2"
+	}
+
+	return fmt.Sprintf("AND (%s)", strings.Join(conditions, " OR "))
+}
+
+// patternsCondition returns the condition matching the column with any of the patterns
+func (a *queryArguments) patternsCondition(column string, patterns []string) string {
+	var conditions []string
+
+	for _, pattern := range patterns {
+		if match := regexNodePattern.FindStringSubmatch(pattern); match != nil {
+			conditions = append(conditions, fmt.Sprintf("%s ~ %s", column, a.bind(match[1])))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s LIKE %s", column, a.bind(pattern)))
+		}
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(conditions, " OR "))
+}
+
+// matchNodePattern returns true if the node name matches the SQL LIKE pattern, or the regular expression between
+// slashes, the same way the database does
+func matchNodePattern(pattern string, name string) bool {
+	if match := regexNodePattern.FindStringSubmatch(pattern); match != nil {
+		expression, err := regexp.Compile(match[1])
+
+		return err == nil && expression.MatchString(name)
+	}
 
-			for i, arg := range args {
-				result += fmt.Sprintf("'%s'", arg)
+	var expression strings.Builder
 
-				if i+1 != len(args) {
-					result += ","
-				}
+	escaped := false
+
+	// Backslash escapes the wildcards, as the default escape character of LIKE
+	for _, character := range pattern {
+		switch {
+		case escaped:
+			expression.WriteString(regexp.QuoteMeta(string(character)))
+			escaped = false
+		case character == '\\':
+			escaped = true
+		case character == '%':
+			expression.WriteString(".*")
+		case character == '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(character)))
+		}
+	}
+
+	matches, _ := regexp.MatchString("^(?s:"+expression.String()+")$", name)
+
+	return matches
+}
+
+// validateNodePatterns makes sure the regular expressions of the node patterns are valid
+func validateNodePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if match := regexNodePattern.FindStringSubmatch(pattern); match != nil {
+			if _, err := regexp.Compile(match[1]); err != nil {
+				return fmt.Errorf("invalid node pattern %s", pattern)
 			}
+		}
+	}
+
+	return nil
+}
+
+// ZonedColumn returns the expression of a timestamp column converted from the database time zone to the report time
+// zone. The timestamp columns of audittraillogentry are stored without time zone, so converting them is done in two
+// steps, first to an absolute time using the database time zone, then to the local time of the report time zone. This
+// keeps the buckets correct across daylight saving time changes
+func (p AudittrailLogEntryQueryParameters) ZonedColumn(column string) string {
+	if p.TimeZone == p.DBTimeZone {
+		return column
+	}
+
+	return fmt.Sprintf("((%s AT TIME ZONE %s) AT TIME ZONE %s)", column, zoneExpression(p.DBTimeZone),
+		zoneExpression(p.TimeZone))
+}
+
+// AbsoluteTime returns the expression of a timestamp column converted from the database time zone to an absolute
+// time, so that it can be compared with the current time
+func (p AudittrailLogEntryQueryParameters) AbsoluteTime(column string) string {
+	return fmt.Sprintf("(%s AT TIME ZONE %s)", column, zoneExpression(p.DBTimeZone))
+}
 
-			return result
+// Bucket returns the expression of the time bucket of a timestamp column, formatted using the time format. Timestamps
+// are aligned on the interval (if specified) since the epoch before formatting
+func (p AudittrailLogEntryQueryParameters) Bucket(column string) string {
+	bucketStart := p.ZonedColumn(column)
+
+	if seconds := int64(p.Interval / time.Second); seconds > 0 {
+		bucketStart = fmt.Sprintf("('epoch'::timestamp + floor(extract(epoch FROM %s) / %d) * %d * interval '1 second')",
+			bucketStart, seconds, seconds)
+	}
+
+	return fmt.Sprintf("To_char(%s, '%s')", bucketStart, p.TimeFormat)
+}
+
+// DBTimestamp returns the expression of a time value (in the format YYYYMMDDHH24MISS) in the report time zone
+// converted to the database time zone, so that it can be compared directly with the timestamp columns
+func (p AudittrailLogEntryQueryParameters) DBTimestamp(value string) string {
+	timestamp := fmt.Sprintf("to_timestamp('%s', '%s')::timestamp", value, timestampDBFormat)
+
+	if p.TimeZone == p.DBTimeZone {
+		return timestamp
+	}
+
+	return fmt.Sprintf("((%s AT TIME ZONE %s) AT TIME ZONE %s)", timestamp, zoneExpression(p.TimeZone),
+		zoneExpression(p.DBTimeZone))
+}
+
+// zoneExpression returns the SQL expression of a time zone, empty time zone is the database session time zone
+func zoneExpression(timeZone string) string {
+	if len(timeZone) == 0 {
+		return "current_setting('TimeZone')"
+	}
+
+	return fmt.Sprintf("'%s'", timeZone)
+}
+
+// parseTemplate generates the query of the template, and returns it with the values bound to its placeholders
+func parseTemplate(templateName string, queryTemplate string, paramStruct interface{}) (string, []interface{}) {
+	var actualQuery bytes.Buffer
+
+	arguments := &queryArguments{}
+
+	funcMap := template.FuncMap{
+		"inc": func(i int) int {
+			return i + 1
 		},
+		"concat": arguments.bindAll,
+		"nodes":  arguments.nodePredicate,
 	}
 
 	parsedTemplate := template.Must(template.New(templateName).Funcs(funcMap).Parse(queryTemplate))
@@ -210,5 +452,5 @@ func parseTemplate(templateName string, queryTemplate string, paramStruct interf
 		fmt.Println(err)
 	}
 
-	return actualQuery.String()
+	return actualQuery.String(), arguments.values
 }
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 )
 
 func TestParseTemplate(t *testing.T) {
 	var query string
+	var args []interface{}
 	var queryParams AudittrailLogEntryQueryParameters
 
 	templateText := `
@@ -24,7 +26,7 @@ func TestParseTemplate(t *testing.T) {
 		InnodeNames: []string{},
 		InnodeIds:   []string{},
 	}
-	query = parseTemplate("", templateText, queryParams)
+	query, _ = parseTemplate("", templateText, queryParams)
 	if query != "" {
 		t.Errorf("Expecting '', but got '%s'", query)
 	}
@@ -33,9 +35,12 @@ func TestParseTemplate(t *testing.T) {
 	queryParams = AudittrailLogEntryQueryParameters{
 		InnodeNames: []string{"node1", "node2"},
 	}
-	query = parseTemplate("", templateText, queryParams)
-	if query != "AND (innodenames IN (node1,node2))" {
-		t.Errorf("Expecting 'AND (innodenames IN (node1,node2))', but got '%s'", query)
+	query, args = parseTemplate("", templateText, queryParams)
+	if query != "AND (innodenames IN ($1,$2))" {
+		t.Errorf("Expecting 'AND (innodenames IN ($1,$2))', but got '%s'", query)
+	}
+	if expected := []interface{}{"node1", "node2"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, args)
 	}
 
 	// Names and Ids
@@ -43,8 +48,85 @@ func TestParseTemplate(t *testing.T) {
 		InnodeNames: []string{"node1", "node2"},
 		InnodeIds:   []string{"10", "20"},
 	}
-	query = parseTemplate("", templateText, queryParams)
-	if query != "AND (innodenames IN (node1,node2) OR innodeids IN (10,20))" {
-		t.Errorf("Expecting 'AND (innodenames IN (node1,node2) OR innodeids IN (10,20))', but got '%s'", query)
+	query, args = parseTemplate("", templateText, queryParams)
+	if query != "AND (innodenames IN ($1,$2) OR innodeids IN ($3,$4))" {
+		t.Errorf("Expecting 'AND (innodenames IN ($1,$2) OR innodeids IN ($3,$4))', but got '%s'", query)
+	}
+	if expected := []interface{}{"node1", "node2", "10", "20"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, args)
+	}
+}
+
+func TestAudittrailLogEntryQueryParameters_TimeZones(t *testing.T) {
+	params := AudittrailLogEntryQueryParameters{}
+
+	if column := params.ZonedColumn("intime"); column != "intime" {
+		t.Errorf("Expecting 'intime', but got '%s'", column)
+	}
+
+	params = AudittrailLogEntryQueryParameters{TimeZone: "Europe/Berlin", DBTimeZone: "Asia/Riyadh"}
+
+	expected := "((intime AT TIME ZONE 'Asia/Riyadh') AT TIME ZONE 'Europe/Berlin')"
+	if column := params.ZonedColumn("intime"); column != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, column)
+	}
+
+	expected = "((to_timestamp('20190325000000', 'YYYYMMDDHH24MISS')::timestamp AT TIME ZONE 'Europe/Berlin') " +
+		"AT TIME ZONE 'Asia/Riyadh')"
+	if timestamp := params.DBTimestamp("20190325000000"); timestamp != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, timestamp)
+	}
+
+	params = AudittrailLogEntryQueryParameters{TimeZone: "Europe/Berlin"}
+
+	expected = "((intime AT TIME ZONE current_setting('TimeZone')) AT TIME ZONE 'Europe/Berlin')"
+	if column := params.ZonedColumn("intime"); column != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, column)
+	}
+}
+
+func TestNodePredicate(t *testing.T) {
+	arguments := &queryArguments{}
+
+	if predicate := arguments.nodePredicate("innode", nil, nil, nil, []string{"x"}); predicate != "AND 1=2" {
+		t.Errorf("Expecting 'AND 1=2', but got '%s'", predicate)
+	}
+
+	predicate := arguments.nodePredicate("innode", []string{"INPUT"}, nil, []string{"4G_LTE_%", "/^toHW_.*_in_/"},
+		[]string{"%_TEST"})
+	expected := "AND (trim(innodename) IN ($1) OR ((trim(innodename) LIKE $2 OR trim(innodename) ~ $3) AND NOT " +
+		"(COALESCE(trim(innodename), '') LIKE $4)))"
+
+	if predicate != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, predicate)
+	}
+
+	if expected := []interface{}{"INPUT", "4G_LTE_%", "^toHW_.*_in_", "%_TEST"}; !reflect.DeepEqual(arguments.values,
+		expected) {
+		t.Errorf("Expecting %v, but got %v", expected, arguments.values)
+	}
+}
+
+func TestMatchNodePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"4G_LTE_%", "4G_LTE_RD1", true},
+		{"4G_LTE_%", "4G-LTE_RD1", true},
+		{"4G_LTE_%", "X4G_LTE_RD1", false},
+		{`4G\_LTE\_%`, "4G_LTE_RD1", true},
+		{`4G\_LTE\_%`, "4G-LTE_RD1", false},
+		{"to%.in", "toHW.in", true},
+		{"to%.in", "toHWxin", false},
+		{"/^toHW_.*_in_/", "toHW_PGW_in_RD", true},
+		{"/^toHW_.*_in_/", "to4G_LTE_in_RD", false},
+	}
+
+	for _, test := range tests {
+		if matches := matchNodePattern(test.pattern, test.name); matches != test.expected {
+			t.Errorf("Expecting %s matching %s to be %v, but got %v", test.pattern, test.name, test.expected, matches)
+		}
 	}
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gota/gota/series"
+	"github.com/montanaflynn/stats"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bytesPerMB is the number of bytes in a megabyte, used to express bytes rates in MB
+const bytesPerMB = byteUnitBase * byteUnitBase
+
+// rateUnit is the time unit of the rate columns (e.g. files per second)
+type rateUnit struct {
+	name     string
+	suffix   string
+	duration time.Duration
+}
+
+// rateUnits contains all the supported rate units
+var rateUnits = map[string]rateUnit{
+	"second": {name: "second", suffix: "_per_sec", duration: time.Second},
+	"minute": {name: "minute", suffix: "_per_min", duration: time.Minute},
+}
+
+// chooseRateUnit returns the rate unit matching the name
+func chooseRateUnit(name string) (rateUnit, error) {
+	if unit, found := rateUnits[name]; found {
+		return unit, nil
+	}
+
+	return rateUnit{}, fmt.Errorf("invalid rate unit %s", name)
+}
+
+// rateColumnName returns the name of the rate column of a files, CDRs or bytes column (e.g. input_cdrs_per_sec,
+// input_mb_per_sec), it returns false for other columns
+func rateColumnName(columnName string, unit rateUnit) (string, bool) {
+	switch {
+	case strings.HasSuffix(columnName, "_bytes"):
+		return strings.TrimSuffix(columnName, "_bytes") + "_mb" + unit.suffix, true
+	case strings.HasSuffix(columnName, "_files"), strings.HasSuffix(columnName, "_cdrs"):
+		return columnName + unit.suffix, true
+	}
+
+	return "", false
+}
+
+// AddRates adds a rate column for each files, CDRs and bytes column of the default table. The rate of a bucket is its
+// value divided by its duration, durations are taken by bucket label so that the first and last buckets clipped by
+// the report period get correct rates. The names of the added columns are returned
+func (r *Report) AddRates(durations map[string]time.Duration, unit rateUnit) []string {
+	var rateColumns []string
+
+	table := r.GetDefaultTable()
+
+	if indexOf(table.GetColumnsNames(), timeColumn) < 0 || table.data.Nrow() == 0 {
+		return rateColumns
+	}
+
+	times := table.GetColumnSeries(timeColumn).Records()
+
+	for _, columnName := range table.GetColumnsNames() {
+		rateColumn, isRate := rateColumnName(columnName, unit)
+
+		if !isRate || !isNumericType(table.GetColumnsDataTypes()[columnName]) {
+			continue
+		}
+
+		values := table.GetColumnSeries(columnName).Float()
+		rates := make([]float64, len(values))
+
+		for i, value := range values {
+			units := float64(durations[times[i]]) / float64(unit.duration)
+
+			if strings.HasSuffix(columnName, "_bytes") {
+				value = value / bytesPerMB
+			}
+
+			if units > 0 {
+				rates[i] = value / units
+			}
+		}
+
+		table.data = table.data.Mutate(series.New(rates, series.Float, rateColumn))
+		table.columnsNames = append(table.columnsNames, rateColumn)
+		table.columnsDataTypes[rateColumn] = series.Float
+		rateColumns = append(rateColumns, rateColumn)
+	}
+
+	return rateColumns
+}
+
+// AddPeakRatesHeaders adds a header for each of the rate columns, containing its peak rate and the time bucket of the
+// peak
+func (r *Report) AddPeakRatesHeaders(rateColumns []string) {
+	times := r.GetDefaultTable().GetColumnSeries(timeColumn).Records()
+
+	for _, rateColumn := range rateColumns {
+		rates := r.GetDefaultTable().GetColumnSeries(rateColumn).Float()
+		peak, err := stats.Max(rates)
+
+		if err != nil {
+			continue
+		}
+
+		for i := range rates {
+			if rates[i] == peak {
+				r.AddHeader(fmt.Sprintf("Peak %s", rateColumn),
+					fmt.Sprintf("%s at %s", strconv.FormatFloat(peak, 'f', 3, 64), times[i]))
+				break
+			}
+		}
+	}
+}
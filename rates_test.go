@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReport_AddRates(t *testing.T) {
+	report := Report{defaultTable: &ResultSet{
+		columnsNames:     []string{"time", "input_cdrs", "input_bytes"},
+		columnsDataTypes: map[string]series.Type{"time": series.String, "input_cdrs": series.Int, "input_bytes": series.Int},
+	}}
+
+	report.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_cdrs", "input_bytes"},
+		{"2019032522", "1800", "1800000000"},
+		{"2019032523", "7200", "0"},
+	}, dataframe.WithTypes(report.defaultTable.columnsDataTypes))
+
+	durations := map[string]time.Duration{"2019032522": 30 * time.Minute, "2019032523": time.Hour}
+	unit, _ := chooseRateUnit("second")
+
+	rateColumns := report.AddRates(durations, unit)
+
+	if expected := []string{"input_cdrs_per_sec", "input_mb_per_sec"}; !reflect.DeepEqual(rateColumns, expected) {
+		t.Fatalf("Expecting %v, but got %v", expected, rateColumns)
+	}
+
+	if rates := report.GetDefaultTable().GetColumnSeries("input_cdrs_per_sec").Float(); !reflect.DeepEqual(rates,
+		[]float64{1, 2}) {
+		t.Errorf("Expecting [1 2], but got %v", rates)
+	}
+
+	if rates := report.GetDefaultTable().GetColumnSeries("input_mb_per_sec").Float(); !reflect.DeepEqual(rates,
+		[]float64{1, 0}) {
+		t.Errorf("Expecting [1 0], but got %v", rates)
+	}
+
+	report.AddPeakRatesHeaders(rateColumns)
+
+	if len(report.headers) != 2 || report.headers[0].value != "2.000 at 2019032523" {
+		t.Errorf("Unexpected peak rates headers %v", report.headers)
+	}
+}
@@ -6,21 +6,61 @@ import (
 	"github.com/go-gota/gota/series"
 	"github.com/jmoiron/sqlx"
 	"github.com/kniren/gota/dataframe"
-	"github.com/montanaflynn/stats"
 	"github.com/olekukonko/tablewriter"
+	"io"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 )
 
+const (
+	// timeColumn is the name of the column which contains the time bucket in the throughput queries
+	timeColumn = "time"
+
+	// gapMarker is appended to the time of the buckets which did not receive any data
+	gapMarker  = " *"
+	gapCaption = "* No data received in the time bucket"
+
+	// highlightMarker is appended to highlighted values (e.g. changes beyond the threshold in comparison reports)
+	highlightMarker = " !"
+
+	// naValue is written for missing values
+	naValue = "NA"
+)
+
+// cell identifies a value of a result set by its row and column indexes
+type cell struct {
+	row    int
+	column int
+}
+
 type ResultSet struct {
+	columnsNames     []string
 	columnsDataTypes map[string]series.Type
 	data             dataframe.DataFrame
 	table            *tablewriter.Table
+
+	// gapRows contains the indexes of the rows added for empty time buckets
+	gapRows map[int]bool
+
+	// textRows contains the indexes of the rows which are not formatted in human readable mode, although they are in
+	// numeric columns (e.g. time of the max value in summary tables)
+	textRows map[int]bool
+
+	// highlights contains the highlighted values, they are marked with the highlight marker, and explained by the
+	// highlight caption
+	highlights       map[cell]bool
+	highlightCaption string
 }
 
 func (r *ResultSet) GetColumnsNames() []string {
-	return r.data.Names()
+	if names := r.data.Names(); len(names) > 0 {
+		return names
+	}
+
+	return r.columnsNames
 }
 
 func (r *ResultSet) GetColumnsDataTypes() map[string]series.Type {
@@ -41,9 +81,7 @@ func (r *ResultSet) WriteToTxtFile(filename string) {
 	}
 
 	table := tablewriter.NewWriter(file)
-	table.SetHeader(r.data.Names())
-	table.AppendBulk(r.data.Records()[1:])
-	table.Render()
+	r.renderTable(table, nil)
 }
 
 func (r *ResultSet) WriteToConsole() {
@@ -51,10 +89,80 @@ func (r *ResultSet) WriteToConsole() {
 		r.table = tablewriter.NewWriter(os.Stdout)
 	}
 
-	r.table.SetHeader(r.data.Names())
-	r.table.AppendBulk(r.data.Records()[1:])
 	fmt.Fprintf(os.Stdout, "\n")
-	r.table.Render()
+	r.renderTable(r.table, nil)
+}
+
+// renderTable writes the result set to the table, rows of empty time buckets are marked with the gap marker. Numbers
+// are formatted in human readable mode if the number format is specified
+func (r *ResultSet) renderTable(table *tablewriter.Table, numbers *numberFormat) {
+	if captions := r.captions(); len(captions) > 0 {
+		table.SetCaption(true, strings.Join(captions, ", "))
+	}
+
+	table.SetHeader(r.GetColumnsNames())
+	table.AppendBulk(r.displayRecords(numbers))
+	table.Render()
+}
+
+// captions returns the explanations of the markers used in the result set
+func (r *ResultSet) captions() []string {
+	var captions []string
+
+	if len(r.gapRows) > 0 {
+		captions = append(captions, gapCaption)
+	}
+
+	if len(r.highlights) > 0 {
+		captions = append(captions, r.highlightCaption)
+	}
+
+	return captions
+}
+
+// records returns the records of the result set (with header), missing values (e.g. percentages of zero baselines,
+// statistics which cannot be computed) are written as NA rather than as the NaN of the data frame
+func (r *ResultSet) records() [][]string {
+	records := r.data.Records()
+
+	for _, record := range records[1:] {
+		for i, value := range record {
+			if value == "NaN" {
+				record[i] = naValue
+			}
+		}
+	}
+
+	return records
+}
+
+// displayRecords returns the records of the result set (without header) as displayed in text outputs, the time of
+// empty time buckets is marked with the gap marker, and numeric columns are formatted using the number format if
+// specified
+func (r *ResultSet) displayRecords(numbers *numberFormat) [][]string {
+	var records [][]string
+
+	columns := r.GetColumnsNames()
+
+	for rowIndex, record := range r.records()[1:] {
+		displayed := append([]string{}, record...)
+
+		for i, columnName := range columns {
+			if columnName == timeColumn && r.gapRows[rowIndex] {
+				displayed[i] += gapMarker
+			} else if numbers != nil && !r.textRows[rowIndex] && isNumericType(r.columnsDataTypes[columnName]) {
+				displayed[i] = numbers.humanize(columnName, displayed[i])
+			}
+
+			if r.highlights[cell{row: rowIndex, column: i}] {
+				displayed[i] += highlightMarker
+			}
+		}
+
+		records = append(records, displayed)
+	}
+
+	return records
 }
 
 func (r *ResultSet) WriteToCSVFile(filename string) {
@@ -68,20 +176,39 @@ func (r *ResultSet) WriteToCSVFile(filename string) {
 
 	w := csv.NewWriter(file)
 
-	for _, record := range r.data.Records() {
+	for _, record := range r.records() {
 		w.Write(record)
 	}
 
 	w.Flush()
 }
 
+// Report contains the tables of a report, and its header. The breakdown table details the default table (e.g.
+// throughput of each logical server of a stream), it is only created by the reports supporting it
 type Report struct {
-	name         string
-	defaultTable *ResultSet
-	avgTable     *ResultSet
-	sumTable     *ResultSet
-	minTable     *ResultSet
-	maxTable     *ResultSet
+	name           string
+	headers        []reportHeader
+	defaultTable   *ResultSet
+	summaryTable   *ResultSet
+	breakdownTable *ResultSet
+}
+
+// reportHeader is a property of the report (e.g. time zone) displayed before the report tables
+type reportHeader struct {
+	name  string
+	value string
+}
+
+// AddHeader adds a property to the header of the report
+func (r *Report) AddHeader(name string, value string) {
+	r.headers = append(r.headers, reportHeader{name: name, value: value})
+}
+
+// WriteHeader writes the properties of the report header, one property per line
+func (r *Report) WriteHeader(writer io.Writer) {
+	for _, header := range r.headers {
+		fmt.Fprintf(writer, "%s: %s\n", header.name, header.value)
+	}
 }
 
 func (r *Report) ExtractResultSet(rows *sqlx.Rows) {
@@ -99,6 +226,8 @@ func (r *Report) ExtractResultSet(rows *sqlx.Rows) {
 		r.defaultTable = &ResultSet{}
 	}
 
+	r.defaultTable.columnsNames = columns
+
 	columnsTypes, err := rows.ColumnTypes()
 
 	r.defaultTable.columnsDataTypes = map[string]series.Type{}
@@ -126,128 +255,126 @@ func (r *Report) ExtractResultSet(rows *sqlx.Rows) {
 	r.defaultTable.data = dataframe.LoadRecords(data, dataframe.WithTypes(r.defaultTable.columnsDataTypes))
 }
 
-func (r *Report) GetDefaultTable() *ResultSet {
-	if r.defaultTable == nil {
-		r.defaultTable = &ResultSet{}
-	}
+// FillGaps makes sure the default table contains a row for every bucket in the buckets series. Buckets missing from
+// the query result (i.e. no data received during the bucket) are added with zero values, and marked as gaps. The rows
+// are sorted by time
+func (r *Report) FillGaps(buckets []string) {
+	table := r.GetDefaultTable()
+	columns := table.GetColumnsNames()
+	timeIndex := indexOf(columns, timeColumn)
 
-	return r.defaultTable
-}
-
-func (r *Report) GetAvgTable() *ResultSet {
-
-	if r.avgTable == nil {
-		var statsFields []string
-		var records [][]string
+	if timeIndex < 0 {
+		return
+	}
 
-		r.avgTable = &ResultSet{}
+	records := table.data.Records()[1:]
+	existing := map[string]bool{}
+	gaps := map[string]bool{}
 
-		records = append(records, r.GetDefaultTable().GetColumnsNames())
+	for _, record := range records {
+		existing[record[timeIndex]] = true
+	}
 
-		for _, columnName := range r.GetDefaultTable().GetColumnsNames() {
+	for _, bucket := range buckets {
+		if existing[bucket] {
+			continue
+		}
 
-			columnDataType := r.GetDefaultTable().GetColumnsDataTypes()[columnName]
+		record := make([]string, len(columns))
 
-			// Generate average for Float and Int columns only
-			if columnDataType == series.Float || columnDataType == series.Int {
-				if avg, err := stats.Mean(r.GetDefaultTable().GetColumnSeries(columnName).Float()); err == nil {
-					statsFields = append(statsFields, strconv.FormatFloat(avg, 'f', -1, 64))
-				}
-			} else {
-				statsFields = append(statsFields, "NA")
+		for i, columnName := range columns {
+			if i == timeIndex {
+				record[i] = bucket
+			} else if isNumericType(table.GetColumnsDataTypes()[columnName]) {
+				record[i] = "0"
 			}
 		}
 
-		records = append(records, statsFields)
-		r.avgTable.data = dataframe.LoadRecords(records, dataframe.DefaultType(series.String), dataframe.DetectTypes(false))
+		gaps[bucket] = true
+		records = append(records, record)
 	}
 
-	return r.avgTable
-}
+	if len(records) == 0 {
+		return
+	}
 
-func (r *Report) GetSumTable() *ResultSet {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i][timeIndex] < records[j][timeIndex]
+	})
 
-	if r.avgTable == nil {
-		var statsFields []string
-		var records [][]string
+	table.gapRows = map[int]bool{}
 
-		r.sumTable = &ResultSet{}
-		records = append(records, r.GetDefaultTable().GetColumnsNames())
+	for i, record := range records {
+		if gaps[record[timeIndex]] {
+			table.gapRows[i] = true
+		}
+	}
 
-		for _, columnName := range r.defaultTable.GetColumnsNames() {
-			// Generate average for Float and Int columns only
-			if r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Float ||
-				r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Int {
+	table.data = dataframe.LoadRecords(append([][]string{columns}, records...),
+		dataframe.WithTypes(table.GetColumnsDataTypes()))
+}
 
-				if sum, err := stats.Sum(r.GetDefaultTable().GetColumnSeries(columnName).Float()); err == nil {
-					statsFields = append(statsFields, strconv.FormatFloat(sum, 'f', -1, 64))
-				}
-			} else {
-				statsFields = append(statsFields, "NA")
-			}
-		}
-		records = append(records, statsFields)
-		r.sumTable.data = dataframe.LoadRecords(records, dataframe.DefaultType(series.String), dataframe.DetectTypes(false))
+func (r *Report) GetDefaultTable() *ResultSet {
+	if r.defaultTable == nil {
+		r.defaultTable = &ResultSet{}
 	}
 
-	return r.sumTable
+	return r.defaultTable
 }
 
-func (r *Report) GetMinTable() *ResultSet {
+// GetSummaryTable returns a table containing a row for each of the statistics, and a column for each numeric column
+// of the default table. The summary table is computed once, and the statistics are ignored in subsequent calls
+func (r *Report) GetSummaryTable(statistics []summaryStatistic) *ResultSet {
 
-	if r.minTable == nil {
-		var statsFields []string
+	if r.summaryTable == nil {
 		var records [][]string
+		var numericColumns []string
 
-		r.minTable = &ResultSet{}
-		records = append(records, r.GetDefaultTable().GetColumnsNames())
-
-		for _, columnName := range r.defaultTable.GetColumnsNames() {
-			// Generate average for Float and Int columns only
-			if r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Float ||
-				r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Int {
+		r.summaryTable = &ResultSet{}
 
-				if min, err := stats.Min(r.GetDefaultTable().GetColumnSeries(columnName).Float()); err == nil {
-					statsFields = append(statsFields, strconv.FormatFloat(min, 'f', -1, 64))
-				}
-			} else {
-				statsFields = append(statsFields, "NA")
+		for _, columnName := range r.GetDefaultTable().GetColumnsNames() {
+			if isNumericType(r.GetDefaultTable().GetColumnsDataTypes()[columnName]) {
+				numericColumns = append(numericColumns, columnName)
 			}
 		}
 
-		records = append(records, statsFields)
-		r.minTable.data = dataframe.LoadRecords(records, dataframe.DefaultType(series.String), dataframe.DetectTypes(false))
-	}
+		var times []string
 
-	return r.minTable
-}
+		if indexOf(r.GetDefaultTable().GetColumnsNames(), timeColumn) >= 0 {
+			times = r.GetDefaultTable().GetColumnSeries(timeColumn).Records()
+		}
 
-func (r *Report) GetMaxTable() *ResultSet {
-	if r.maxTable == nil {
-		var statsFields []string
-		var records [][]string
+		r.summaryTable.columnsNames = append([]string{statisticColumn}, numericColumns...)
+		r.summaryTable.columnsDataTypes = map[string]series.Type{statisticColumn: series.String}
+		r.summaryTable.textRows = map[int]bool{}
+		records = append(records, r.summaryTable.columnsNames)
 
-		r.maxTable = &ResultSet{}
-		records = append(records, r.GetDefaultTable().GetColumnsNames())
+		for _, columnName := range numericColumns {
+			r.summaryTable.columnsDataTypes[columnName] = r.GetDefaultTable().GetColumnsDataTypes()[columnName]
+		}
 
-		for _, columnName := range r.GetDefaultTable().GetColumnsNames() {
-			// Generate average for Float and Int columns only
-			if r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Float ||
-				r.GetDefaultTable().GetColumnsDataTypes()[columnName] == series.Int {
+		for i, statistic := range statistics {
+			statsFields := []string{statistic.name}
+			r.summaryTable.textRows[i] = statistic.timeValued
+
+			for _, columnName := range numericColumns {
+				value, err := statistic.compute(r.GetDefaultTable().GetColumnSeries(columnName).Float(), times)
 
-				if max, err := stats.Max(r.GetDefaultTable().GetColumnSeries(columnName).Float()); err == nil {
-					statsFields = append(statsFields, strconv.FormatFloat(max, 'f', -1, 64))
+				if err != nil {
+					value = naValue
 				}
-			} else {
-				statsFields = append(statsFields, "NA")
+
+				statsFields = append(statsFields, value)
 			}
+
+			records = append(records, statsFields)
 		}
 
-		records = append(records, statsFields)
-		r.maxTable.data = dataframe.LoadRecords(records, dataframe.DefaultType(series.String), dataframe.DetectTypes(false))
+		r.summaryTable.data = dataframe.LoadRecords(records, dataframe.DefaultType(series.String),
+			dataframe.DetectTypes(false))
 	}
 
-	return r.maxTable
+	return r.summaryTable
 }
 
 func mapReflectTypeToSeriesType(reflectType reflect.Type) series.Type {
@@ -268,6 +395,21 @@ func mapReflectTypeToSeriesType(reflectType reflect.Type) series.Type {
 	return series.String
 }
 
+func isNumericType(seriesType series.Type) bool {
+	return seriesType == series.Float || seriesType == series.Int
+}
+
+// indexOf returns the index of value in values, or -1 if the values do not contain it
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
 func rowFieldToString(field interface{}) string {
 	var v = reflect.ValueOf(field)
 	var t = reflect.TypeOf(field)
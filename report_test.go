@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"github.com/go-gota/gota/series"
 	"github.com/kniren/gota/dataframe"
+	"reflect"
 	"testing"
 )
 
@@ -14,3 +16,72 @@ func TestReport_ExtractData(t *testing.T) {
 
 	fmt.Println(df.String())
 }
+
+func TestReport_FillGaps(t *testing.T) {
+	report := Report{defaultTable: &ResultSet{
+		columnsNames:     []string{"time", "input_files"},
+		columnsDataTypes: map[string]series.Type{"time": series.String, "input_files": series.Int},
+	}}
+
+	report.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_files"},
+		{"20190327", "15"},
+		{"20190325", "10"},
+	}, dataframe.WithTypes(report.defaultTable.columnsDataTypes))
+
+	report.FillGaps([]string{"20190325", "20190326", "20190327"})
+
+	records := report.GetDefaultTable().data.Records()
+	expected := [][]string{
+		{"time", "input_files"},
+		{"20190325", "10"},
+		{"20190326", "0"},
+		{"20190327", "15"},
+	}
+
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+
+	if len(report.GetDefaultTable().gapRows) != 1 || !report.GetDefaultTable().gapRows[1] {
+		t.Errorf("Expecting only row 1 to be marked as gap, but got %v", report.GetDefaultTable().gapRows)
+	}
+}
+
+func TestReport_GetSummaryTable(t *testing.T) {
+	report := Report{defaultTable: &ResultSet{
+		columnsNames:     []string{"time", "input_files"},
+		columnsDataTypes: map[string]series.Type{"time": series.String, "input_files": series.Int},
+	}}
+
+	report.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_files"},
+		{"20190325", "10"},
+		{"20190326", "40"},
+		{"20190327", "20"},
+		{"20190328", "30"},
+	}, dataframe.WithTypes(report.defaultTable.columnsDataTypes))
+
+	statistics, err := parseSummaryStatistics("avg,p50,max,max_time,sum")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	records := report.GetSummaryTable(statistics).data.Records()
+	expected := [][]string{
+		{"statistic", "input_files"},
+		{"avg", "25"},
+		{"p50", "20"},
+		{"max", "40"},
+		{"max_time", "20190326"},
+		{"sum", "100"},
+	}
+
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+
+	if _, err := parseSummaryStatistics("avg,median"); err == nil {
+		t.Errorf("Expecting error for 'median'")
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Default files of the scheduler, relative to the working directory
+	defaultSchedulerLockFile  = "emmstats-scheduler.lock"
+	defaultScheduleStateFile  = "emmstats-schedule.json"
+	defaultRunHistoryFile     = "emmstats-runs.log"
+	defaultScheduleCatchUpAge = "24h"
+
+	// Run statuses logged in the run history
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+	runSkipped   = "skipped"
+
+	// runOutputLimit is the number of trailing bytes of the output of a run kept in the run history
+	runOutputLimit = 1000
+)
+
+var (
+	// schedulerTickInterval is the interval between two checks of the due jobs
+	schedulerTickInterval = 15 * time.Second
+
+	// missedRunTolerance is the delay after which a scheduled time is considered missed rather than late, it covers
+	// the tick interval
+	missedRunTolerance = time.Minute
+
+	// inheritedOptions are the scheduler options passed to every job when they are specified, besides the
+	// configuration file
+	inheritedOptions = []string{"environment", "ls-dbname", "pf-dbname", "db-ip", "db-port", "sslmode",
+		"sslrootcert", "sslcert", "sslkey"}
+)
+
+// scheduledJobRun is a job with its parsed cron expression
+type scheduledJobRun struct {
+	job      *ScheduledJob
+	schedule cron.Schedule
+}
+
+// runRecord is a line of the run history
+type runRecord struct {
+	Job       string    `json:"job"`
+	Scheduled time.Time `json:"scheduled"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Status    string    `json:"status"`
+	Format    string    `json:"format,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// scheduler executes the jobs on their cron expressions. The last scheduled time of every job is kept in the state
+// file, so that runs missed while the scheduler was stopped are caught up once when it is started again
+type scheduler struct {
+	config  *ScheduleConfig
+	jobs    []*scheduledJobRun
+	catchUp time.Duration
+	states  map[string]time.Time
+	running map[string]bool
+	lock    sync.Mutex
+	wait    sync.WaitGroup
+
+	// inherited are the arguments of the scheduler options passed to every job (e.g. config-file, environment), so
+	// that the jobs run against the same configuration as the scheduler
+	inherited []string
+
+	// execute runs the arguments of a job, and returns its output and exit code. It runs the emmstats executable by
+	// default, so that a failing job cannot stop the scheduler
+	execute func(arguments []string) (string, int, error)
+}
+
+// runScheduler runs the scheduled jobs until the scheduler is interrupted
+func runScheduler(context *cli.Context) error {
+
+	if emmConfig == nil || emmConfig.Schedule == nil || len(emmConfig.Schedule.Jobs) == 0 {
+		return cli.Exit("No scheduled jobs are defined in EMM configuration", errorExitCode)
+	}
+
+	s, err := newScheduler(emmConfig.Schedule)
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	s.inherited = inheritedArguments(context)
+
+	release, err := acquireSchedulerLock(s.lockFile())
+
+	if err != nil {
+		return cli.Exit(err.Error(), errorExitCode)
+	}
+
+	defer release()
+
+	logger.WithFields(logrus.Fields{
+		"jobs": len(s.jobs),
+	}).Info("Scheduler started")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(schedulerTickInterval)
+	defer ticker.Stop()
+
+	s.tick(time.Now())
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-signals:
+			logger.Info("Scheduler stopping, waiting for the running jobs")
+			s.wait.Wait()
+
+			return nil
+		}
+	}
+}
+
+// newScheduler parses the cron expressions of the jobs, and loads the last scheduled times
+func newScheduler(config *ScheduleConfig) (*scheduler, error) {
+	s := &scheduler{
+		config:  config,
+		running: map[string]bool{},
+		execute: executeEmmstats,
+	}
+
+	catchUp := config.CatchUp
+
+	if len(catchUp) == 0 {
+		catchUp = defaultScheduleCatchUpAge
+	}
+
+	var err error
+
+	if s.catchUp, err = time.ParseDuration(catchUp); err != nil {
+		return nil, fmt.Errorf("invalid catch-up %s", catchUp)
+	}
+
+	names := map[string]bool{}
+
+	for _, job := range config.Jobs {
+		if len(job.Name) == 0 || names[job.Name] {
+			return nil, fmt.Errorf("scheduled jobs must have unique names, invalid name '%s'", job.Name)
+		}
+
+		if len(job.Command) == 0 {
+			return nil, fmt.Errorf("%s job: command is missing", job.Name)
+		}
+
+		schedule, err := cron.ParseStandard(job.Cron)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s job: invalid cron expression %s: %s", job.Name, job.Cron, err)
+		}
+
+		names[job.Name] = true
+		s.jobs = append(s.jobs, &scheduledJobRun{job: job, schedule: schedule})
+	}
+
+	if s.states, err = loadScheduleStates(s.stateFile()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// tick starts the jobs due at the time. A job is due when its next scheduled time since its last scheduled time is
+// not after the time. Several missed scheduled times are caught up by a single run of the latest one, if it is not
+// older than the catch-up age. Jobs still running from a previous scheduled time are skipped
+func (s *scheduler) tick(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, jobRun := range s.jobs {
+		name := jobRun.job.Name
+		last, found := s.states[name]
+
+		// Jobs seen for the first time are scheduled from now on
+		if !found {
+			s.states[name] = now
+			continue
+		}
+
+		scheduled := jobRun.schedule.Next(last)
+
+		if scheduled.After(now) {
+			continue
+		}
+
+		for next := jobRun.schedule.Next(scheduled); !next.After(now); next = jobRun.schedule.Next(next) {
+			scheduled = next
+		}
+
+		s.states[name] = scheduled
+
+		switch missed := now.Sub(scheduled) > missedRunTolerance; {
+		case s.running[name]:
+			s.recordRun(runRecord{Job: name, Scheduled: scheduled, Started: now, Finished: now, Status: runSkipped,
+				Message: "previous run is still running"})
+		case missed && (s.catchUp <= 0 || now.Sub(scheduled) > s.catchUp):
+			s.recordRun(runRecord{Job: name, Scheduled: scheduled, Started: now, Finished: now, Status: runSkipped,
+				Message: "missed run is older than the catch-up age"})
+		default:
+			if missed {
+				logger.WithFields(logrus.Fields{
+					"job":       name,
+					"scheduled": scheduled,
+				}).Info("Catching up missed run")
+			}
+
+			s.running[name] = true
+			s.wait.Add(1)
+
+			go s.run(jobRun.job, scheduled)
+		}
+	}
+
+	if err := saveScheduleStates(s.stateFile(), s.states); err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Saving schedule state")
+	}
+}
+
+// run executes the job once per output format, and logs every execution in the run history
+func (s *scheduler) run(job *ScheduledJob, scheduled time.Time) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.running, job.Name)
+		s.lock.Unlock()
+		s.wait.Done()
+	}()
+
+	formats := job.Formats
+
+	if len(formats) == 0 {
+		formats = []string{""}
+	}
+
+	for _, format := range formats {
+		record := runRecord{Job: job.Name, Scheduled: scheduled, Started: time.Now(), Format: format}
+		output, exitCode, err := s.execute(jobArguments(job, format, scheduled, s.inherited))
+
+		record.Finished = time.Now()
+		record.ExitCode = exitCode
+		record.Status = runSucceeded
+		record.Message = tail(strings.TrimSpace(output), runOutputLimit)
+
+		if err != nil || exitCode != 0 {
+			record.Status = runFailed
+
+			if err != nil {
+				record.Message = strings.TrimSpace(fmt.Sprintf("%s %s", err, record.Message))
+			}
+		}
+
+		s.lock.Lock()
+		s.recordRun(record)
+		s.lock.Unlock()
+	}
+}
+
+// recordRun appends the record to the run history file, the caller must hold the scheduler lock
+func (s *scheduler) recordRun(record runRecord) {
+	logger.WithFields(logrus.Fields{
+		"job":       record.Job,
+		"scheduled": record.Scheduled,
+		"status":    record.Status,
+		"exit_code": record.ExitCode,
+	}).Info("Scheduled job run")
+
+	line, _ := json.Marshal(record)
+	file, err := os.OpenFile(s.historyFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		file.Close()
+	}
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Writing run history")
+	}
+}
+
+// lockFile, stateFile and historyFile return the configured files of the scheduler, or their defaults
+func (s *scheduler) lockFile() string {
+	return valueOrDefault(s.config.LockFile, defaultSchedulerLockFile)
+}
+
+func (s *scheduler) stateFile() string {
+	return valueOrDefault(s.config.StateFile, defaultScheduleStateFile)
+}
+
+func (s *scheduler) historyFile() string {
+	return valueOrDefault(s.config.HistoryFile, defaultRunHistoryFile)
+}
+
+// inheritedArguments returns the arguments of the scheduler options which are passed to every job: the configuration
+// file (always passed, as jobs may not run in the directory of the default file), the environment and the adhoc
+// database options if specified
+func inheritedArguments(context *cli.Context) []string {
+	arguments := []string{"--config-file", configFileName(context)}
+
+	for _, name := range inheritedOptions {
+		if context.IsSet(name) {
+			arguments = append(arguments, "--"+name, context.String(name))
+		}
+	}
+
+	return arguments
+}
+
+// jobArguments returns the emmstats arguments of the job for the output format, followed by the inherited arguments of
+// the scheduler. Relative ranges are resolved from the scheduled time, so that caught up runs report the same period
+// as if they were not missed
+func jobArguments(job *ScheduledJob, format string, scheduled time.Time, inherited []string) []string {
+	arguments := []string{"--reference-time", scheduled.Format(time.RFC3339)}
+	options := append(job.ReportOptions.globalOptions(), optionValue{"format", format})
+
+	for _, option := range options {
+		if len(option.value) > 0 {
+			arguments = append(arguments, "--"+option.name, option.value)
+		}
+	}
+
+	arguments = append(arguments, inherited...)
+
+	arguments = append(arguments, job.Command)
+
+	return append(arguments, job.Args...)
+}
+
+// executeEmmstats runs the current executable with the arguments, and returns its combined output and exit code
+func executeEmmstats(arguments []string) (string, int, error) {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return "", -1, err
+	}
+
+	var output bytes.Buffer
+
+	command := exec.Command(executable, arguments...)
+	command.Stdout = &output
+	command.Stderr = &output
+
+	err = command.Run()
+
+	if exitError, isExitError := err.(*exec.ExitError); isExitError {
+		return output.String(), exitError.ExitCode(), nil
+	}
+
+	if err != nil {
+		return output.String(), -1, err
+	}
+
+	return output.String(), 0, nil
+}
+
+// acquireSchedulerLock creates the lock file containing the process id, and returns the function releasing it. Lock
+// files left by schedulers which are no longer running are taken over
+func acquireSchedulerLock(lockFile string) (func(), error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+		if err == nil {
+			fmt.Fprintf(file, "%d", os.Getpid())
+			file.Close()
+
+			return func() { os.Remove(lockFile) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("cannot create scheduler lock file %s: %s", lockFile, err)
+		}
+
+		content, _ := ioutil.ReadFile(lockFile)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+
+		if pid > 0 && isProcessRunning(pid) {
+			return nil, fmt.Errorf("scheduler is already running with process id %d (lock file %s)", pid, lockFile)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"lock_file": lockFile,
+			"pid":       pid,
+		}).Warn("Removing stale scheduler lock file")
+
+		os.Remove(lockFile)
+	}
+
+	return nil, fmt.Errorf("cannot acquire scheduler lock file %s", lockFile)
+}
+
+// isProcessRunning returns true if a process with the id is running
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+
+	if err != nil {
+		return false
+	}
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// loadScheduleStates reads the last scheduled time of every job, a missing state file means no job was scheduled yet
+func loadScheduleStates(stateFile string) (map[string]time.Time, error) {
+	states := map[string]time.Time{}
+	content, err := ioutil.ReadFile(stateFile)
+
+	if os.IsNotExist(err) {
+		return states, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read schedule state file %s: %s", stateFile, err)
+	}
+
+	if err = json.Unmarshal(content, &states); err != nil {
+		return nil, fmt.Errorf("cannot parse schedule state file %s: %s", stateFile, err)
+	}
+
+	return states, nil
+}
+
+// saveScheduleStates writes the last scheduled time of every job
+func saveScheduleStates(stateFile string, states map[string]time.Time) error {
+	content, err := json.MarshalIndent(states, "", "  ")
+
+	if err == nil {
+		err = ioutil.WriteFile(stateFile, content, 0644)
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot write schedule state file %s: %s", stateFile, err)
+	}
+
+	return nil
+}
+
+// valueOrDefault returns the value if it is not empty, otherwise the default value
+func valueOrDefault(value string, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// tail returns the last characters of the text, up to the limit
+func tail(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+
+	return text[len(text)-limit:]
+}
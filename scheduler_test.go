@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/urfave/cli.v2"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestScheduler creates a scheduler with its files in a temporary directory, and an execute function recording the
+// executed arguments
+func newTestScheduler(t *testing.T, job *ScheduledJob, catchUp string) (*scheduler, *[][]string, string) {
+	directory, _ := ioutil.TempDir("", "emmstats")
+
+	config := &ScheduleConfig{
+		StateFile:   filepath.Join(directory, "state.json"),
+		HistoryFile: filepath.Join(directory, "runs.log"),
+		CatchUp:     catchUp,
+		Jobs:        []*ScheduledJob{job},
+	}
+
+	s, err := newScheduler(config)
+
+	if err != nil {
+		t.Fatalf("Expecting scheduler to be created, but got %s", err)
+	}
+
+	var executed [][]string
+	var lock sync.Mutex
+
+	s.execute = func(arguments []string) (string, int, error) {
+		lock.Lock()
+		executed = append(executed, arguments)
+		lock.Unlock()
+
+		return "done", 0, nil
+	}
+
+	return s, &executed, directory
+}
+
+// readRunHistory returns the records of the run history
+func readRunHistory(s *scheduler) []runRecord {
+	var records []runRecord
+
+	file, err := os.Open(s.historyFile())
+
+	if err != nil {
+		return records
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var record runRecord
+		json.Unmarshal(scanner.Bytes(), &record)
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestSchedulerTick(t *testing.T) {
+	job := &ScheduledJob{Name: "daily", Cron: "0 6 * * *", Formats: []string{"html", "csv"},
+		ReportOptions: ReportOptions{Command: "throughput", Stream: stringList{"UAT_Test"}, Range: "yesterday"}}
+
+	s, executed, directory := newTestScheduler(t, job, "")
+	defer os.RemoveAll(directory)
+
+	s.inherited = []string{"--config-file", "/etc/emm.yaml", "--environment", "prod"}
+	start := time.Date(2019, 3, 25, 5, 0, 0, 0, time.UTC)
+
+	// First tick only records the job, then the job runs at 06:00 for each format
+	s.tick(start)
+	s.tick(start.Add(30 * time.Minute))
+	s.tick(start.Add(time.Hour + 10*time.Second))
+	s.wait.Wait()
+
+	if len(*executed) != 2 {
+		t.Fatalf("Expecting two executions, but got %v", *executed)
+	}
+
+	expected := []string{"--reference-time", "2019-03-25T06:00:00Z", "--stream", "UAT_Test", "--range", "yesterday",
+		"--format", "html", "--config-file", "/etc/emm.yaml", "--environment", "prod", "throughput"}
+
+	if !reflect.DeepEqual((*executed)[0], expected) {
+		t.Errorf("Expecting arguments %v, but got %v", expected, (*executed)[0])
+	}
+
+	records := readRunHistory(s)
+
+	if len(records) != 2 || records[0].Status != runSucceeded || records[1].Format != "csv" {
+		t.Errorf("Expecting two successful runs in history, but got %+v", records)
+	}
+
+	// The state survives restarts, so that the runs missed while stopped are caught up once
+	restarted, _ := newScheduler(s.config)
+	restarted.execute = s.execute
+
+	restarted.tick(start.Add(72 * time.Hour))
+	restarted.wait.Wait()
+
+	if len(*executed) != 4 || (*executed)[2][1] != "2019-03-27T06:00:00Z" {
+		t.Errorf("Expecting catch-up of the latest missed run, but got %v", *executed)
+	}
+}
+
+func TestInheritedArguments(t *testing.T) {
+	set := flag.NewFlagSet("emmstats", flag.ContinueOnError)
+	set.String("config-file", defaultEMMConfigFile, "")
+	set.String("environment", "", "")
+	set.String("db-ip", "", "")
+	set.Parse([]string{"--config-file", "/etc/emm.yaml", "--db-ip", "10.0.0.1"})
+
+	expected := []string{"--config-file", "/etc/emm.yaml", "--db-ip", "10.0.0.1"}
+
+	if arguments := inheritedArguments(cli.NewContext(&cli.App{}, set, nil)); !reflect.DeepEqual(arguments, expected) {
+		t.Errorf("Expecting arguments %v, but got %v", expected, arguments)
+	}
+}
+
+func TestSchedulerCatchUpAge(t *testing.T) {
+	job := &ScheduledJob{Name: "hourly", Cron: "0 * * * *",
+		ReportOptions: ReportOptions{Command: "check", Stream: stringList{"UAT_Test"}}}
+
+	s, executed, directory := newTestScheduler(t, job, "30m")
+	defer os.RemoveAll(directory)
+
+	start := time.Date(2019, 3, 25, 5, 30, 0, 0, time.UTC)
+
+	s.tick(start)
+	s.tick(start.Add(75 * time.Minute))
+	s.wait.Wait()
+
+	records := readRunHistory(s)
+
+	if len(*executed) != 0 || len(records) != 1 || records[0].Status != runSkipped {
+		t.Errorf("Expecting missed run older than catch-up age to be skipped, but got %v and %+v", *executed, records)
+	}
+}
+
+func TestSchedulerOverlap(t *testing.T) {
+	job := &ScheduledJob{Name: "minutely", Cron: "* * * * *",
+		ReportOptions: ReportOptions{Command: "check", Stream: stringList{"UAT_Test"}}}
+
+	s, _, directory := newTestScheduler(t, job, "")
+	defer os.RemoveAll(directory)
+
+	release := make(chan bool)
+
+	s.execute = func(arguments []string) (string, int, error) {
+		<-release
+		return "", 2, nil
+	}
+
+	start := time.Date(2019, 3, 25, 5, 0, 0, 0, time.UTC)
+
+	s.tick(start)
+	s.tick(start.Add(time.Minute))
+	s.tick(start.Add(2 * time.Minute))
+
+	close(release)
+	s.wait.Wait()
+
+	records := readRunHistory(s)
+
+	if len(records) != 2 || records[0].Status != runSkipped || records[1].Status != runFailed ||
+		records[1].ExitCode != 2 {
+		t.Errorf("Expecting overlapping run skipped and failed run, but got %+v", records)
+	}
+}
+
+func TestNewSchedulerValidation(t *testing.T) {
+	throughputOptions := ReportOptions{Command: "throughput"}
+	invalid := []*ScheduleConfig{
+		{Jobs: []*ScheduledJob{{Name: "a", Cron: "every day", ReportOptions: throughputOptions}}},
+		{Jobs: []*ScheduledJob{{Name: "a", Cron: "0 6 * * *"}}},
+		{Jobs: []*ScheduledJob{{Name: "a", Cron: "0 6 * * *", ReportOptions: throughputOptions},
+			{Name: "a", Cron: "0 7 * * *", ReportOptions: throughputOptions}}},
+		{CatchUp: "a day", Jobs: []*ScheduledJob{{Name: "a", Cron: "0 6 * * *", ReportOptions: throughputOptions}}},
+	}
+
+	for i, config := range invalid {
+		config.StateFile = filepath.Join(os.TempDir(), "emmstats-missing-state.json")
+
+		if _, err := newScheduler(config); err == nil {
+			t.Errorf("Expecting error for invalid schedule %d, but got nil", i)
+		}
+	}
+}
+
+func TestAcquireSchedulerLock(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(directory)
+
+	lockFile := filepath.Join(directory, "scheduler.lock")
+	release, err := acquireSchedulerLock(lockFile)
+
+	if err != nil {
+		t.Fatalf("Expecting lock to be acquired, but got %s", err)
+	}
+
+	if _, err = acquireSchedulerLock(lockFile); err == nil {
+		t.Errorf("Expecting error acquiring lock held by running process, but got nil")
+	}
+
+	release()
+
+	// Lock files of processes which are not running are stale
+	ioutil.WriteFile(lockFile, []byte(fmt.Sprintf("%d", 1<<22+12345)), 0644)
+
+	if release, err = acquireSchedulerLock(lockFile); err != nil {
+		t.Errorf("Expecting stale lock to be taken over, but got %s", err)
+	} else {
+		release()
+	}
+}
+
+func TestJobArguments_SampleConfiguration(t *testing.T) {
+	var config Config
+
+	content, err := ioutil.ReadFile("emm-config.yaml")
+
+	if err == nil {
+		err = yaml.Unmarshal(content, &config)
+	}
+
+	if err != nil || config.Schedule == nil {
+		t.Fatalf("Expecting sample schedule to be parsed, but got %v", err)
+	}
+
+	// Commands do not run, only the arguments are parsed by the application
+	app := CreateCliApp()
+	app.Before = nil
+	app.Writer = ioutil.Discard
+	app.ErrWriter = ioutil.Discard
+
+	var commands []*cli.Command
+
+	for _, command := range app.Commands {
+		parsed := *command
+		parsed.Before = nil
+		parsed.Action = func(context *cli.Context) error { return nil }
+		commands = append(commands, &parsed)
+	}
+
+	app.Commands = commands
+
+	for _, job := range config.Schedule.Jobs {
+		for _, format := range append(job.Formats, "") {
+			arguments := jobArguments(job, format, time.Now(), []string{"--config-file", "emm-config.yaml"})
+
+			if err := app.Run(append([]string{app.Name}, arguments...)); err != nil {
+				t.Errorf("Expecting %s job arguments %v to be parsed, but got %s", job.Name, arguments, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+	"gopkg.in/urfave/cli.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// encryptedSecretPrefix prefixes the secrets encrypted by the config encrypt command (e.g. enc:3q2+7w==)
+	encryptedSecretPrefix = "enc:"
+
+	// secretKeyEnvVar is the environment variable containing the passphrase of the encrypted secrets
+	secretKeyEnvVar = "EMMSTATS_SECRET_KEY"
+
+	// pgpassFileEnvVar is the environment variable overriding the location of the PostgreSQL password file
+	pgpassFileEnvVar = "PGPASSFILE"
+
+	// secretSaltSize is the size of the random salt of each encrypted secret, and secretKeyCost is the scrypt CPU and
+	// memory cost deriving the key from the passphrase, so that leaked secrets are expensive to brute-force
+	secretSaltSize = 16
+	secretKeyCost  = 1 << 15
+)
+
+// environmentVariableRegex matches the ${ENV_VAR} references interpolated in the secrets, the $ENV_VAR syntax is not
+// supported as passwords may contain dollar signs
+var environmentVariableRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// secretsMask masks the resolved secrets in every log entry
+var secretsMask = &secretsMaskHook{}
+
+// secretsMaskHook is a logrus hook replacing the registered secrets by the masked secret in the messages and the
+// fields of the log entries
+type secretsMaskHook struct {
+	sync.RWMutex
+	secrets []string
+}
+
+// resolveSecrets interpolates the environment variables in the usernames and the passwords of the clusters, the
+// logical servers and the notifiers, decrypts the encrypted passwords, and reads the passwords files. Resolved
+// passwords are masked in the logs
+func (c *Config) resolveSecrets() error {
+	for _, cluster := range c.Clusters {
+		if err := resolveCredentials(&cluster.Username, &cluster.Password, cluster.PasswordFile); err != nil {
+			return fmt.Errorf("cluster %s: %s", cluster.Name, err)
+		}
+
+		for _, logicalServer := range cluster.LogicalServers {
+			if err := resolveCredentials(&logicalServer.Username, &logicalServer.Password,
+				logicalServer.PasswordFile); err != nil {
+				return fmt.Errorf("logical server %s (%s): %s", logicalServer.Name, cluster.Name, err)
+			}
+		}
+	}
+
+	if c.Notifications != nil {
+		for _, notifier := range c.Notifications.Notifiers {
+			if err := resolveCredentials(&notifier.Username, &notifier.Password, ""); err != nil {
+				return fmt.Errorf("notifier %s: %s", notifier.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolvePgpass looks up the passwords of the logical servers which are specified neither by the logical servers nor
+// by their clusters in the PostgreSQL password file (~/.pgpass, or the file of PGPASSFILE environment variable)
+func (c *Config) resolvePgpass() error {
+	fileName := os.Getenv(pgpassFileEnvVar)
+
+	if len(fileName) == 0 {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return nil
+		}
+
+		fileName = filepath.Join(home, ".pgpass")
+	}
+
+	for _, cluster := range c.Clusters {
+		for _, logicalServer := range cluster.LogicalServers {
+			if len(logicalServer.Password) > 0 {
+				continue
+			}
+
+			password, err := lookupPgpass(fileName, logicalServer)
+
+			if err != nil {
+				return err
+			}
+
+			if len(password) > 0 {
+				logger.WithFields(logrus.Fields{
+					"logical_server": logicalServer.Name,
+					"cluster":        cluster.Name,
+					"file":           fileName,
+				}).Debug("Password found in PostgreSQL password file")
+
+				logicalServer.Password = password
+				secretsMask.register(password)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveCredentials resolves the username and the password of a cluster, a logical server or a notifier. The
+// password and the password file are exclusive
+func resolveCredentials(username *string, password *string, passwordFile string) error {
+	var err error
+
+	if *username, err = interpolateEnvironment(*username); err != nil {
+		return err
+	}
+
+	if len(passwordFile) > 0 {
+		if len(*password) > 0 {
+			return fmt.Errorf("password and password-file cannot be specified at the same time")
+		}
+
+		if *password, err = readPasswordFile(passwordFile); err != nil {
+			return err
+		}
+	} else if *password, err = resolveSecret(*password); err != nil {
+		return err
+	}
+
+	secretsMask.register(*password)
+
+	return nil
+}
+
+// resolveSecret interpolates the environment variables of the secret, and decrypts it if it is encrypted
+func resolveSecret(secret string) (string, error) {
+	secret, err := interpolateEnvironment(secret)
+
+	if err != nil || !strings.HasPrefix(secret, encryptedSecretPrefix) {
+		return secret, err
+	}
+
+	return decryptSecret(secret, os.Getenv(secretKeyEnvVar))
+}
+
+// interpolateEnvironment replaces the ${ENV_VAR} references by the values of the environment variables, undefined
+// variables are reported as errors
+func interpolateEnvironment(value string) (string, error) {
+	var err error
+
+	interpolated := environmentVariableRegex.ReplaceAllStringFunc(value, func(reference string) string {
+		name := environmentVariableRegex.FindStringSubmatch(reference)[1]
+		variable, found := os.LookupEnv(name)
+
+		if !found && err == nil {
+			err = fmt.Errorf("environment variable %s is not defined", name)
+		}
+
+		return variable
+	})
+
+	return interpolated, err
+}
+
+// readPasswordFile returns the first line of the password file, the file name may reference environment variables and
+// start with the home directory (~/)
+func readPasswordFile(fileName string) (string, error) {
+	fileName, err := interpolateEnvironment(fileName)
+
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(expandHome(fileName))
+
+	if err != nil {
+		return "", fmt.Errorf("cannot read password file: %s", err)
+	}
+
+	return strings.SplitN(strings.TrimRight(string(content), "\r\n"), "\n", 2)[0], nil
+}
+
+// expandHome replaces the home directory prefix (~/) of the file name by the home directory of the user, the file name
+// is kept if the home directory is unknown
+func expandHome(fileName string) string {
+	if !strings.HasPrefix(fileName, "~/") {
+		return fileName
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return fileName
+	}
+
+	return filepath.Join(home, fileName[2:])
+}
+
+// lookupPgpass returns the password of the first entry of the PostgreSQL password file matching the host, port,
+// database and username of the logical server. Fields may be * wildcards, and escape colons and backslashes with a
+// backslash. The database defaults to the username as in PostgreSQL. Missing files return an empty password, and files
+// readable by group or others are ignored as in psql
+func lookupPgpass(fileName string, logicalServer *LogicalServer) (string, error) {
+	info, err := os.Stat(fileName)
+
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("cannot read PostgreSQL password file: %s", err)
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		logger.WithFields(logrus.Fields{
+			"file": fileName,
+		}).Warn("PostgreSQL password file has group or world access, it is ignored")
+
+		return "", nil
+	}
+
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot read PostgreSQL password file: %s", err)
+	}
+
+	defer file.Close()
+
+	database := logicalServer.Database
+
+	if len(database) == 0 {
+		database = logicalServer.Username
+	}
+
+	expected := []string{logicalServer.IP, logicalServer.Port, database, logicalServer.Username}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := splitPgpassLine(line)
+
+		if len(fields) != 5 {
+			continue
+		}
+
+		matches := true
+
+		for i, value := range expected {
+			matches = matches && (fields[i] == "*" || fields[i] == value)
+		}
+
+		if matches {
+			return fields[4], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// splitPgpassLine splits the line of the PostgreSQL password file on the unescaped colons, and unescapes the fields
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':' && len(fields) < 4:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+
+	return append(fields, field.String())
+}
+
+// secretCipher returns the AES-256 GCM cipher of the encrypted secrets, the key is derived from the passphrase and the
+// salt of the secret using scrypt
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("environment variable %s is not defined", secretKeyEnvVar)
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, secretKeyCost, 8, 1, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts the secret with the passphrase, and returns the encrypted value in the configuration format
+// (i.e. enc: followed by the base64 encoding of the salt, the nonce and the sealed secret)
+func encryptSecret(secret string, passphrase string) (string, error) {
+	salt := make([]byte, secretSaltSize)
+
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := secretCipher(passphrase, salt)
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(secret), nil)
+
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts the secret encrypted by encryptSecret with the same passphrase
+func decryptSecret(encrypted string, passphrase string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, encryptedSecretPrefix))
+
+	if err != nil || len(sealed) < secretSaltSize {
+		return "", fmt.Errorf("invalid encrypted secret")
+	}
+
+	gcm, err := secretCipher(passphrase, sealed[:secretSaltSize])
+
+	if err != nil {
+		return "", err
+	}
+
+	sealed = sealed[secretSaltSize:]
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted secret")
+	}
+
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt secret, check %s environment variable", secretKeyEnvVar)
+	}
+
+	return string(secret), nil
+}
+
+// configEncrypt encrypts the secret given as argument, or read from the standard input (without echo on terminals),
+// with the passphrase of EMMSTATS_SECRET_KEY environment variable, and prints the value to set in EMM configuration
+func configEncrypt(context *cli.Context) error {
+	secret := context.Args().First()
+
+	if context.Args().Len() > 1 {
+		return cli.Exit("Only one secret can be encrypted at once", errorExitCode)
+	}
+
+	if len(secret) == 0 {
+		var err error
+
+		if secret, err = readSecret(); err != nil {
+			return cli.Exit(fmt.Sprintf("Cannot read secret: %s", err), errorExitCode)
+		}
+	}
+
+	if len(secret) == 0 {
+		return cli.Exit("Missing secret to encrypt", errorExitCode)
+	}
+
+	encrypted, err := encryptSecret(secret, os.Getenv(secretKeyEnvVar))
+
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Cannot encrypt secret: %s", err), errorExitCode)
+	}
+
+	fmt.Fprintln(context.App.Writer, encrypted)
+
+	return nil
+}
+
+// readSecret reads the secret from the standard input, the terminal does not echo the secret
+func readSecret() (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Secret: ")
+		secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+
+		return string(secret), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// register adds the secret to the masked secrets, with its escaped value in the connection strings
+func (h *secretsMaskHook) register(secret string) {
+	if len(secret) == 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	for _, value := range []string{connectionValueEscaper.Replace(secret), secret} {
+		if indexOf(h.secrets, value) < 0 {
+			h.secrets = append(h.secrets, value)
+		}
+	}
+}
+
+// mask replaces the registered secrets in the value
+func (h *secretsMaskHook) mask(value string) string {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, secret := range h.secrets {
+		value = strings.Replace(value, secret, maskedSecret, -1)
+	}
+
+	return value
+}
+
+// Levels returns all the levels, secrets are masked in every log entry
+func (h *secretsMaskHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire masks the secrets in the message and the string and error fields of the log entry
+func (h *secretsMaskHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.mask(entry.Message)
+
+	for key, value := range entry.Data {
+		switch typed := value.(type) {
+		case string:
+			entry.Data[key] = h.mask(typed)
+		case error:
+			entry.Data[key] = h.mask(typed.Error())
+		case fmt.Stringer:
+			entry.Data[key] = h.mask(typed.String())
+		}
+	}
+
+	return nil
+}
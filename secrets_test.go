@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolateEnvironment(t *testing.T) {
+	os.Setenv("EMMSTATS_TEST_PASSWORD", "mediation")
+	defer os.Unsetenv("EMMSTATS_TEST_PASSWORD")
+
+	tests := []struct {
+		value    string
+		expected string
+		fails    bool
+	}{
+		{"${EMMSTATS_TEST_PASSWORD}", "mediation", false},
+		{"pre-${EMMSTATS_TEST_PASSWORD}-$HOME", "pre-mediation-$HOME", false},
+		{"plain$text", "plain$text", false},
+		{"${EMMSTATS_TEST_UNDEFINED}", "", true},
+	}
+
+	for _, test := range tests {
+		value, err := interpolateEnvironment(test.value)
+
+		if (err != nil) != test.fails || (!test.fails && value != test.expected) {
+			t.Errorf("Expecting %s to interpolate to %s (fails: %v), but got %s (%v)", test.value, test.expected,
+				test.fails, value, err)
+		}
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	encrypted, err := encryptSecret("it's se:cret", "passphrase")
+
+	if err != nil || !strings.HasPrefix(encrypted, encryptedSecretPrefix) {
+		t.Fatalf("Expecting encrypted secret, but got %s (%v)", encrypted, err)
+	}
+
+	if secret, err := decryptSecret(encrypted, "passphrase"); err != nil || secret != "it's se:cret" {
+		t.Errorf("Expecting decrypted secret, but got %s (%v)", secret, err)
+	}
+
+	if _, err := decryptSecret(encrypted, "another"); err == nil {
+		t.Errorf("Expecting error decrypting with another passphrase")
+	}
+
+	// Each secret has its own salt, so that the same secret is never encrypted to the same value
+	if another, _ := encryptSecret("it's se:cret", "passphrase"); another == encrypted {
+		t.Errorf("Expecting salted encrypted secrets to differ, but both are %s", encrypted)
+	}
+
+	if _, err := encryptSecret("secret", ""); err == nil {
+		t.Errorf("Expecting error encrypting without passphrase")
+	}
+}
+
+func TestLookupPgpass(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, ".pgpass")
+	ioutil.WriteFile(fileName, []byte("# comment\n"+
+		"10.135.3.125:5432:fm_db_Server1:mmsuper:first\\:secret\n"+
+		"*:5432:*:mmsuper:wildcard\n"), 0600)
+
+	tests := []struct {
+		server   *LogicalServer
+		expected string
+	}{
+		{&LogicalServer{IP: "10.135.3.125", Port: "5432", Database: "fm_db_Server1", Username: "mmsuper"},
+			"first:secret"},
+		{&LogicalServer{IP: "localhost", Port: "5432", Database: "fm_db_Server11", Username: "mmsuper"}, "wildcard"},
+		{&LogicalServer{IP: "localhost", Port: "5433", Username: "mmsuper"}, ""},
+	}
+
+	for _, test := range tests {
+		if password, err := lookupPgpass(fileName, test.server); err != nil || password != test.expected {
+			t.Errorf("Expecting password %s for %+v, but got %s (%v)", test.expected, test.server, password, err)
+		}
+	}
+
+	os.Chmod(fileName, 0644)
+
+	if password, _ := lookupPgpass(fileName, tests[0].server); len(password) > 0 {
+		t.Errorf("Expecting world readable password file to be ignored, but got %s", password)
+	}
+}
+
+func TestConfig_ResolveSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	os.Setenv("EMMSTATS_TEST_DIR", dir)
+	os.Setenv(secretKeyEnvVar, "passphrase")
+	defer os.Unsetenv("EMMSTATS_TEST_DIR")
+	defer os.Unsetenv(secretKeyEnvVar)
+
+	ioutil.WriteFile(filepath.Join(dir, "ryd2.password"), []byte("from-file\n"), 0600)
+	encrypted, _ := encryptSecret("encrypted", "passphrase")
+
+	config := &Config{Clusters: []*Cluster{{
+		Name:         "ryd2",
+		Username:     "${EMMSTATS_TEST_DIR}",
+		PasswordFile: "${EMMSTATS_TEST_DIR}/ryd2.password",
+		LogicalServers: []*LogicalServer{
+			{Name: "Server1"},
+			{Name: "Server2", Password: encrypted},
+		},
+	}}}
+
+	if err := config.resolveSecrets(); err != nil {
+		t.Fatalf("Expecting secrets to be resolved, but got %v", err)
+	}
+
+	config.resolveDefaults()
+
+	cluster := config.Clusters[0]
+
+	if cluster.Username != dir || cluster.LogicalServers[0].Password != "from-file" ||
+		cluster.LogicalServers[1].Password != "encrypted" {
+		t.Errorf("Expecting resolved credentials, but got %+v %+v %+v", cluster, cluster.LogicalServers[0],
+			cluster.LogicalServers[1])
+	}
+
+	config.Clusters[0].Password = "both"
+
+	if err := config.resolveSecrets(); err == nil {
+		t.Errorf("Expecting error specifying both password and password-file")
+	}
+}
+
+func TestParseEMMConfig_UnresolvedSecret(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "emm-config.yaml")
+	ioutil.WriteFile(fileName, []byte("clusters:\n  - name: ryd2\n    password: mediation\n"+
+		"    password-file: ryd2.password\n"), 0600)
+
+	config, err := parseEMMConfig(fileName, "")
+
+	if config != nil || err == nil || !strings.Contains(err.Error(), "cluster ryd2") {
+		t.Errorf("Expecting an error naming cluster ryd2, but got %v", err)
+	}
+}
+
+func TestSecretsMaskHook(t *testing.T) {
+	hook := &secretsMaskHook{}
+	hook.register("mediation")
+	hook.register("")
+	hook.register("it's")
+
+	entry := &logrus.Entry{Message: "user=mmsuper password=mediation", Data: logrus.Fields{
+		"connection": "password='mediation'",
+		"error":      fmt.Errorf("authentication failed for mediation"),
+		"rows":       3,
+		"quoted":     connectionString(&LogicalServer{Password: "it's"}),
+	}}
+
+	hook.Fire(entry)
+
+	if entry.Message != "user=mmsuper password="+maskedSecret ||
+		entry.Data["connection"] != "password='"+maskedSecret+"'" ||
+		entry.Data["error"] != "authentication failed for "+maskedSecret || entry.Data["rows"] != 3 ||
+		entry.Data["quoted"] != "password='"+maskedSecret+"' sslmode='disable'" {
+		t.Errorf("Expecting masked secrets, but got %s %v", entry.Message, entry.Data)
+	}
+}
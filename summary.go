@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/montanaflynn/stats"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultSummaryStatistics are the statistics displayed in the summary table if not specified in the options
+	defaultSummaryStatistics = "avg,min,max"
+
+	// statisticColumn is the name of the first column of the summary table, it contains the statistic names
+	statisticColumn = "statistic"
+)
+
+// percentileExpression matches percentile statistic names (e.g. p50, p95, p99.9)
+var percentileExpression = regexp.MustCompile(`^p(\d+(\.\d+)?)$`)
+
+// summaryStatistic is a statistic computed for every numeric column of the report. It is computed using the values
+// of the column, and the time buckets of the values (used by statistics reporting the time of a value)
+type summaryStatistic struct {
+	name    string
+	compute func(values []float64, times []string) (string, error)
+
+	// timeValued is true for statistics producing time buckets instead of numbers
+	timeValued bool
+}
+
+// summaryStatistics contains all the supported statistics, except percentiles which are created on demand
+var summaryStatistics = map[string]summaryStatistic{
+	"avg":      {name: "avg", compute: numericStatistic(stats.Mean)},
+	"min":      {name: "min", compute: numericStatistic(stats.Min)},
+	"max":      {name: "max", compute: numericStatistic(stats.Max)},
+	"sum":      {name: "sum", compute: numericStatistic(stats.Sum)},
+	"stddev":   {name: "stddev", compute: numericStatistic(stats.StandardDeviation)},
+	"min_time": {name: "min_time", compute: timeOfStatistic(stats.Min), timeValued: true},
+	"max_time": {name: "max_time", compute: timeOfStatistic(stats.Max), timeValued: true},
+}
+
+// parseSummaryStatistics parses a comma separated list of statistic names (e.g. avg,p95,max,sum)
+func parseSummaryStatistics(names string) ([]summaryStatistic, error) {
+	var statistics []summaryStatistic
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if statistic, found := summaryStatistics[name]; found {
+			statistics = append(statistics, statistic)
+		} else if match := percentileExpression.FindStringSubmatch(name); match != nil {
+			percent, _ := strconv.ParseFloat(match[1], 64)
+
+			if percent <= 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid percentile %s", name)
+			}
+
+			statistics = append(statistics, summaryStatistic{name: name, compute: percentileStatistic(percent)})
+		} else {
+			return nil, fmt.Errorf("invalid statistic %s", name)
+		}
+	}
+
+	return statistics, nil
+}
+
+// numericStatistic creates the compute function of statistics producing a single number
+func numericStatistic(function func(stats.Float64Data) (float64, error)) func([]float64, []string) (string, error) {
+	return func(values []float64, times []string) (string, error) {
+		value, err := function(values)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	}
+}
+
+// percentileStatistic creates the compute function of a percentile, using the nearest rank method so that the
+// percentile is always one of the values
+func percentileStatistic(percent float64) func([]float64, []string) (string, error) {
+	return numericStatistic(func(values stats.Float64Data) (float64, error) {
+		return stats.PercentileNearestRank(values, percent)
+	})
+}
+
+// timeOfStatistic creates the compute function of statistics producing the time bucket of the first value equal to
+// the statistic (e.g. time of the max value)
+func timeOfStatistic(function func(stats.Float64Data) (float64, error)) func([]float64, []string) (string, error) {
+	return func(values []float64, times []string) (string, error) {
+		value, err := function(values)
+
+		if err != nil {
+			return "", err
+		}
+
+		for i := range values {
+			if values[i] == value && i < len(times) {
+				return times[i], nil
+			}
+		}
+
+		return "", nil
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// streamColumn and logicalServerColumn are the names of the columns which contain the stream name, and the logical
+	// server name in the combined throughput reports
+	streamColumn        = "stream"
+	logicalServerColumn = "logical_server"
+
+	// subtotalRow and totalRow label the subtotal row of each stream, and the total row of the combined reports
+	subtotalRow = "subtotal"
+	totalRow    = "total"
+)
+
+// throughputTarget is a stream, or a complete logical server, which throughput reports are generated for. Streams
+// running in several logical servers have a server per logical server, the logical server and the cluster of the
+// target are the first ones
+type throughputTarget struct {
+	name          string
+	stream        *Stream
+	logicalServer *LogicalServer
+	cluster       *Cluster
+	servers       []*targetServer
+}
+
+// matchedNodesColumns are the columns of the matched nodes table, listing the actual nodes matched by the streams
+var matchedNodesColumns = []string{"stream", "logical_server", "node_type", "node_name", "node_id", "files"}
+
+// targetServer is a logical server, and its cluster, queried for the throughput of a target
+type targetServer struct {
+	logicalServer *LogicalServer
+	cluster       *Cluster
+}
+
+// label returns the name of the logical server, and the name of its cluster
+func (s *targetServer) label() string {
+	if s.cluster == nil {
+		return s.logicalServer.Name
+	}
+
+	return fmt.Sprintf("%s (%s)", s.logicalServer.Name, s.cluster.Name)
+}
+
+// resolveThroughputTarget finds the stream, or the logical server specified in the options in EMM configuration
+func resolveThroughputTarget(context *cli.Context) (*throughputTarget, error) {
+
+	// Logical server name, and cluster are required to generate throughput for specific logical server
+	logicalServerArg := context.String("lserver")
+	clusterArg := context.String("cluster")
+
+	// Stream name is required to generate throughput for specific stream
+	streamArgs := context.StringSlice("stream")
+
+	if emmConfig == nil {
+		return nil, fmt.Errorf("EMM configuration file is not loaded")
+	}
+
+	if len(streamArgs) > 0 {
+		streams, err := emmConfig.FindStreams(streamArgs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(streams) > 1 {
+			return nil, fmt.Errorf("%s matches %d streams, a single stream is required",
+				strings.Join(streamArgs, ", "), len(streams))
+		}
+
+		return newStreamTarget(streams[0])
+
+	} else if len(logicalServerArg) > 0 && len(clusterArg) > 0 {
+
+		logicalServer := emmConfig.FindLogicalServer(logicalServerArg, clusterArg)
+
+		if logicalServer == nil {
+			return nil, fmt.Errorf("%s logical server is not defined in %s cluster", logicalServerArg, clusterArg)
+		}
+
+		return newLogicalServerTarget(logicalServer, emmConfig.FindCluster(clusterArg)), nil
+	}
+
+	return nil, fmt.Errorf("Invalid command options, either specify a stream, or logical server and cluster")
+}
+
+// resolveThroughputTargets returns the streams matching the stream options, or the logical server specified in the
+// options. If none of them is specified, all the streams defined in EMM configuration are returned
+func resolveThroughputTargets(context *cli.Context) ([]*throughputTarget, error) {
+	var targets []*throughputTarget
+
+	if len(context.String("lserver")) > 0 {
+		target, err := resolveThroughputTarget(context)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append(targets, target), nil
+	}
+
+	if emmConfig == nil {
+		return nil, fmt.Errorf("EMM configuration file is not loaded")
+	}
+
+	streams := emmConfig.Streams
+
+	if streamArgs := context.StringSlice("stream"); len(streamArgs) > 0 {
+		var err error
+
+		if streams, err = emmConfig.FindStreams(streamArgs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, stream := range streams {
+		target, err := newStreamTarget(stream)
+
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// period returns the report period with the database time zone of the logical server. Buckets are in the report time
+// zone, but each logical server stores the timestamps in the time zone of its cluster
+func (s *targetServer) period(period *reportPeriod) *reportPeriod {
+	serverPeriod := *period
+
+	if s.cluster != nil {
+		serverPeriod.dbTimeZone = s.cluster.TimeZone
+	}
+
+	return &serverPeriod
+}
+
+// newStreamTarget creates the throughput target of the stream, using the logical servers assigned to the stream
+func newStreamTarget(stream *Stream) (*throughputTarget, error) {
+	assigned := stream.AssignedLogicalServers()
+
+	if len(assigned) == 0 {
+		return nil, fmt.Errorf("%s stream is not assigned to any logical server", stream.Name)
+	}
+
+	for _, patterns := range [][]string{stream.CollectorPatterns, stream.DistributorPatterns,
+		stream.CollectorExclusions, stream.DistributorExclusions} {
+
+		if err := validateNodePatterns(patterns); err != nil {
+			return nil, fmt.Errorf("%s stream: %s", stream.Name, err)
+		}
+	}
+
+	target := &throughputTarget{name: fmt.Sprintf("%s Stream", stream.Name), stream: stream}
+
+	for _, server := range assigned {
+		logicalServer := emmConfig.FindLogicalServer(server.Name, server.Cluster)
+
+		if logicalServer == nil {
+			return nil, fmt.Errorf("%s logical server of %s stream is not defined in %s cluster",
+				server.Name, stream.Name, server.Cluster)
+		}
+
+		target.servers = append(target.servers, &targetServer{
+			logicalServer: logicalServer,
+			cluster:       emmConfig.FindCluster(server.Cluster),
+		})
+	}
+
+	target.logicalServer = target.servers[0].logicalServer
+	target.cluster = target.servers[0].cluster
+
+	return target, nil
+}
+
+// newLogicalServerTarget creates the throughput target of the complete logical server
+func newLogicalServerTarget(logicalServer *LogicalServer, cluster *Cluster) *throughputTarget {
+	return &throughputTarget{
+		name:          fmt.Sprintf("%s Logical Server", logicalServer.Name),
+		logicalServer: logicalServer,
+		cluster:       cluster,
+		servers:       []*targetServer{{logicalServer: logicalServer, cluster: cluster}},
+	}
+}
+
+// throughputQuery returns the throughput query of the target, for the period grouped using the time bucket, and the
+// values bound to its placeholders
+func (t *throughputTarget) throughputQuery(period *reportPeriod, bucket timeBucket) (string, []interface{}) {
+	params := period.queryParameters(bucket)
+
+	if t.stream == nil {
+		return parseTemplate("throughput", lsThroughputQueryTemplate, params)
+	}
+
+	t.stream.setNodesParameters(&params)
+
+	return parseTemplate("throughput", streamThroughputQueryTemplate, params)
+}
+
+// setNodesParameters sets the collectors and distributors of the stream in the query parameters
+func (s *Stream) setNodesParameters(params *AudittrailLogEntryQueryParameters) {
+	params.InnodeNames = s.CollectorNames
+	params.InnodeIds = s.CollectorIds
+	params.InnodePatterns = s.CollectorPatterns
+	params.InnodeExclusions = s.CollectorExclusions
+	params.OutnodeNames = s.DistributorNames
+	params.OutnodeIds = s.DistributorIds
+	params.OutnodePatterns = s.DistributorPatterns
+	params.OutnodeExclusions = s.DistributorExclusions
+}
+
+// throughputReport executes the throughput query of the target in the logical server database, and completes the
+// report with the empty time buckets, headers, and rates. Streams running in several logical servers are queried
+// concurrently, and their throughput is aggregated per time bucket. If the by-server option is specified, the report
+// contains a breakdown table with the throughput of each logical server
+func (t *throughputTarget) throughputReport(context *cli.Context, period *reportPeriod,
+	bucket timeBucket) (*Report, error) {
+
+	if len(t.servers) <= 1 {
+		report, err := t.queryServer(t.logicalServer, period, bucket)
+
+		if err != nil {
+			return nil, err
+		}
+
+		completeThroughputReport(context, report, period, bucket)
+
+		return report, nil
+	}
+
+	var wait sync.WaitGroup
+
+	reports := make([]*Report, len(t.servers))
+	periods := make([]*reportPeriod, len(t.servers))
+	errors := make([]error, len(t.servers))
+
+	for i, server := range t.servers {
+		periods[i] = server.period(period)
+		wait.Add(1)
+
+		go func(i int, server *targetServer) {
+			defer wait.Done()
+			reports[i], errors[i] = t.queryServer(server.logicalServer, periods[i], bucket)
+		}(i, server)
+	}
+
+	wait.Wait()
+
+	var labels, failed []string
+	var tables []*ResultSet
+
+	for i, server := range t.servers {
+		labels = append(labels, server.label())
+
+		if errors[i] != nil {
+			logger.WithFields(logrus.Fields{
+				"command":        "throughput",
+				"target":         t.name,
+				"logical_server": server.label(),
+				"error":          errors[i],
+			}).Error("Querying logical server throughput")
+
+			failed = append(failed, server.label())
+			continue
+		}
+
+		tables = append(tables, reports[i].GetDefaultTable())
+	}
+
+	// A partial aggregation would under-report the throughput of the stream
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("Throughput of %s failed in %s", t.name, strings.Join(failed, ", "))
+	}
+
+	report := &Report{name: fmt.Sprintf("%s Throughput", t.name), defaultTable: sumTablesByTime(tables)}
+	completeThroughputReport(context, report, period, bucket)
+	report.AddHeader("Logical Servers", strings.Join(labels, ", "))
+
+	if context.Bool("by-server") {
+		for i := range reports {
+			completeThroughputReport(context, reports[i], periods[i], bucket)
+		}
+
+		report.breakdownTable = combineTables(logicalServerColumn, labels, reports)
+	}
+
+	return report, nil
+}
+
+// queryServer executes the throughput query of the target in the logical server database
+func (t *throughputTarget) queryServer(logicalServer *LogicalServer, period *reportPeriod,
+	bucket timeBucket) (*Report, error) {
+
+	query, args := t.throughputQuery(period, bucket)
+
+	logger.WithFields(logrus.Fields{
+		"command":        "throughput",
+		"target":         t.name,
+		"logical_server": logicalServer.Name,
+		"query":          query,
+		"args":           args,
+	}).Debug("Throughput query")
+
+	session := CreateSession(logicalServer)
+
+	if session == nil {
+		return nil, fmt.Errorf("Cannot open session to %s logical server", logicalServer.Name)
+	}
+
+	report, err := session.executeQuery(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report.name = fmt.Sprintf("%s Throughput", t.name)
+
+	return report, nil
+}
+
+// sumTablesByTime sums the numeric columns of the tables per time bucket. The columns are the columns of the first
+// table, and the rows are sorted by time
+func sumTablesByTime(tables []*ResultSet) *ResultSet {
+	table := &ResultSet{
+		columnsNames:     tables[0].GetColumnsNames(),
+		columnsDataTypes: tables[0].GetColumnsDataTypes(),
+	}
+
+	var times []string
+
+	sums := map[string]map[string]float64{}
+
+	for _, source := range tables {
+		for _, row := range tableRows(source) {
+			bucketSums, found := sums[row[timeColumn]]
+
+			if !found {
+				bucketSums = map[string]float64{}
+				sums[row[timeColumn]] = bucketSums
+				times = append(times, row[timeColumn])
+			}
+
+			for _, columnName := range table.columnsNames {
+				if value, err := strconv.ParseFloat(row[columnName], 64); err == nil && columnName != timeColumn {
+					bucketSums[columnName] += value
+				}
+			}
+		}
+	}
+
+	sort.Strings(times)
+
+	records := [][]string{table.columnsNames}
+
+	for _, bucketTime := range times {
+		var record []string
+
+		for _, columnName := range table.columnsNames {
+			if columnName == timeColumn {
+				record = append(record, bucketTime)
+			} else if isNumericType(table.columnsDataTypes[columnName]) {
+				record = append(record, formatFloat(sums[bucketTime][columnName]))
+			} else {
+				record = append(record, "")
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+
+	return table
+}
+
+// streamsThroughputReport executes the throughput queries of the stream targets concurrently, and combines the reports
+// of the streams into a single report. Streams which fail are logged and listed in the report header, without
+// blocking the other streams
+func streamsThroughputReport(context *cli.Context, targets []*throughputTarget, bucket timeBucket) (*Report, error) {
+	var wait sync.WaitGroup
+
+	reports := make([]*Report, len(targets))
+	errors := make([]error, len(targets))
+
+	for i, target := range targets {
+		wait.Add(1)
+
+		go func(i int, target *throughputTarget) {
+			defer wait.Done()
+
+			period, err := resolveReportPeriod(context, target.cluster)
+
+			if err != nil {
+				errors[i] = err
+				return
+			}
+
+			reports[i], errors[i] = target.throughputReport(context, period, bucket)
+		}(i, target)
+	}
+
+	wait.Wait()
+
+	var names, failed []string
+	var succeeded []*Report
+
+	for i, target := range targets {
+		if errors[i] != nil {
+			logger.WithFields(logrus.Fields{
+				"command": "throughput",
+				"target":  target.name,
+				"error":   errors[i],
+			}).Error("Querying stream throughput")
+
+			failed = append(failed, target.stream.Name)
+			continue
+		}
+
+		names = append(names, target.stream.Name)
+		succeeded = append(succeeded, reports[i])
+	}
+
+	if len(succeeded) == 0 {
+		return nil, fmt.Errorf("Throughput of all the streams failed: %s", strings.Join(failed, ", "))
+	}
+
+	report := &Report{name: "Streams Throughput"}
+
+	// Streams of different clusters may have different time zones, and thus different periods
+	for _, headerName := range []string{"Period", "Time Zone"} {
+		var values []string
+
+		for _, streamReport := range succeeded {
+			for _, header := range streamReport.headers {
+				if header.name == headerName && indexOf(values, header.value) < 0 {
+					values = append(values, header.value)
+				}
+			}
+		}
+
+		report.AddHeader(headerName, strings.Join(values, ", "))
+	}
+
+	report.AddHeader("Streams", strings.Join(names, ", "))
+
+	if len(failed) > 0 {
+		report.AddHeader("Failed Streams", strings.Join(failed, ", "))
+	}
+
+	report.defaultTable = combineTables(streamColumn, names, succeeded)
+
+	return report, nil
+}
+
+// combineTables combines the default tables of the reports into a single table, with a label column (e.g. stream)
+// before the columns of the report tables. The rows of each report are followed by a subtotal row, and the table ends
+// with a total row of all the reports. Subtotals are the sums of the report values, except for the rate columns which
+// are averaged, and the total rates are the sums of the average rates of the reports
+func combineTables(labelColumn string, labels []string, reports []*Report) *ResultSet {
+	columns := []string{labelColumn}
+	table := &ResultSet{columnsDataTypes: map[string]series.Type{labelColumn: series.String}}
+
+	for _, report := range reports {
+		for _, columnName := range report.GetDefaultTable().GetColumnsNames() {
+			if indexOf(columns, columnName) < 0 {
+				columns = append(columns, columnName)
+				table.columnsDataTypes[columnName] = report.GetDefaultTable().GetColumnsDataTypes()[columnName]
+			}
+		}
+	}
+
+	table.columnsNames = columns
+	records := [][]string{columns}
+	totals := make([]float64, len(columns))
+
+	for i, report := range reports {
+		subtotals := make([]float64, len(columns))
+		rows := tableRows(report.GetDefaultTable())
+
+		for _, row := range rows {
+			record := []string{labels[i]}
+
+			for j, columnName := range columns[1:] {
+				record = append(record, row[columnName])
+
+				if value, err := strconv.ParseFloat(row[columnName], 64); err == nil {
+					subtotals[j+1] += value
+				}
+			}
+
+			records = append(records, record)
+		}
+
+		record := []string{labels[i]}
+
+		for j, columnName := range columns[1:] {
+			switch {
+			case columnName == timeColumn:
+				record = append(record, subtotalRow)
+				continue
+			case !isNumericType(table.columnsDataTypes[columnName]):
+				record = append(record, "")
+				continue
+			case isRateColumn(columnName) && len(rows) > 0:
+				subtotals[j+1] = subtotals[j+1] / float64(len(rows))
+			}
+
+			totals[j+1] += subtotals[j+1]
+			record = append(record, formatFloat(subtotals[j+1]))
+		}
+
+		records = append(records, record)
+	}
+
+	record := []string{totalRow}
+
+	for j, columnName := range columns[1:] {
+		if isNumericType(table.columnsDataTypes[columnName]) {
+			record = append(record, formatFloat(totals[j+1]))
+		} else {
+			record = append(record, "")
+		}
+	}
+
+	records = append(records, record)
+
+	// Subtotals of integer columns are integers, but the averages of the rates are not
+	for _, columnName := range columns {
+		if isRateColumn(columnName) {
+			table.columnsDataTypes[columnName] = series.Float
+		}
+	}
+
+	table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+
+	return table
+}
+
+// isRateColumn returns true if the column is a rate column added by the rate option
+func isRateColumn(columnName string) bool {
+	for _, unit := range rateUnits {
+		if strings.HasSuffix(columnName, unit.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchedNodesTable returns the collectors and distributors matched by the nodes of the stream targets during the
+// report period, with the number of files they processed, in each logical server of the streams. Logical servers which
+// fail are logged, without blocking the others
+func matchedNodesTable(context *cli.Context, targets []*throughputTarget, bucket timeBucket) *ResultSet {
+	records := [][]string{matchedNodesColumns}
+	table := &ResultSet{columnsNames: matchedNodesColumns, columnsDataTypes: map[string]series.Type{}}
+
+	for _, columnName := range matchedNodesColumns {
+		table.columnsDataTypes[columnName] = series.String
+	}
+
+	table.columnsDataTypes["files"] = series.Int
+
+	for _, target := range targets {
+		period, err := resolveReportPeriod(context, target.cluster)
+
+		for _, server := range target.servers {
+			serverErr := err
+
+			if serverErr == nil {
+				serverErr = target.queryMatchedNodes(server, period, bucket, &records)
+			}
+
+			if serverErr != nil {
+				logger.WithFields(logrus.Fields{
+					"command":        "throughput",
+					"target":         target.name,
+					"logical_server": server.label(),
+					"error":          serverErr,
+				}).Error("Querying matched nodes")
+			}
+		}
+	}
+
+	if len(records) > 1 {
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(table.columnsDataTypes))
+	}
+
+	return table
+}
+
+// queryMatchedNodes executes the matched nodes query of the stream target in the logical server database, and adds
+// the matched nodes to the records
+func (t *throughputTarget) queryMatchedNodes(server *targetServer, period *reportPeriod, bucket timeBucket,
+	records *[][]string) error {
+
+	params := server.period(period).queryParameters(bucket)
+	t.stream.setNodesParameters(&params)
+
+	query, args := parseTemplate("matched-nodes", matchedNodesQueryTemplate, params)
+
+	logger.WithFields(logrus.Fields{
+		"command":        "throughput",
+		"target":         t.name,
+		"logical_server": server.logicalServer.Name,
+		"query":          query,
+		"args":           args,
+	}).Debug("Matched nodes query")
+
+	session := CreateSession(server.logicalServer)
+
+	if session == nil {
+		return fmt.Errorf("Cannot open session to %s logical server", server.logicalServer.Name)
+	}
+
+	report, err := session.executeQuery(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	for _, row := range tableRows(report.GetDefaultTable()) {
+		*records = append(*records, []string{t.stream.Name, server.label(), row["node_type"], row["node_name"],
+			row["node_id"], row["files"]})
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/go-gota/gota/series"
+	"github.com/jmoiron/sqlx"
+	"github.com/kniren/gota/dataframe"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingDriver is a database driver which cannot connect, so that every query of its sessions fails
+type failingDriver struct{}
+
+func (failingDriver) Open(name string) (driver.Conn, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func init() {
+	sql.Register("emmstats-failing", failingDriver{})
+}
+
+// failingLogicalServer returns a logical server with a session in the pool whose queries fail
+func failingLogicalServer(name string) *LogicalServer {
+	logicalServer := &LogicalServer{Name: name, IP: name + ".invalid"}
+	db, _ := sql.Open("emmstats-failing", "")
+
+	sessionsLock.Lock()
+	sessionsPool = append(sessionsPool, Session{logicalServer: logicalServer, Db: sqlx.NewDb(db, "postgres")})
+	sessionsLock.Unlock()
+
+	return logicalServer
+}
+
+func TestQueryServer_QueryFailure(t *testing.T) {
+	logicalServer := failingLogicalServer("Failing1")
+	target := newLogicalServerTarget(logicalServer, nil)
+	bucket, _ := chooseTimeBucket("hour")
+	period := &reportPeriod{start: time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC),
+		end: time.Date(2019, 3, 26, 0, 0, 0, 0, time.UTC)}
+
+	if report, err := target.queryServer(logicalServer, period, bucket); err == nil || report != nil {
+		t.Errorf("Expecting query error, but got %v (%v)", report, err)
+	}
+
+	var records [][]string
+	stream := &Stream{Name: "UAT_Test", CollectorNames: []string{"INPUT"}}
+	streamTarget := &throughputTarget{name: "UAT_Test Stream", stream: stream, logicalServer: logicalServer,
+		servers: []*targetServer{{logicalServer: logicalServer}}}
+
+	if err := streamTarget.queryMatchedNodes(streamTarget.servers[0], period, bucket, &records); err == nil {
+		t.Errorf("Expecting matched nodes query error, but got nil")
+	}
+}
+
+func TestFindStreams(t *testing.T) {
+	config := Config{Streams: []*Stream{
+		{Name: "UAT_Test"},
+		{Name: "4GLTE_INPUT_CDRs", Group: "4G"},
+		{Name: "HW_INPUT_CDRs"},
+		{Name: "HW_OUTPUT_CDRs"},
+	}}
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{"UAT_Test"}, []string{"UAT_Test"}},
+		{[]string{"*_INPUT_CDRs"}, []string{"4GLTE_INPUT_CDRs", "HW_INPUT_CDRs"}},
+		{[]string{"/^HW/"}, []string{"HW_INPUT_CDRs", "HW_OUTPUT_CDRs"}},
+		{[]string{"HW_OUTPUT_CDRs", "group:4G", "4GLTE_*"}, []string{"4GLTE_INPUT_CDRs", "HW_OUTPUT_CDRs"}},
+	}
+
+	for _, test := range tests {
+		var names []string
+
+		streams, err := config.FindStreams(test.patterns)
+
+		if err != nil {
+			t.Errorf("Expecting %v to match streams, but got %s", test.patterns, err)
+			continue
+		}
+
+		for _, stream := range streams {
+			names = append(names, stream.Name)
+		}
+
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Expecting %v, but got %v", test.expected, names)
+		}
+	}
+
+	for _, patterns := range [][]string{{"UAT_Test", "group:5G"}, {"/[/"}, {"[UAT"}} {
+		if _, err := config.FindStreams(patterns); err == nil {
+			t.Errorf("Expecting %v to fail, but got no error", patterns)
+		}
+	}
+}
+
+func TestCombineTables(t *testing.T) {
+	columnsTypes := map[string]series.Type{"time": series.String, "input_files": series.Int,
+		"input_files_per_sec": series.Float}
+
+	newReport := func(records [][]string) *Report {
+		table := &ResultSet{columnsNames: records[0], columnsDataTypes: columnsTypes}
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(columnsTypes))
+
+		return &Report{defaultTable: table}
+	}
+
+	reports := []*Report{
+		newReport([][]string{
+			{"time", "input_files", "input_files_per_sec"},
+			{"2019-03-25 10", "3600", "1"},
+			{"2019-03-25 11", "10800", "3"},
+		}),
+		newReport([][]string{
+			{"time", "input_files", "input_files_per_sec"},
+			{"2019-03-25 10", "7200", "2"},
+		}),
+	}
+
+	records := combineTables(streamColumn, []string{"UAT_Test", "HW_INPUT_CDRs"}, reports).data.Records()
+	expected := [][]string{
+		{"stream", "time", "input_files", "input_files_per_sec"},
+		{"UAT_Test", "2019-03-25 10", "3600", "1.000000"},
+		{"UAT_Test", "2019-03-25 11", "10800", "3.000000"},
+		{"UAT_Test", "subtotal", "14400", "2.000000"},
+		{"HW_INPUT_CDRs", "2019-03-25 10", "7200", "2.000000"},
+		{"HW_INPUT_CDRs", "subtotal", "7200", "2.000000"},
+		{"total", "", "21600", "4.000000"},
+	}
+
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+}
+
+func TestStream_AssignedLogicalServers(t *testing.T) {
+	stream := &Stream{
+		LogicalServer: &AssignedLogicalServer{Name: "Server1", Cluster: "ryd2"},
+		LogicalServers: []*AssignedLogicalServer{
+			{Name: "Server11", Cluster: "dev"},
+			{Name: "Server1", Cluster: "ryd2"},
+		},
+	}
+
+	var names []string
+
+	for _, server := range stream.AssignedLogicalServers() {
+		names = append(names, server.Name+"@"+server.Cluster)
+	}
+
+	expected := []string{"Server1@ryd2", "Server11@dev"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, names)
+	}
+
+	if servers := (&Stream{}).AssignedLogicalServers(); len(servers) != 0 {
+		t.Errorf("Expecting no logical servers, but got %v", servers)
+	}
+}
+
+func TestSumTablesByTime(t *testing.T) {
+	columnsTypes := map[string]series.Type{"time": series.String, "input_files": series.Int}
+
+	newTable := func(records [][]string) *ResultSet {
+		table := &ResultSet{columnsNames: records[0], columnsDataTypes: columnsTypes}
+		table.data = dataframe.LoadRecords(records, dataframe.WithTypes(columnsTypes))
+
+		return table
+	}
+
+	tables := []*ResultSet{
+		newTable([][]string{{"time", "input_files"}, {"2019-03-25 11", "20"}, {"2019-03-25 12", "5"}}),
+		newTable([][]string{{"time", "input_files"}, {"2019-03-25 10", "7"}, {"2019-03-25 11", "30"}}),
+	}
+
+	records := sumTablesByTime(tables).data.Records()
+	expected := [][]string{
+		{"time", "input_files"},
+		{"2019-03-25 10", "7"},
+		{"2019-03-25 11", "50"},
+		{"2019-03-25 12", "5"},
+	}
+
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, records)
+	}
+}
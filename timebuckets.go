@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// timeBucket describes the time interval used to group the result of the queries. It maps the PostgreSQL to_char
+// format used in the queries to the equivalent Go layout, so that the complete series of buckets can be generated on
+// the client side
+type timeBucket struct {
+	name     string
+	dbFormat string
+
+	// layout is the Go layout equivalent to dbFormat, it is empty for buckets labeled without layouts (week, quarter)
+	layout string
+
+	// interval is the width of custom interval buckets (e.g. 15m, 4h), it is zero for calendar buckets
+	interval time.Duration
+}
+
+// timeBuckets contains all the supported calendar group by periods
+var timeBuckets = map[string]timeBucket{
+	"year":    {name: "year", dbFormat: year, layout: "2006"},
+	"quarter": {name: "quarter", dbFormat: quarter},
+	"month":   {name: "month", dbFormat: month, layout: "200601"},
+	"week":    {name: "week", dbFormat: week},
+	"day":     {name: "day", dbFormat: day, layout: "20060102"},
+	"hour":    {name: "hour", dbFormat: hour, layout: "2006010215"},
+	"minute":  {name: "minute", dbFormat: minute, layout: "200601021504"},
+}
+
+// intervalExpression matches custom interval group by periods, in minutes or hours (e.g. 5m, 15m, 4h)
+var intervalExpression = regexp.MustCompile(`^(\d+)([mh])$`)
+
+// chooseTimeBucket returns the time bucket matching the group by period, it could be any of the calendar periods
+// (minute, hour, day, week, month, quarter, year), or a custom interval in minutes or hours (e.g. 15m, 4h)
+func chooseTimeBucket(groupByPeriod string) (timeBucket, error) {
+	if bucket, found := timeBuckets[groupByPeriod]; found {
+		return bucket, nil
+	}
+
+	match := intervalExpression.FindStringSubmatch(groupByPeriod)
+
+	if match == nil {
+		return timeBucket{}, fmt.Errorf("invalid group by period %s", groupByPeriod)
+	}
+
+	count, _ := strconv.Atoi(match[1])
+
+	if count == 0 {
+		return timeBucket{}, fmt.Errorf("invalid group by period %s", groupByPeriod)
+	}
+
+	if match[2] == "h" {
+		return timeBucket{name: groupByPeriod, dbFormat: hour, layout: "2006010215",
+			interval: time.Duration(count) * time.Hour}, nil
+	}
+
+	return timeBucket{name: groupByPeriod, dbFormat: minute, layout: "200601021504",
+		interval: time.Duration(count) * time.Minute}, nil
+}
+
+// truncate returns the start of the bucket which contains t
+func (b timeBucket) truncate(t time.Time) time.Time {
+	if b.interval > 0 {
+		// Custom intervals are aligned on the wall clock since the epoch, the same way the queries align them
+		seconds := int64(b.interval / time.Second)
+		wallClock := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Unix()
+		aligned := time.Unix(wallClock-wallClock%seconds, 0).UTC()
+
+		return time.Date(aligned.Year(), aligned.Month(), aligned.Day(), aligned.Hour(), aligned.Minute(), 0, 0,
+			t.Location())
+	}
+
+	switch b.name {
+	case "year":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	case "quarter":
+		return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case "week":
+		return startOfDay(t).AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	}
+
+	return startOfDay(t)
+}
+
+// next returns the start of the bucket following the bucket which starts at t
+func (b timeBucket) next(t time.Time) time.Time {
+	if b.interval > 0 {
+		// Custom intervals advance on the wall clock, the same way they are aligned, so that the buckets keep their
+		// labels across daylight saving time changes
+		wallClock := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).
+			Add(b.interval)
+		next := time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(), wallClock.Hour(), wallClock.Minute(),
+			wallClock.Second(), 0, t.Location())
+
+		// Wall clock times repeated when clocks move back could resolve to the same instant
+		if !next.After(t) {
+			return t.Add(b.interval)
+		}
+
+		return next
+	}
+
+	switch b.name {
+	case "year":
+		return t.AddDate(1, 0, 0)
+	case "quarter":
+		return t.AddDate(0, 3, 0)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "hour":
+		return t.Add(time.Hour)
+	case "minute":
+		return t.Add(time.Minute)
+	}
+
+	return t.AddDate(0, 0, 1)
+}
+
+// label returns the label of the bucket which contains t, formatted the same way the queries format the time column
+func (b timeBucket) label(t time.Time) string {
+	t = b.truncate(t)
+
+	switch {
+	case b.name == "week":
+		isoYear, isoWeek := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", isoYear, isoWeek)
+	case b.name == "quarter":
+		return fmt.Sprintf("%04d-Q%d", t.Year(), (t.Month()-1)/3+1)
+	}
+
+	return t.Format(b.layout)
+}
+
+// series returns the sorted labels of all the buckets between start (inclusive) and end (exclusive)
+func (b timeBucket) series(start time.Time, end time.Time) []string {
+	var labels []string
+
+	seen := map[string]bool{}
+
+	for t := b.truncate(start); t.Before(end); t = b.next(t) {
+		label := b.label(t)
+
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+
+	sort.Strings(labels)
+
+	return labels
+}
+
+// durations returns the duration of each bucket between start (inclusive) and end (exclusive) by bucket label. The first and
+// last buckets are clipped by start and end, and buckets repeated because of daylight saving time changes are summed
+func (b timeBucket) durations(start time.Time, end time.Time) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+
+	for t := b.truncate(start); t.Before(end); t = b.next(t) {
+		from, to := t, b.next(t)
+
+		if from.Before(start) {
+			from = start
+		}
+
+		if to.After(end) {
+			to = end
+		}
+
+		durations[b.label(t)] += to.Sub(from)
+	}
+
+	return durations
+}
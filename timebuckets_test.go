@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeBucket_Series(t *testing.T) {
+	start := time.Date(2019, 3, 25, 22, 30, 0, 0, time.UTC)
+	end := time.Date(2019, 3, 26, 1, 10, 0, 0, time.UTC)
+
+	bucket, _ := chooseTimeBucket("hour")
+	series := bucket.series(start, end)
+	expected := []string{"2019032522", "2019032523", "2019032600", "2019032601"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+
+	bucket, _ = chooseTimeBucket("month")
+	series = bucket.series(start, end)
+	expected = []string{"201903"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+
+	// End is exclusive, the bucket starting at the end of a named range is not part of the series
+	start, end, _ = parseRangeExpression("yesterday", time.Date(2019, 3, 27, 15, 30, 0, 0, time.UTC))
+
+	if series = bucket.series(start, end); !reflect.DeepEqual(series, []string{"201903"}) {
+		t.Errorf("Expecting a single month bucket, but got %v", series)
+	}
+
+	bucket, _ = chooseTimeBucket("hour")
+
+	if series = bucket.series(start, end); len(series) != 24 || series[23] != "2019032623" {
+		t.Errorf("Expecting the 24 hours of yesterday, but got %v", series)
+	}
+}
+
+func TestTimeBucket_SeriesDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("Time zone database is not available")
+	}
+
+	// Clocks move from 02:00 to 03:00 on 31 March 2019 in Berlin
+	start := time.Date(2019, 3, 31, 0, 0, 0, 0, berlin)
+	end := time.Date(2019, 3, 31, 4, 30, 0, 0, berlin)
+
+	bucket, _ := chooseTimeBucket("hour")
+	series := bucket.series(start, end)
+	expected := []string{"2019033100", "2019033101", "2019033103", "2019033104"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+}
+
+func TestTimeBucket_IntervalDaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("Time zone database is not available")
+	}
+
+	// Interval buckets keep their wall clock alignment when clocks move forward, and when they move back
+	bucket, _ := chooseTimeBucket("4h")
+
+	series := bucket.series(time.Date(2019, 3, 31, 0, 0, 0, 0, berlin), time.Date(2019, 3, 31, 12, 30, 0, 0, berlin))
+	expected := []string{"2019033100", "2019033104", "2019033108", "2019033112"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+
+	series = bucket.series(time.Date(2019, 10, 27, 0, 0, 0, 0, berlin), time.Date(2019, 10, 27, 12, 30, 0, 0, berlin))
+	expected = []string{"2019102700", "2019102704", "2019102708", "2019102712"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+}
+
+func TestTimeBucket_SeriesCalendarAndIntervals(t *testing.T) {
+	start := time.Date(2018, 12, 30, 23, 50, 0, 0, time.UTC)
+	end := time.Date(2019, 1, 7, 0, 20, 0, 0, time.UTC)
+
+	tests := map[string][]string{
+		"week":    {"2018-W52", "2019-W01", "2019-W02"},
+		"quarter": {"2018-Q4", "2019-Q1"},
+		"year":    {"2018", "2019"},
+	}
+
+	for groupBy, expected := range tests {
+		bucket, err := chooseTimeBucket(groupBy)
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", groupBy, err)
+		} else if series := bucket.series(start, end); !reflect.DeepEqual(series, expected) {
+			t.Errorf("Expecting %v for '%s', but got %v", expected, groupBy, series)
+		}
+	}
+
+	bucket, _ := chooseTimeBucket("15m")
+	series := bucket.series(time.Date(2019, 1, 7, 0, 10, 0, 0, time.UTC), time.Date(2019, 1, 7, 0, 40, 0, 0, time.UTC))
+	expected := []string{"201901070000", "201901070015", "201901070030"}
+
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, series)
+	}
+
+	for _, groupBy := range []string{"fortnight", "0m", "15s"} {
+		if _, err := chooseTimeBucket(groupBy); err == nil {
+			t.Errorf("Expecting error for '%s'", groupBy)
+		}
+	}
+}
+
+func TestTimeBucket_Durations(t *testing.T) {
+	start := time.Date(2019, 3, 25, 22, 30, 0, 0, time.UTC)
+	end := time.Date(2019, 3, 26, 0, 15, 0, 0, time.UTC)
+
+	bucket, _ := chooseTimeBucket("hour")
+	durations := bucket.durations(start, end)
+	expected := map[string]time.Duration{
+		"2019032522": 30 * time.Minute,
+		"2019032523": time.Hour,
+		"2019032600": 15 * time.Minute,
+	}
+
+	if !reflect.DeepEqual(durations, expected) {
+		t.Errorf("Expecting %v, but got %v", expected, durations)
+	}
+}
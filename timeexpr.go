@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRange is the time range of reports when no time options are specified
+	defaultRange = "last-24h"
+
+	// rangeSeparator separates the start and end time expressions of an explicit range (e.g. -7d..now)
+	rangeSeparator = ".."
+
+	// periodLabelFormat is the format of the start and end times displayed in report headers
+	periodLabelFormat = "2006-01-02 15:04:05"
+)
+
+// isoTimeFormats contains the ISO-8601 formats accepted in time expressions, formats without offset are parsed in the
+// report time zone
+var isoTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var (
+	// offsetExpression matches relative time expressions (e.g. -7d, -24h, -30m, -2w)
+	offsetExpression = regexp.MustCompile(`^([+-])(\d+)([mhdw])$`)
+
+	// lastExpression matches the named ranges ending at the current time (e.g. last-24h, last-7d)
+	lastExpression = regexp.MustCompile(`^last-(\d+)([mhdw])$`)
+)
+
+// parseTimeExpression parses a single point in time, it could be any of the below:
+// - Time in the format YYYYMMDDHH24MISS
+// - ISO-8601 date or date time (e.g. 2019-03-25, 2019-03-25T10:00:00, 2019-03-25T10:00:00+03:00)
+// - now, today or yesterday (the last two refer to the start of the day)
+// - Offset relative to the current time (e.g. -7d, -24h, -30m, -2w)
+func parseTimeExpression(expression string, now time.Time) (time.Time, error) {
+	expression = strings.TrimSpace(strings.ToLower(expression))
+	location := now.Location()
+
+	switch expression {
+	case "now":
+		return now, nil
+	case "today":
+		return startOfDay(now), nil
+	case "yesterday":
+		return startOfDay(now).AddDate(0, 0, -1), nil
+	}
+
+	if offset, err := parseTimeOffset(expression); err == nil {
+		return offset.apply(now), nil
+	}
+
+	if t, err := time.ParseInLocation(timeFlagFormat, expression, location); err == nil {
+		return t, nil
+	}
+
+	for _, format := range isoTimeFormats {
+		if t, err := time.ParseInLocation(format, strings.ToUpper(expression), location); err == nil {
+			return t.In(location), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time expression %s", expression)
+}
+
+// parseRangeExpression parses a time range, it could be an explicit range of two time expressions separated by ..
+// (e.g. -7d..now, 2019-03-01..2019-04-01), or one of the below named ranges. Ranges are half-open, they end before
+// the end time, so that named ranges end at the start of the next period:
+// - last-N[mhdw]: from N minutes, hours, days or weeks ago until now (e.g. last-24h, last-7d)
+// - today, yesterday
+// - this-week, last-week (ISO weeks starting on Monday)
+// - this-month, last-month
+// - this-year, last-year
+func parseRangeExpression(expression string, now time.Time) (time.Time, time.Time, error) {
+	expression = strings.TrimSpace(strings.ToLower(expression))
+
+	if parts := strings.Split(expression, rangeSeparator); len(parts) == 2 {
+		start, err := parseTimeExpression(parts[0], now)
+
+		if err != nil {
+			return start, start, err
+		}
+
+		end, err := parseTimeExpression(parts[1], now)
+
+		if err != nil {
+			return start, end, err
+		}
+
+		if end.Before(start) {
+			return start, end, fmt.Errorf("end of range %s is before its start", expression)
+		}
+
+		return start, end, nil
+	}
+
+	if match := lastExpression.FindStringSubmatch(expression); match != nil {
+		count, _ := strconv.Atoi(match[1])
+
+		return addUnits(now, -count, match[2]), now, nil
+	}
+
+	today := startOfDay(now)
+	thisWeek := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	thisYear := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+
+	switch expression {
+	case "today":
+		return today, now, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	case "this-week":
+		return thisWeek, now, nil
+	case "last-week":
+		return thisWeek.AddDate(0, 0, -7), thisWeek, nil
+	case "this-month":
+		return thisMonth, now, nil
+	case "last-month":
+		return thisMonth.AddDate(0, -1, 0), thisMonth, nil
+	case "this-year":
+		return thisYear, now, nil
+	case "last-year":
+		return thisYear.AddDate(-1, 0, 0), thisYear, nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid range expression %s", expression)
+}
+
+// timeOffset is a signed count of minutes, hours, days or weeks
+type timeOffset struct {
+	count int
+	unit  string
+}
+
+// parseTimeOffset parses a relative time expression (e.g. -7d, -24h, -30m, -2w)
+func parseTimeOffset(expression string) (timeOffset, error) {
+	match := offsetExpression.FindStringSubmatch(strings.TrimSpace(strings.ToLower(expression)))
+
+	if match == nil {
+		return timeOffset{}, fmt.Errorf("invalid time offset %s", expression)
+	}
+
+	count, _ := strconv.Atoi(match[2])
+
+	if match[1] == "-" {
+		count = -count
+	}
+
+	return timeOffset{count: count, unit: match[3]}, nil
+}
+
+// apply returns t shifted by the offset
+func (o timeOffset) apply(t time.Time) time.Time {
+	return addUnits(t, o.count, o.unit)
+}
+
+// startOfDay returns the midnight of the day of t in the location of t
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// addUnits adds count minutes (m), hours (h), days (d) or weeks (w) to t. Days and weeks are calendar days, so they
+// keep the time of day across daylight saving time changes
+func addUnits(t time.Time, count int, unit string) time.Time {
+	switch unit {
+	case "m":
+		return t.Add(time.Duration(count) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(count) * time.Hour)
+	case "w":
+		return t.AddDate(0, 0, 7*count)
+	}
+
+	return t.AddDate(0, 0, count)
+}
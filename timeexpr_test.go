@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeExpression(t *testing.T) {
+	now := time.Date(2019, 3, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"now":                       now,
+		"today":                     time.Date(2019, 3, 27, 0, 0, 0, 0, time.UTC),
+		"yesterday":                 time.Date(2019, 3, 26, 0, 0, 0, 0, time.UTC),
+		"-7d":                       time.Date(2019, 3, 20, 15, 30, 0, 0, time.UTC),
+		"-90m":                      time.Date(2019, 3, 27, 14, 0, 0, 0, time.UTC),
+		"20190325100000":            time.Date(2019, 3, 25, 10, 0, 0, 0, time.UTC),
+		"2019-03-25":                time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC),
+		"2019-03-25T10:15:00":       time.Date(2019, 3, 25, 10, 15, 0, 0, time.UTC),
+		"2019-03-25T10:15:00+03:00": time.Date(2019, 3, 25, 7, 15, 0, 0, time.UTC),
+	}
+
+	for expression, expected := range tests {
+		parsed, err := parseTimeExpression(expression, now)
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", expression, err)
+		} else if !parsed.Equal(expected) {
+			t.Errorf("Expecting %s for '%s', but got %s", expected, expression, parsed)
+		}
+	}
+
+	if _, err := parseTimeExpression("last tuesday", now); err == nil {
+		t.Errorf("Expecting error for 'last tuesday'")
+	}
+}
+
+func TestParseRangeExpression(t *testing.T) {
+	// Wednesday
+	now := time.Date(2019, 3, 27, 15, 30, 0, 0, time.UTC)
+
+	tests := map[string][2]time.Time{
+		"last-24h": {time.Date(2019, 3, 26, 15, 30, 0, 0, time.UTC), now},
+		"yesterday": {time.Date(2019, 3, 26, 0, 0, 0, 0, time.UTC),
+			time.Date(2019, 3, 27, 0, 0, 0, 0, time.UTC)},
+		"this-month": {time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC), now},
+		"last-week": {time.Date(2019, 3, 18, 0, 0, 0, 0, time.UTC),
+			time.Date(2019, 3, 25, 0, 0, 0, 0, time.UTC)},
+		"-7d..now": {time.Date(2019, 3, 20, 15, 30, 0, 0, time.UTC), now},
+	}
+
+	for expression, expected := range tests {
+		start, end, err := parseRangeExpression(expression, now)
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", expression, err)
+		} else if !start.Equal(expected[0]) || !end.Equal(expected[1]) {
+			t.Errorf("Expecting %s - %s for '%s', but got %s - %s", expected[0], expected[1], expression, start, end)
+		}
+	}
+
+	if _, _, err := parseRangeExpression("now..-7d", now); err == nil {
+		t.Errorf("Expecting error for 'now..-7d'")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	ctx "context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSSHPort is the port of the bastion hosts which do not specify it
+	defaultSSHPort = "22"
+
+	// defaultKnownHostsFile verifies the host keys of the bastions which do not specify the known hosts file
+	defaultKnownHostsFile = "~/.ssh/known_hosts"
+
+	// sshTunnelTimeout is the maximum time to connect to the bastion
+	sshTunnelTimeout = 30 * time.Second
+)
+
+// sshTunnels are the SSH tunnels opened by the sessions, one per cluster
+var sshTunnels = map[*SSHTunnelConfig]*sshTunnel{}
+
+// sshTunnelsLock protects the SSH tunnels, as sessions are opened concurrently
+var sshTunnelsLock sync.Mutex
+
+// sshTunnel dials the connections to the databases of a cluster through the SSH connection to its bastion. The SSH
+// connection is opened by the first dial, shared by the following ones, and opened again if the bastion closes it
+type sshTunnel struct {
+	config *SSHTunnelConfig
+	lock   sync.Mutex
+	client *ssh.Client
+}
+
+// findSSHTunnel returns the SSH tunnel of the configuration, it is created if it does not exist
+func findSSHTunnel(config *SSHTunnelConfig) *sshTunnel {
+	sshTunnelsLock.Lock()
+	defer sshTunnelsLock.Unlock()
+
+	if tunnel, found := sshTunnels[config]; found {
+		return tunnel
+	}
+
+	tunnel := &sshTunnel{config: config}
+	sshTunnels[config] = tunnel
+
+	return tunnel
+}
+
+// address returns the address of the bastion, with the default SSH port if not specified
+func (c *SSHTunnelConfig) address() string {
+	if _, _, err := net.SplitHostPort(c.Host); err == nil {
+		return c.Host
+	}
+
+	return net.JoinHostPort(c.Host, defaultSSHPort)
+}
+
+// clientConfig returns the SSH client configuration authenticating with the private key, and verifying the bastion
+// host key with the known hosts file
+func (c *SSHTunnelConfig) clientConfig() (*ssh.ClientConfig, error) {
+	if len(c.Host) == 0 || len(c.User) == 0 || len(c.Key) == 0 {
+		return nil, fmt.Errorf("host, user and key of the SSH tunnel are required")
+	}
+
+	key, err := ioutil.ReadFile(expandHome(c.Key))
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SSH key: %s", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse SSH key %s: %s", c.Key, err)
+	}
+
+	knownHostsFile := c.KnownHosts
+
+	if len(knownHostsFile) == 0 {
+		knownHostsFile = defaultKnownHostsFile
+	}
+
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsFile))
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SSH known hosts: %s", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTunnelTimeout,
+	}, nil
+}
+
+// connect returns the SSH connection to the bastion, it is opened if it is not opened yet or if it was closed
+func (t *sshTunnel) connect() (*ssh.Client, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	clientConfig, err := t.config.clientConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", t.config.address(), clientConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"bastion": t.config.address(),
+		"user":    t.config.User,
+	}).Debug("SSH tunnel opened")
+
+	t.client = client
+
+	go func() {
+		err := client.Wait()
+
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		if t.client == client {
+			t.client = nil
+		}
+
+		logger.WithFields(logrus.Fields{
+			"bastion": t.config.address(),
+			"error":   err,
+		}).Debug("SSH tunnel closed")
+	}()
+
+	return client, nil
+}
+
+// Dial connects to the address from the bastion
+func (t *sshTunnel) Dial(network, address string) (net.Conn, error) {
+	client, err := t.connect()
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot open SSH tunnel to %s: %s", t.config.address(), err)
+	}
+
+	return client.Dial(network, address)
+}
+
+// DialTimeout connects to the address from the bastion, the connection fails after the timeout
+func (t *sshTunnel) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	timeoutContext, cancel := ctx.WithTimeout(ctx.Background(), timeout)
+	defer cancel()
+
+	return t.DialContext(timeoutContext, network, address)
+}
+
+// DialContext connects to the address from the bastion, the connection fails when the context is done. SSH channels
+// cannot be cancelled, a connection established after the context is done is closed
+func (t *sshTunnel) DialContext(dialContext ctx.Context, network, address string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	results := make(chan dialResult, 1)
+
+	go func() {
+		conn, err := t.Dial(network, address)
+		results <- dialResult{conn, err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.conn, result.err
+	case <-dialContext.Done():
+		go func() {
+			if result := <-results; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+
+		return nil, dialContext.Err()
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// bastionStandIn is an in-process SSH server forwarding the direct-tcpip channels, it counts the SSH connections
+type bastionStandIn struct {
+	listener    net.Listener
+	connections int32
+}
+
+// startBastionStandIn starts the SSH server with the host key, accepting the client public key
+func startBastionStandIn(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) *bastionStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Expecting listener, but got %v", err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "emm" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+
+			return nil, io.EOF
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	bastion := &bastionStandIn{listener: listener}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go bastion.serve(conn, serverConfig)
+		}
+	}()
+
+	return bastion
+}
+
+// serve forwards the direct-tcpip channels of the SSH connection
+func (b *bastionStandIn) serve(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+
+	if err != nil {
+		return
+	}
+
+	atomic.AddInt32(&b.connections, 1)
+
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		var forward struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+
+		if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &forward) != nil {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(forward.Host, strconv.Itoa(int(forward.Port))))
+
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		channel, channelRequests, _ := newChannel.Accept()
+
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			io.Copy(channel, target)
+			channel.Close()
+		}()
+		go func() {
+			io.Copy(target, channel)
+			target.Close()
+		}()
+	}
+}
+
+// startEchoServer starts a TCP server echoing the data, it stands in for the database behind the bastion
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Expecting listener, but got %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func TestSSHTunnel_Dial(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "emmstats")
+	defer os.RemoveAll(dir)
+
+	_, hostPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	clientPublicKey, clientPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewSignerFromKey(hostPrivateKey)
+	clientKey, _ := ssh.NewPublicKey(clientPublicKey)
+
+	bastion := startBastionStandIn(t, hostKey, clientKey)
+	defer bastion.listener.Close()
+
+	database := startEchoServer(t)
+	defer database.Close()
+
+	keyBlock, _ := ssh.MarshalPrivateKey(clientPrivateKey, "")
+	ioutil.WriteFile(filepath.Join(dir, "id_ed25519"), pem.EncodeToMemory(keyBlock), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownhosts.Line(
+		[]string{knownhosts.Normalize(bastion.listener.Addr().String())}, hostKey.PublicKey())+"\n"), 0600)
+
+	config := &SSHTunnelConfig{Host: bastion.listener.Addr().String(), User: "emm",
+		Key: filepath.Join(dir, "id_ed25519"), KnownHosts: filepath.Join(dir, "known_hosts")}
+
+	var wait sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wait.Add(1)
+
+		go func(message string) {
+			defer wait.Done()
+
+			conn, err := findSSHTunnel(config).Dial("tcp", database.Addr().String())
+
+			if err != nil {
+				t.Errorf("Expecting connection through the tunnel, but got %v", err)
+				return
+			}
+
+			defer conn.Close()
+
+			conn.Write([]byte(message))
+			echo := make([]byte, len(message))
+
+			if _, err = io.ReadFull(conn, echo); err != nil || string(echo) != message {
+				t.Errorf("Expecting echo %s, but got %s (%v)", message, echo, err)
+			}
+		}("message " + strconv.Itoa(i))
+	}
+
+	wait.Wait()
+
+	if connections := atomic.LoadInt32(&bastion.connections); connections != 1 {
+		t.Errorf("Expecting one shared SSH connection, but got %d", connections)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "other_known_hosts"), []byte(knownhosts.Line(
+		[]string{knownhosts.Normalize(bastion.listener.Addr().String())}, clientKey)+"\n"), 0600)
+
+	untrusted := &SSHTunnelConfig{Host: config.Host, User: "emm", Key: config.Key,
+		KnownHosts: filepath.Join(dir, "other_known_hosts")}
+
+	if _, err := findSSHTunnel(untrusted).Dial("tcp", database.Addr().String()); err == nil {
+		t.Errorf("Expecting unknown bastion host key to be rejected")
+	}
+}
+
+func TestSSHTunnelConfig_Address(t *testing.T) {
+	if address := (&SSHTunnelConfig{Host: "bastion.example.com"}).address(); address != "bastion.example.com:22" {
+		t.Errorf("Expecting default SSH port, but got %s", address)
+	}
+
+	if address := (&SSHTunnelConfig{Host: "10.135.3.1:2222"}).address(); address != "10.135.3.1:2222" {
+		t.Errorf("Expecting specified SSH port, but got %s", address)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"html"
+	"io"
+	"strings"
+)
+
+// supportedFormat checks if the report can be written in the output format
+func supportedFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case txtFileFormat, csvFileFormat, mdFileFormat, htmlFileFormat:
+		return true
+	}
+
+	return false
+}
+
+// markdownCell escapes the characters of a field which would break the Markdown table row
+func markdownCell(field string) string {
+	field = strings.Replace(field, "\\", "\\\\", -1)
+	field = strings.Replace(field, "|", "\\|", -1)
+
+	return strings.Replace(field, "\n", " ", -1)
+}
+
+// markdownRow joins the escaped fields as a Markdown table row
+func markdownRow(fields []string) string {
+	cells := make([]string, len(fields))
+
+	for i, field := range fields {
+		cells[i] = markdownCell(field)
+	}
+
+	return fmt.Sprintf("| %s |\n", strings.Join(cells, " | "))
+}
+
+// Write writes the report header and the tables to the writer in the output format. Numbers are formatted in human
+// readable mode if the number format is specified, except in CSV format which always contains the raw values
+func (r *Report) Write(writer io.Writer, format string, numbers *numberFormat, tables ...*ResultSet) error {
+	switch strings.ToLower(format) {
+	case txtFileFormat:
+		r.WriteHeader(writer)
+
+		for _, table := range tables {
+			fmt.Fprintf(writer, "\n")
+			table.renderTable(tablewriter.NewWriter(writer), numbers)
+		}
+	case mdFileFormat:
+		r.writeMarkdown(writer, numbers, tables)
+	case htmlFileFormat:
+		r.writeHTML(writer, numbers, tables)
+	case csvFileFormat:
+		return r.writeCSV(writer, tables)
+	default:
+		return fmt.Errorf("unsupported output format %s", format)
+	}
+
+	return nil
+}
+
+// writeMarkdown writes the report header as a list, and the tables as Markdown tables
+func (r *Report) writeMarkdown(writer io.Writer, numbers *numberFormat, tables []*ResultSet) {
+	if len(r.name) > 0 {
+		fmt.Fprintf(writer, "# %s\n\n", r.name)
+	}
+
+	for _, header := range r.headers {
+		fmt.Fprintf(writer, "- **%s**: %s\n", header.name, header.value)
+	}
+
+	for _, table := range tables {
+		columns := table.GetColumnsNames()
+		separators := make([]string, len(columns))
+
+		for i := range separators {
+			separators[i] = "---"
+		}
+
+		fmt.Fprintf(writer, "\n%s", markdownRow(columns))
+		fmt.Fprintf(writer, "| %s |\n", strings.Join(separators, " | "))
+
+		for _, record := range table.displayRecords(numbers) {
+			fmt.Fprint(writer, markdownRow(record))
+		}
+
+		for _, caption := range table.captions() {
+			fmt.Fprintf(writer, "\n%s\n", strings.Replace(caption, "*", "\\*", -1))
+		}
+	}
+}
+
+// writeHTML writes the report as a standalone HTML document, rows of empty time buckets have the gap class
+func (r *Report) writeHTML(writer io.Writer, numbers *numberFormat, tables []*ResultSet) {
+	fmt.Fprintf(writer, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n",
+		html.EscapeString(r.name))
+	fmt.Fprintf(writer, "<style>table{border-collapse:collapse;margin-bottom:1em}th,td{border:1px solid #999;"+
+		"padding:2px 6px;text-align:right}tr.gap{background:#fdd}td.highlight{font-weight:bold;color:#c00}</style>\n</head>\n<body>\n")
+
+	if len(r.name) > 0 {
+		fmt.Fprintf(writer, "<h1>%s</h1>\n", html.EscapeString(r.name))
+	}
+
+	if len(r.headers) > 0 {
+		fmt.Fprintf(writer, "<ul>\n")
+
+		for _, header := range r.headers {
+			fmt.Fprintf(writer, "<li><b>%s</b>: %s</li>\n", html.EscapeString(header.name),
+				html.EscapeString(header.value))
+		}
+
+		fmt.Fprintf(writer, "</ul>\n")
+	}
+
+	for _, table := range tables {
+		fmt.Fprintf(writer, "<table>\n<tr>")
+
+		for _, columnName := range table.GetColumnsNames() {
+			fmt.Fprintf(writer, "<th>%s</th>", html.EscapeString(columnName))
+		}
+
+		fmt.Fprintf(writer, "</tr>\n")
+
+		for rowIndex, record := range table.displayRecords(numbers) {
+			if table.gapRows[rowIndex] {
+				fmt.Fprintf(writer, "<tr class=\"gap\">")
+			} else {
+				fmt.Fprintf(writer, "<tr>")
+			}
+
+			for columnIndex, field := range record {
+				if table.highlights[cell{row: rowIndex, column: columnIndex}] {
+					fmt.Fprintf(writer, "<td class=\"highlight\">%s</td>", html.EscapeString(field))
+				} else {
+					fmt.Fprintf(writer, "<td>%s</td>", html.EscapeString(field))
+				}
+			}
+
+			fmt.Fprintf(writer, "</tr>\n")
+		}
+
+		fmt.Fprintf(writer, "</table>\n")
+
+		for _, caption := range table.captions() {
+			fmt.Fprintf(writer, "<p>%s</p>\n", html.EscapeString(caption))
+		}
+	}
+
+	fmt.Fprintf(writer, "</body>\n</html>\n")
+}
+
+// writeCSV writes the raw records of the tables, tables are separated by empty lines
+func (r *Report) writeCSV(writer io.Writer, tables []*ResultSet) error {
+	for i, table := range tables {
+		if i > 0 {
+			fmt.Fprintf(writer, "\n")
+		}
+
+		w := csv.NewWriter(writer)
+
+		for _, record := range table.records() {
+			w.Write(record)
+		}
+
+		w.Flush()
+
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
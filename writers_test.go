@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"github.com/go-gota/gota/series"
+	"github.com/kniren/gota/dataframe"
+	"gopkg.in/urfave/cli.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport_Write(t *testing.T) {
+	report := Report{name: "Test Throughput", defaultTable: &ResultSet{
+		columnsNames:     []string{"time", "input_bytes"},
+		columnsDataTypes: map[string]series.Type{"time": series.String, "input_bytes": series.Int},
+		gapRows:          map[int]bool{1: true},
+	}}
+
+	report.defaultTable.data = dataframe.LoadRecords([][]string{
+		{"time", "input_bytes"},
+		{"20190325", "2000"},
+		{"20190326", "0"},
+	}, dataframe.WithTypes(report.defaultTable.columnsDataTypes))
+
+	report.AddHeader("Time Zone", "UTC")
+
+	var output bytes.Buffer
+	numbers, _ := chooseNumberFormat("en")
+
+	if err := report.Write(&output, mdFileFormat, numbers, report.GetDefaultTable()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := "# Test Throughput\n\n" +
+		"- **Time Zone**: UTC\n\n" +
+		"| time | input_bytes |\n" +
+		"| --- | --- |\n" +
+		"| 20190325 | 2.00 kB |\n" +
+		"| 20190326 * | 0 B |\n\n" +
+		"\\* No data received in the time bucket\n"
+
+	if output.String() != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, output.String())
+	}
+
+	output.Reset()
+
+	if err := report.Write(&output, csvFileFormat, numbers, report.GetDefaultTable()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if expected = "time,input_bytes\n20190325,2000\n20190326,0\n"; output.String() != expected {
+		t.Errorf("Expecting '%s', but got '%s'", expected, output.String())
+	}
+}
+
+func TestMarkdownRow(t *testing.T) {
+	if row := markdownRow([]string{"toHW|in", "a\\b", "1"}); row != "| toHW\\|in | a\\\\b | 1 |\n" {
+		t.Errorf("Expecting escaped Markdown row, but got '%s'", row)
+	}
+}
+
+func TestSupportedFormat(t *testing.T) {
+	for format, expected := range map[string]bool{"txt": true, "CSV": true, "md": true, "html": true, "xls": false, "pdf": false} {
+		if supportedFormat(format) != expected {
+			t.Errorf("Expecting %t for format %s", expected, format)
+		}
+	}
+}
+
+func TestInitializeAndValidateGFlags_DeprecatedXLS(t *testing.T) {
+	context := newTestContext(&cli.Command{Name: "throughput"}, ioutil.Discard, "--format", "xls", "--config-file",
+		filepath.Join(os.TempDir(), "emmstats-missing-config.yaml"))
+
+	if err := initializeAndValidateGFlags(context); err != nil {
+		t.Fatalf("Expecting deprecated xls format to be accepted, but got %s", err)
+	}
+
+	if format := context.String("format"); format != csvFileFormat {
+		t.Errorf("Expecting xls format to be written as csv, but got %s", format)
+	}
+}